@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"glox/cover"
+	"glox/runtime"
+)
+
+// runCover reports the coverage written by -coverage, given
+// as the argument of `glox cover`, as text or as HTML.
+func runCover(args []string) {
+	flags := flag.NewFlagSet("cover", flag.ExitOnError)
+	html := flags.String("html", "", "write an HTML view of the covered source to this file instead")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: glox cover [-html out.html] coverage.json")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err := coverReport(flags.Arg(0), *html); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func coverReport(profile, html string) error {
+	in, err := os.Open(profile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	files, err := runtime.ReadCoverage(in)
+	if err != nil {
+		return fmt.Errorf("%s: %s", profile, err)
+	}
+	// Show the files under the working directory relative to it.
+	if wd, err := os.Getwd(); err == nil {
+		for _, f := range files {
+			if rel, err := filepath.Rel(wd, f.Path); err == nil && !strings.HasPrefix(rel, "..") {
+				f.Path = rel
+			}
+		}
+	}
+	if html == "" {
+		return cover.Report(os.Stdout, files)
+	}
+	out, err := os.Create(html)
+	if err != nil {
+		return err
+	}
+	if err := cover.HTML(out, files); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// saveCoverage writes the coverage recorded by the programs
+// run to the file given to -coverage.
+func saveCoverage(cov *runtime.Coverage, path string) {
+	out, err := os.Create(path)
+	if err == nil {
+		err = cov.WriteJSON(out)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"glox/format"
+)
+
+// runFmt formats lox files, given as the arguments of `glox fmt`,
+// printing them to stdout unless -w or -check is set.
+func runFmt(args []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the formatted source back to the files")
+	check := flags.Bool("check", false, "list the files that aren't formatted and exit 1 if there are any")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: glox fmt [-w] [-check] files or directories...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range flags.Args() {
+		files, err := loxFiles(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+			continue
+		}
+		for _, file := range files {
+			switch err := fmtFile(file, *write, *check); err {
+			case nil:
+			case errUnformatted:
+				fmt.Println(file)
+				failed = true
+			default:
+				fmt.Fprintf(os.Stderr, "%s: %s\n", file, strings.TrimSpace(err.Error()))
+				failed = true
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// errUnformatted is reported by -check for files that need formatting.
+var errUnformatted = errors.New("not formatted")
+
+func fmtFile(file string, write, check bool) error {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	out, err := format.Source(src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case check:
+		if !bytes.Equal(src, out) {
+			return errUnformatted
+		}
+	case write:
+		if !bytes.Equal(src, out) {
+			return os.WriteFile(file, out, 0o644)
+		}
+	default:
+		os.Stdout.Write(out)
+	}
+	return nil
+}
+
+// loxFiles returns path if it's a file, or the .lx files
+// under it if it's a directory.
+func loxFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	var files []string
+	err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && filepath.Ext(file) == ".lx" {
+			files = append(files, file)
+		}
+		return nil
+	})
+	return files, err
+}
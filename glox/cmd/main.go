@@ -2,10 +2,17 @@ package main
 
 import (
 	_ "embed"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 
+	"glox/dap"
+	"glox/debugger"
+	"glox/loxtest"
+	"glox/lsp"
 	"glox/runtime"
+	"glox/vm"
 )
 
 //go:embed logo.txt
@@ -14,26 +21,167 @@ var logo string
 //go:embed version.txt
 var version string
 
+var (
+	useVM    = flag.Bool("vm", false, "run programs on the bytecode VM instead of the tree walker")
+	noColor  = flag.Bool("no-color", false, "don't use colors in error messages")
+	paths    = flag.String("path", "", "directories to search for imported modules, separated by '"+string(filepath.ListSeparator)+"'")
+	allow    = flag.String("allow", "", "capabilities granted to programs, separated by ',' (io.fs, os.env, os.exec, net, time or all), none by default")
+	covFile  = flag.String("coverage", "", "write the coverage of the lox files run to this file, as JSON, see 'glox cover'")
+	profFile = flag.String("profile", "", "write a pprof profile of the lox code run to this file, and print a report of it to stderr")
+	trace    = flag.String("trace", "", "write a JSON line for each statement and expression evaluated to this file, or to stderr if it's '-'")
+)
+
 func main() {
-	l := len(os.Args)
-	lox := runtime.NewLoxInterpreter()
-	if l == 1 {
-		interactiveShell(lox)
-	} else if l == 2 {
-		runFromFile(lox, os.Args[1])
+	flag.Parse()
+	caps, err := runtime.ParseCapabilities(*allow)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	var cov *runtime.Coverage
+	if *covFile != "" {
+		if *useVM {
+			fmt.Fprintln(os.Stderr, "-coverage needs the tree walker, it can't be used with -vm")
+			os.Exit(2)
+		}
+		cov = runtime.NewCoverage()
+	}
+	var prof *runtime.Profiler
+	if *profFile != "" {
+		if *useVM {
+			fmt.Fprintln(os.Stderr, "-profile needs the tree walker, it can't be used with -vm")
+			os.Exit(2)
+		}
+		prof = runtime.NewProfiler(nil)
+	}
+	var tracer *runtime.Tracer
+	if *trace != "" {
+		if *useVM {
+			fmt.Fprintln(os.Stderr, "-trace needs the tree walker, it can't be used with -vm")
+			os.Exit(2)
+		}
+		tracer = newTracer(*trace)
+	}
+	// exit writes what -coverage and -profile recorded, reports
+	// whether -trace failed to write, and exits.
+	exit := func(code int) {
+		if tracer != nil && tracer.Err() != nil {
+			fmt.Fprintln(os.Stderr, tracer.Err())
+			code = 1
+		}
+		if cov != nil {
+			saveCoverage(cov, *covFile)
+		}
+		if prof != nil {
+			saveProfile(prof, *profFile)
+		}
+		os.Exit(code)
+	}
+	newLox := func() *runtime.Lox {
+		lox := runtime.NewLoxInterpreter()
+		if *useVM {
+			lox.Backend = vm.New()
+		}
+		lox.Paths = filepath.SplitList(*paths)
+		lox.Capabilities = caps
+		lox.Color = !*noColor && os.Getenv("NO_COLOR") == ""
+		lox.Coverage = cov
+		lox.Profiler = prof
+		lox.Tracer = tracer
+		return lox
+	}
+	l := flag.NArg()
+	if l > 0 && flag.Arg(0) == "fmt" {
+		runFmt(flag.Args()[1:])
+	} else if l > 0 && flag.Arg(0) == "cover" {
+		runCover(flag.Args()[1:])
+	} else if flag.Arg(0) == "lsp" && l == 1 {
+		runLSP()
+	} else if flag.Arg(0) == "dap" && l == 1 {
+		runDAP(newLox)
+	} else if flag.Arg(0) == "debug" && l == 2 {
+		runDebugger(newLox(), flag.Arg(1))
+	} else if l == 0 {
+		interactiveShell(newLox())
+	} else if flag.Arg(0) == "test" && l <= 2 {
+		exit(runTests(newLox, flag.Arg(1)))
+	} else if l == 1 {
+		exit(runFromFile(newLox(), flag.Arg(0)))
 	} else {
-		fmt.Println("Usage: glox [filename]")
+		fmt.Println("Usage: glox [-vm] [-no-color] [-path dirs] [-allow caps] [-coverage file] [-profile file] [-trace file] [filename]")
+		fmt.Println("       glox [flags] test [dir]")
+		fmt.Println("       glox [flags] debug filename")
+		fmt.Println("       glox fmt [-w] [-check] files...")
+		fmt.Println("       glox cover [-html out.html] coverage.json")
+		fmt.Println("       glox lsp")
+		fmt.Println("       glox [flags] dap")
 		os.Exit(2)
 	}
 }
 
-func runFromFile(l *runtime.Lox, fname string) {
-	data, err := os.ReadFile(fname)
+// newTracer returns a tracer writing to the file given to -trace.
+func newTracer(path string) *runtime.Tracer {
+	if path == "-" {
+		return runtime.NewTracer(os.Stderr)
+	}
+	out, err := os.Create(path)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	_, loxError := l.Run(string(data))
-	if loxError != nil {
+	return runtime.NewTracer(out)
+}
+
+// runFromFile runs a file, returning the status to exit with.
+func runFromFile(l *runtime.Lox, fname string) int {
+	if _, err := l.RunFile(fname); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// runTests runs the *_test.lx files under dir, the working
+// directory by default, returning 1 if any fail.
+func runTests(newLox func() *runtime.Lox, dir string) int {
+	if dir == "" {
+		dir = "."
+	}
+	runner := &loxtest.Runner{NewLox: newLox, Out: os.Stdout}
+	sum, err := runner.Run(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if sum.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runLSP serves the Language Server Protocol over stdio
+// until the editor exits.
+func runLSP() {
+	if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// runDebugger runs a file under the step debugger,
+// reading its commands from stdin.
+func runDebugger(l *runtime.Lox, fname string) {
+	d := debugger.New(os.Stdin, os.Stdout)
+	d.Prompt = "(glox) "
+	if err := d.Run(l, fname); err != nil {
+		os.Exit(1)
+	}
+}
+
+// runDAP serves the Debug Adapter Protocol over
+// stdio until the editor disconnects.
+func runDAP(newLox func() *runtime.Lox) {
+	if err := dap.Serve(os.Stdin, os.Stdout, newLox); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"glox/profile"
+	"glox/runtime"
+)
+
+// saveProfile writes the profile of the lox code run to the file given
+// to -profile, and reports it to stderr with the 20 longest running lines.
+func saveProfile(prof *runtime.Profiler, path string) {
+	prof.Stop()
+	out, err := os.Create(path)
+	if err == nil {
+		err = profile.WritePprof(out, prof)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err == nil {
+		err = profile.Report(os.Stderr, prof, 20)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Package cover reports the coverage of lox files recorded by a
+runtime.Coverage, either as text listing what wasn't executed in each
+file, or as an HTML page of their source with lines highlighted by
+whether they were executed.
+*/
+package cover
+
+import (
+	"fmt"
+	"glox/runtime"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Summary counts the statements and branches of files, and
+// how many of them were executed. Each branch of an if statement
+// or of a loop counts twice, once for each way it can go.
+type Summary struct {
+	Statements, StatementsRun int
+	Branches, BranchesTaken   int
+}
+
+// Summarize counts the statements and branches of files.
+func Summarize(files ...*runtime.FileCoverage) Summary {
+	var sum Summary
+	for _, f := range files {
+		for _, s := range f.Statements {
+			sum.Statements++
+			if s.Count > 0 {
+				sum.StatementsRun++
+			}
+		}
+		for _, b := range f.Branches {
+			for _, n := range b.Counts {
+				sum.Branches++
+				if n > 0 {
+					sum.BranchesTaken++
+				}
+			}
+		}
+	}
+	return sum
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("%d/%d statements (%s)\t%d/%d branches (%s)",
+		s.StatementsRun, s.Statements, percent(s.StatementsRun, s.Statements),
+		s.BranchesTaken, s.Branches, percent(s.BranchesTaken, s.Branches))
+}
+
+func percent(n, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+// The ways each kind of branch can go, when its
+// condition is truthy and when it's falsy.
+var ways = map[string][2]string{
+	"if":    {"then", "else"},
+	"while": {"body", "exit"},
+	"for":   {"body", "exit"},
+}
+
+// Report writes a summary of each file's coverage to w, followed by
+// the lines with statements that weren't executed and the branches
+// that were never taken, then a summary of all of them.
+func Report(w io.Writer, files []*runtime.FileCoverage) error {
+	var sb strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&sb, "%s\t%s\n", f.Path, Summarize(f))
+		lines := lineStatuses(f)
+		bi := 0
+		for _, l := range sortedLines(lines) {
+			switch lines[l] {
+			case missed:
+				fmt.Fprintf(&sb, "\t%d\tnot executed\n", l)
+			case partial:
+				fmt.Fprintf(&sb, "\t%d\tpartly executed\n", l)
+			}
+			for ; bi < len(f.Branches) && f.Branches[bi].Line <= l; bi++ {
+				b := f.Branches[bi]
+				for i, n := range b.Counts {
+					if n == 0 {
+						fmt.Fprintf(&sb, "\t%d\t%s: %s never taken\n", b.Line, b.Kind, ways[b.Kind][i])
+					}
+				}
+			}
+		}
+	}
+	fmt.Fprintf(&sb, "total\t%s\n", Summarize(files...))
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+type status int
+
+const (
+	// The line has no statements.
+	none status = iota
+	run
+	// Some statements of the line were executed, but not all.
+	partial
+	missed
+)
+
+// lineStatuses returns the status of each line with statements.
+func lineStatuses(f *runtime.FileCoverage) map[int]status {
+	lines := make(map[int]status)
+	for _, s := range f.Statements {
+		st := run
+		if s.Count == 0 {
+			st = missed
+		}
+		if prev, ok := lines[s.Line]; ok && prev != st {
+			st = partial
+		}
+		lines[s.Line] = st
+	}
+	return lines
+}
+
+func sortedLines(lines map[int]status) []int {
+	var sorted []int
+	for l := range lines {
+		sorted = append(sorted, l)
+	}
+	sort.Ints(sorted)
+	return sorted
+}
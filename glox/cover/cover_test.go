@@ -0,0 +1,70 @@
+package cover
+
+import (
+	"bytes"
+	"glox/runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sign is the coverage of testdata/sign.lx.
+var sign = &runtime.FileCoverage{
+	Path: "testdata/sign.lx",
+	Statements: []*runtime.StatementCoverage{
+		{Line: 1, Column: 1, Count: 1},
+		{Line: 2, Column: 2, Count: 1},
+		{Line: 2, Column: 13, Count: 0},
+		{Line: 3, Column: 2, Count: 1},
+		{Line: 6, Column: 1, Count: 1},
+	},
+	Branches: []*runtime.BranchCoverage{
+		{Line: 2, Column: 2, Kind: "if", Counts: [2]int{0, 1}},
+	},
+}
+
+var loop = &runtime.FileCoverage{
+	Path: "loop.lx",
+	Statements: []*runtime.StatementCoverage{
+		{Line: 1, Column: 1, Count: 0},
+		{Line: 2, Column: 2, Count: 0},
+	},
+	Branches: []*runtime.BranchCoverage{
+		{Line: 1, Column: 1, Kind: "for", Counts: [2]int{0, 0}},
+	},
+}
+
+func TestSummarize(t *testing.T) {
+	assert.Equal(t, Summary{Statements: 5, StatementsRun: 4, Branches: 2, BranchesTaken: 1}, Summarize(sign))
+	assert.Equal(t, Summary{Statements: 7, StatementsRun: 4, Branches: 4, BranchesTaken: 1}, Summarize(sign, loop))
+	assert.Equal(t, "0/0 statements (-)\t0/0 branches (-)", Summarize().String())
+}
+
+func TestReport(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, Report(&out, []*runtime.FileCoverage{loop, sign}))
+	assert.Equal(t, `loop.lx	0/2 statements (0.0%)	0/2 branches (0.0%)
+	1	not executed
+	1	for: body never taken
+	1	for: exit never taken
+	2	not executed
+testdata/sign.lx	4/5 statements (80.0%)	1/2 branches (50.0%)
+	2	partly executed
+	2	if: then never taken
+total	4/7 statements (57.1%)	1/4 branches (25.0%)
+`, out.String())
+}
+
+func TestHTML(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, HTML(&out, []*runtime.FileCoverage{sign}))
+	html := out.String()
+	assert.Contains(t, html, "<p>Total: 4/5 statements (80.0%)\t1/2 branches (50.0%)</p>")
+	assert.Contains(t, html, `<tr class="run"><td class="n">1</td><td class="n">1x</td><td class="src">fun sign(n) {</td></tr>`)
+	assert.Contains(t, html, `<tr class="partial"><td class="n">2</td><td class="n">1x</td><td class="src" title="never taken: if then">    if (n &lt; 0) return -1;</td></tr>`)
+	assert.Contains(t, html, `<tr class=""><td class="n">4</td><td class="n"></td><td class="src">}</td></tr>`)
+
+	err := HTML(&out, []*runtime.FileCoverage{loop})
+	assert.Error(t, err)
+}
@@ -0,0 +1,104 @@
+package cover
+
+import (
+	"glox/runtime"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+)
+
+type htmlFile struct {
+	Path    string
+	Summary Summary
+	Lines   []htmlLine
+}
+
+type htmlLine struct {
+	Number int
+	Text   string
+	// Class highlights the line: "run", "partial", "missed",
+	// or empty for lines without statements.
+	Class string
+	// Count is the times the line's first statement was executed.
+	Count int
+	// Branches are the ways of the line's branches never taken.
+	Branches []string
+}
+
+var page = template.Must(template.New("cover").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>glox coverage</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; font-family: monospace; white-space: pre; }
+td { padding: 0 0.5em; }
+td.n { color: #888; text-align: right; }
+tr.run td.src { background: #d4f4d4; }
+tr.partial td.src { background: #f8f0c0; }
+tr.missed td.src { background: #f8d0d0; }
+</style>
+</head>
+<body>
+<p>Total: {{.Total}}</p>
+{{range .Files}}
+<h2>{{.Path}}</h2>
+<p>{{.Summary}}</p>
+<table>
+{{range .Lines}}<tr class="{{.Class}}"><td class="n">{{.Number}}</td><td class="n">{{if .Class}}{{.Count}}x{{end}}</td><td class="src"{{if .Branches}} title="never taken: {{range $i, $b := .Branches}}{{if $i}}, {{end}}{{$b}}{{end}}"{{end}}>{{.Text}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// HTML writes a page to w showing the source of each file, read from
+// its path, with lines highlighted by whether they were executed.
+func HTML(w io.Writer, files []*runtime.FileCoverage) error {
+	data := struct {
+		Total Summary
+		Files []htmlFile
+	}{Total: Summarize(files...)}
+	for _, f := range files {
+		source, err := os.ReadFile(f.Path)
+		if err != nil {
+			return err
+		}
+		data.Files = append(data.Files, htmlFile{Path: f.Path, Summary: Summarize(f), Lines: htmlLines(f, string(source))})
+	}
+	return page.Execute(w, data)
+}
+
+func htmlLines(f *runtime.FileCoverage, source string) []htmlLine {
+	statuses := lineStatuses(f)
+	text := strings.Split(strings.TrimSuffix(source, "\n"), "\n")
+	lines := make([]htmlLine, len(text))
+	for i, t := range text {
+		lines[i] = htmlLine{Number: i + 1, Text: strings.ReplaceAll(t, "\t", "    ")}
+		lines[i].Class = [...]string{none: "", run: "run", partial: "partial", missed: "missed"}[statuses[i+1]]
+	}
+	counted := make(map[int]bool)
+	for _, s := range f.Statements {
+		if s.Line <= len(lines) && !counted[s.Line] {
+			counted[s.Line] = true
+			lines[s.Line-1].Count = s.Count
+		}
+	}
+	for _, b := range f.Branches {
+		if b.Line > len(lines) {
+			continue
+		}
+		l := &lines[b.Line-1]
+		for i, n := range b.Counts {
+			if n == 0 {
+				l.Branches = append(l.Branches, b.Kind+" "+ways[b.Kind][i])
+				if l.Class == "run" {
+					l.Class = "partial"
+				}
+			}
+		}
+	}
+	return lines
+}
@@ -0,0 +1,104 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+// request is sent by the client, which the
+// server answers with a response.
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type response struct {
+	Seq        int    `json:"seq"`
+	Type       string `json:"type"`
+	RequestSeq int    `json:"request_seq"`
+	Success    bool   `json:"success"`
+	Command    string `json:"command"`
+	// Message is the error of a failed request.
+	Message string `json:"message,omitempty"`
+	Body    any    `json:"body,omitempty"`
+}
+
+// event is sent by the server when the state of the program changes.
+type event struct {
+	Seq   int    `json:"seq"`
+	Type  string `json:"type"`
+	Event string `json:"event"`
+	Body  any    `json:"body,omitempty"`
+}
+
+// conn reads and writes messages framed by a Content-Length header,
+// as DAP sends them over stdio. Messages can be written by several
+// goroutines, and are numbered in the order they're written.
+type conn struct {
+	r *bufio.Reader
+
+	mu  sync.Mutex
+	w   io.Writer
+	seq int
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: bufio.NewReader(r), w: w}
+}
+
+// read decodes the next message into msg.
+func (c *conn) read(msg any) error {
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		return err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return fmt.Errorf("bad Content-Length header '%s'", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return err
+	}
+	return json.Unmarshal(body, msg)
+}
+
+// write numbers a message with setSeq, and sends it.
+func (c *conn) write(msg any, setSeq func(int)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	setSeq(c.seq)
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(body)
+	return err
+}
+
+// respond answers req with body, or with err if it failed.
+func (c *conn) respond(req *request, body any, err error) error {
+	res := &response{Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: body}
+	if err != nil {
+		res.Message = err.Error()
+		res.Body = nil
+	}
+	return c.write(res, func(seq int) { res.Seq = seq })
+}
+
+// event sends an event named name to the client.
+func (c *conn) event(name string, body any) error {
+	ev := &event{Type: "event", Event: name, Body: body}
+	return c.write(ev, func(seq int) { ev.Seq = seq })
+}
@@ -0,0 +1,127 @@
+package dap
+
+// The parts of the Debug Adapter Protocol the server speaks, see
+// https://microsoft.github.io/debug-adapter-protocol/specification
+
+type InitializeArguments struct {
+	ClientID string `json:"clientID,omitempty"`
+	// LinesStartAt1 is true unless the client counts lines from 0.
+	LinesStartAt1 *bool `json:"linesStartAt1,omitempty"`
+}
+
+type Capabilities struct {
+	SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
+}
+
+type LaunchArguments struct {
+	// Program is the path of the lox file to run.
+	Program string `json:"program"`
+	// StopOnEntry pauses the program before its first statement.
+	StopOnEntry bool `json:"stopOnEntry,omitempty"`
+}
+
+type Source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type SourceBreakpoint struct {
+	Line int `json:"line"`
+}
+
+type SetBreakpointsArguments struct {
+	Source      Source             `json:"source"`
+	Breakpoints []SourceBreakpoint `json:"breakpoints"`
+}
+
+type Breakpoint struct {
+	Verified bool   `json:"verified"`
+	Line     int    `json:"line,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+type SetBreakpointsResponseBody struct {
+	Breakpoints []Breakpoint `json:"breakpoints"`
+}
+
+type Thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type ThreadsResponseBody struct {
+	Threads []Thread `json:"threads"`
+}
+
+// ThreadArguments are the arguments of continue, next, stepIn and stepOut.
+type ThreadArguments struct {
+	ThreadID int `json:"threadId"`
+}
+
+type ContinueResponseBody struct {
+	AllThreadsContinued bool `json:"allThreadsContinued"`
+}
+
+type StackTraceArguments struct {
+	ThreadID int `json:"threadId"`
+}
+
+type StackFrame struct {
+	ID     int     `json:"id"`
+	Name   string  `json:"name"`
+	Source *Source `json:"source,omitempty"`
+	Line   int     `json:"line"`
+	Column int     `json:"column"`
+}
+
+type StackTraceResponseBody struct {
+	StackFrames []StackFrame `json:"stackFrames"`
+	TotalFrames int          `json:"totalFrames"`
+}
+
+type ScopesArguments struct {
+	FrameID int `json:"frameId"`
+}
+
+type Scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type ScopesResponseBody struct {
+	Scopes []Scope `json:"scopes"`
+}
+
+type VariablesArguments struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+// Variable is a value, which can be expanded to its fields or
+// elements when its VariablesReference isn't 0.
+type Variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type VariablesResponseBody struct {
+	Variables []Variable `json:"variables"`
+}
+
+type StoppedEventBody struct {
+	// Reason is "entry", "step" or "breakpoint".
+	Reason            string `json:"reason"`
+	ThreadID          int    `json:"threadId"`
+	AllThreadsStopped bool   `json:"allThreadsStopped"`
+}
+
+type OutputEventBody struct {
+	// Category is "stdout" or "stderr".
+	Category string `json:"category"`
+	Output   string `json:"output"`
+}
+
+type ExitedEventBody struct {
+	ExitCode int `json:"exitCode"`
+}
@@ -0,0 +1,479 @@
+/*
+Package dap is a Debug Adapter Protocol server for lox, which lets
+editors debug programs run by the tree walker.
+
+A client launches a single program, which runs once the client is
+done setting breakpoints. The server then:
+
+  - pauses it on breakpoints, or when it steps into, over or out of
+    a call, with the continue, next, stepIn and stepOut requests
+  - lists the calls in progress with the stackTrace request
+  - lists the variables of each scope of a call with the scopes and
+    variables requests, expanding instances to their fields and
+    lists to their elements
+
+Programs have a single thread, and breakpoints can only be set in the
+file launched, since the lines of imported modules aren't tracked.
+*/
+package dap
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"glox/ast"
+	"glox/debugger"
+	"glox/runtime"
+	"io"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// threadID identifies the only thread of a program.
+const threadID = 1
+
+var errNotPaused = stderrors.New("the program isn't paused")
+
+// Server debugs the program launched by a single client.
+type Server struct {
+	conn   *conn
+	newLox func() *runtime.Lox
+
+	initialized bool
+	// Lines are sent and received counting from lineBase.
+	lineBase int
+	// The arguments of the launch request.
+	launch *LaunchArguments
+	// after runs once the response to the request being handled
+	// is sent, for the requests whose events must follow it.
+	after func() error
+
+	// The calls in progress, outermost first, updated by the program
+	// before each of its statements.
+	calls []call
+	// resume wakes the program up once it's paused,
+	// and done is closed when it ends.
+	resume chan struct{}
+	done   chan struct{}
+
+	// mu guards the state shared with the program.
+	mu      sync.Mutex
+	stepper *debugger.Stepper
+	entry   bool
+	quit    bool
+	// The calls in progress when the program paused, innermost
+	// first, nil while it runs.
+	paused []call
+	// The values variable references stand for while the
+	// program is paused: references are indexes plus one.
+	refs []any
+}
+
+// call is a lox function call in progress, or the script itself.
+type call struct {
+	name string
+	// The line of the statement being executed, and the scope it's in.
+	line         int
+	env, globals *runtime.Environment
+}
+
+// globals is the scope of a module's globals, which leaves out natives.
+type globals struct {
+	env *runtime.Environment
+}
+
+// NewServer returns a server reading messages from r and writing to w,
+// which runs the program launched with an interpreter from newLox.
+func NewServer(r io.Reader, w io.Writer, newLox func() *runtime.Lox) *Server {
+	return &Server{
+		conn:     newConn(r, w),
+		newLox:   newLox,
+		lineBase: 1,
+		resume:   make(chan struct{}),
+		stepper:  debugger.NewStepper(),
+	}
+}
+
+// Serve debugs the program the client launches until it disconnects.
+func Serve(r io.Reader, w io.Writer, newLox func() *runtime.Lox) error {
+	return NewServer(r, w, newLox).Serve()
+}
+
+// Serve answers the requests of the client until it disconnects, or the
+// connection fails. The program is stopped if it's still running.
+func (s *Server) Serve() error {
+	defer s.stop()
+	for {
+		req := &request{}
+		if err := s.conn.read(req); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				// Without the request there's no seq to reply to.
+				continue
+			}
+			return err
+		}
+		body, err := s.handle(req)
+		if err := s.conn.respond(req, body, err); err != nil {
+			return err
+		}
+		if req.Command == "disconnect" {
+			return nil
+		}
+		if after := s.after; after != nil {
+			s.after = nil
+			if err := after(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle runs the command of a request, returning the body of its response.
+func (s *Server) handle(req *request) (any, error) {
+	if !s.initialized && req.Command != "initialize" {
+		return nil, fmt.Errorf("the server isn't initialized")
+	}
+	switch req.Command {
+	case "initialize":
+		var args InitializeArguments
+		if err := unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if args.LinesStartAt1 != nil && !*args.LinesStartAt1 {
+			s.lineBase = 0
+		}
+		s.initialized = true
+		return Capabilities{SupportsConfigurationDoneRequest: true}, nil
+	case "launch":
+		if s.launch != nil {
+			return nil, fmt.Errorf("a program was already launched")
+		}
+		args := &LaunchArguments{}
+		if err := unmarshal(req.Arguments, args); err != nil {
+			return nil, err
+		}
+		if args.Program == "" {
+			return nil, fmt.Errorf("launch needs the path of a program")
+		}
+		s.launch = args
+		// Breakpoints are set once there's a program to set them in.
+		s.after = func() error { return s.conn.event("initialized", nil) }
+		return nil, nil
+	case "configurationDone":
+		if s.launch == nil {
+			return nil, fmt.Errorf("no program was launched")
+		}
+		if s.done == nil {
+			s.after = s.start
+		}
+		return nil, nil
+	case "setBreakpoints":
+		var args SetBreakpointsArguments
+		if err := unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.setBreakpoints(args), nil
+	case "threads":
+		return ThreadsResponseBody{Threads: []Thread{{ID: threadID, Name: "main"}}}, nil
+	case "continue":
+		return ContinueResponseBody{AllThreadsContinued: true}, s.resumeWith((*debugger.Stepper).Continue)
+	case "next":
+		return nil, s.resumeWith((*debugger.Stepper).StepOver)
+	case "stepIn":
+		return nil, s.resumeWith((*debugger.Stepper).StepInto)
+	case "stepOut":
+		return nil, s.resumeWith((*debugger.Stepper).StepOut)
+	case "stackTrace":
+		return s.stackTrace()
+	case "scopes":
+		var args ScopesArguments
+		if err := unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.scopes(args.FrameID)
+	case "variables":
+		var args VariablesArguments
+		if err := unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.variables(args.VariablesReference)
+	case "disconnect":
+		s.stop()
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown command '%s'", req.Command)
+}
+
+// start runs the program launched, once the
+// client is done setting breakpoints.
+func (s *Server) start() error {
+	l := s.newLox()
+	debugger.Attach(l, s)
+	l.Stdout = &output{conn: s.conn, category: "stdout"}
+	l.Stderr = &output{conn: s.conn, category: "stderr"}
+	l.Color = false
+	s.mu.Lock()
+	s.entry = s.launch.StopOnEntry
+	if !s.entry {
+		s.stepper.Continue()
+	}
+	s.mu.Unlock()
+
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		code := 0
+		if _, err := l.RunFile(s.launch.Program); err != nil && !stderrors.Is(err, debugger.ErrQuit) {
+			code = 1
+		}
+		s.conn.event("exited", ExitedEventBody{ExitCode: code})
+		s.conn.event("terminated", nil)
+	}()
+	return nil
+}
+
+// stop stops the program, if it's running, and waits for it to end.
+func (s *Server) stop() {
+	if s.done == nil {
+		return
+	}
+	s.mu.Lock()
+	s.quit = true
+	paused := s.paused != nil
+	s.paused = nil
+	s.mu.Unlock()
+	if paused {
+		s.resume <- struct{}{}
+	}
+	<-s.done
+}
+
+// BeforeStatement implements runtime.Hook, pausing the program
+// when the stepper says so until it's resumed.
+func (s *Server) BeforeStatement(te *runtime.TreeEvaluator, stmt ast.Stmt, line int) error {
+	depth := te.Depth()
+	for len(s.calls) <= depth {
+		s.calls = append(s.calls, call{})
+	}
+	s.calls = s.calls[:depth+1]
+	c := &s.calls[depth]
+	c.env, c.globals = te.Env(), te.BaseEnv
+	if line != 0 {
+		c.line = line
+	}
+
+	s.mu.Lock()
+	if s.quit {
+		s.mu.Unlock()
+		return debugger.ErrQuit
+	}
+	pause, breakpoint := s.stepper.Pause(stmt, line, depth)
+	if !pause {
+		s.mu.Unlock()
+		return nil
+	}
+	reason := "step"
+	switch {
+	case breakpoint:
+		reason = "breakpoint"
+	case s.entry:
+		reason = "entry"
+	}
+	s.entry = false
+	trace := te.Trace()
+	s.paused = make([]call, depth+1)
+	for i, c := range s.calls {
+		c.name = "<script>"
+		if i > 0 {
+			c.name = trace[i-1].Function
+		}
+		s.paused[depth-i] = c
+	}
+	s.mu.Unlock()
+
+	s.conn.event("stopped", StoppedEventBody{Reason: reason, ThreadID: threadID, AllThreadsStopped: true})
+	<-s.resume
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.quit {
+		return debugger.ErrQuit
+	}
+	return nil
+}
+
+// resumeWith resumes the paused program once the
+// response is sent, after calling step on the stepper.
+func (s *Server) resumeWith(step func(*debugger.Stepper)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paused == nil {
+		return errNotPaused
+	}
+	step(s.stepper)
+	s.paused, s.refs = nil, nil
+	s.after = func() error {
+		s.resume <- struct{}{}
+		return nil
+	}
+	return nil
+}
+
+func (s *Server) setBreakpoints(args SetBreakpointsArguments) SetBreakpointsResponseBody {
+	res := SetBreakpointsResponseBody{Breakpoints: []Breakpoint{}}
+	launched := s.launch != nil && sameFile(args.Source.Path, s.launch.Program)
+	lines := make(map[int]bool)
+	for _, bp := range args.Breakpoints {
+		if !launched {
+			res.Breakpoints = append(res.Breakpoints, Breakpoint{Line: bp.Line, Message: "breakpoints can only be set in the program launched"})
+			continue
+		}
+		lines[bp.Line-s.lineBase+1] = true
+		res.Breakpoints = append(res.Breakpoints, Breakpoint{Verified: true, Line: bp.Line})
+	}
+	if launched {
+		s.mu.Lock()
+		s.stepper.Breakpoints = lines
+		s.mu.Unlock()
+	}
+	return res
+}
+
+func sameFile(a, b string) bool {
+	a, errA := filepath.Abs(a)
+	b, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && a == b
+}
+
+func (s *Server) stackTrace() (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paused == nil {
+		return nil, errNotPaused
+	}
+	source := &Source{Name: filepath.Base(s.launch.Program), Path: s.launch.Program}
+	res := StackTraceResponseBody{TotalFrames: len(s.paused)}
+	for i, c := range s.paused {
+		res.StackFrames = append(res.StackFrames, StackFrame{
+			ID:     i + 1,
+			Name:   c.name,
+			Source: source,
+			Line:   c.line + s.lineBase - 1,
+			Column: s.lineBase,
+		})
+	}
+	return res, nil
+}
+
+// scopes lists the scopes of the call with the frame id, innermost first,
+// skipping empty ones, and ending with the globals of its module.
+func (s *Server) scopes(id int) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paused == nil {
+		return nil, errNotPaused
+	}
+	if id < 1 || id > len(s.paused) {
+		return nil, fmt.Errorf("no frame %d", id)
+	}
+	c := s.paused[id-1]
+	res := ScopesResponseBody{Scopes: []Scope{}}
+	for env := c.env; env != nil && env != c.globals; env = env.Parent() {
+		if len(env.Names()) == 0 {
+			continue
+		}
+		name := "Locals"
+		if len(res.Scopes) > 0 {
+			name = "Scope " + strconv.Itoa(len(res.Scopes))
+		}
+		res.Scopes = append(res.Scopes, Scope{Name: name, VariablesReference: s.ref(env)})
+	}
+	res.Scopes = append(res.Scopes, Scope{Name: "Globals", VariablesReference: s.ref(globals{c.globals})})
+	return res, nil
+}
+
+// variables lists the variables of a scope, the
+// fields of an instance or the elements of a list.
+func (s *Server) variables(ref int) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paused == nil {
+		return nil, errNotPaused
+	}
+	if ref < 1 || ref > len(s.refs) {
+		return nil, fmt.Errorf("no variables with reference %d", ref)
+	}
+	res := VariablesResponseBody{Variables: []Variable{}}
+	add := func(name string, val any) {
+		res.Variables = append(res.Variables, s.variable(name, val))
+	}
+	switch val := s.refs[ref-1].(type) {
+	case *runtime.Environment:
+		for _, name := range val.Names() {
+			v, _ := val.Get(name)
+			add(name, v)
+		}
+	case globals:
+		for _, name := range val.env.Names() {
+			if v, _ := val.env.Get(name); !isNative(v) {
+				add(name, v)
+			}
+		}
+	case *runtime.LoxInstance:
+		for _, name := range val.Names() {
+			v, _ := val.Get(name)
+			add(name, v)
+		}
+	case *runtime.LoxList:
+		for i, v := range val.Elements {
+			add(strconv.Itoa(i), v)
+		}
+	}
+	return res, nil
+}
+
+func (s *Server) variable(name string, val any) Variable {
+	v := Variable{Name: name, Value: debugger.Repr(val)}
+	switch val := val.(type) {
+	case *runtime.LoxInstance:
+		if len(val.Names()) > 0 {
+			v.VariablesReference = s.ref(val)
+		}
+	case *runtime.LoxList:
+		if len(val.Elements) > 0 {
+			v.VariablesReference = s.ref(val)
+		}
+	}
+	return v
+}
+
+// ref returns a new reference to val, valid until the program resumes.
+func (s *Server) ref(val any) int {
+	s.refs = append(s.refs, val)
+	return len(s.refs)
+}
+
+func isNative(val any) bool {
+	_, ok := val.(*runtime.GoCallable)
+	return ok
+}
+
+// output sends what the program writes to the client as output events.
+type output struct {
+	conn     *conn
+	category string
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	if err := o.conn.event("output", OutputEventBody{Category: o.category, Output: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func unmarshal(args json.RawMessage, v any) error {
+	if len(args) == 0 {
+		return nil
+	}
+	return json.Unmarshal(args, v)
+}
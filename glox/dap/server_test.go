@@ -0,0 +1,334 @@
+package dap
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"glox/runtime"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// incoming has the fields of the responses and events the server sends.
+type incoming struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Command    string          `json:"command"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// client drives a server over pipes, the way an editor does over stdio.
+type client struct {
+	t    *testing.T
+	conn *conn
+	msgs chan *incoming
+	// Events read while waiting for other messages.
+	events []*incoming
+	done   chan error
+}
+
+func newClient(t *testing.T) *client {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &client{t: t, conn: newConn(clientIn, clientOut), msgs: make(chan *incoming), done: make(chan error, 1)}
+	go func() {
+		err := Serve(serverIn, serverOut, runtime.NewLoxInterpreter)
+		serverOut.Close()
+		c.done <- err
+	}()
+	go func() {
+		defer close(c.msgs)
+		for {
+			msg := &incoming{}
+			if err := c.conn.read(msg); err != nil {
+				return
+			}
+			c.msgs <- msg
+		}
+	}()
+	return c
+}
+
+func (c *client) next() *incoming {
+	select {
+	case msg, ok := <-c.msgs:
+		require.True(c.t, ok, "the server closed the connection")
+		return msg
+	case <-time.After(5 * time.Second):
+		require.FailNow(c.t, "timed out waiting for the server")
+		return nil
+	}
+}
+
+// call sends a request and decodes the body of its response into body.
+func (c *client) call(command string, args any, body any) error {
+	data, err := json.Marshal(args)
+	require.NoError(c.t, err)
+	req := &request{Type: "request", Command: command, Arguments: data}
+	require.NoError(c.t, c.conn.write(req, func(seq int) { req.Seq = seq }))
+	for {
+		msg := c.next()
+		if msg.Type == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+		require.Equal(c.t, req.Seq, msg.RequestSeq)
+		require.Equal(c.t, command, msg.Command)
+		if !msg.Success {
+			return stderrors.New(msg.Message)
+		}
+		if body != nil {
+			require.NoError(c.t, json.Unmarshal(msg.Body, body))
+		}
+		return nil
+	}
+}
+
+// event waits for the next event called name, decoding its body into body.
+func (c *client) event(name string, body any) {
+	var ev *incoming
+	for i, e := range c.events {
+		if e.Event == name {
+			ev = e
+			c.events = append(c.events[:i:i], c.events[i+1:]...)
+			break
+		}
+	}
+	for ev == nil {
+		msg := c.next()
+		require.Equal(c.t, "event", msg.Type)
+		if msg.Event == name {
+			ev = msg
+		} else {
+			c.events = append(c.events, msg)
+		}
+	}
+	if body != nil {
+		require.NoError(c.t, json.Unmarshal(ev.Body, body))
+	}
+}
+
+// output returns what the program wrote to category so far.
+func (c *client) output(category string) string {
+	var sb strings.Builder
+	for _, e := range c.events {
+		var out OutputEventBody
+		if e.Event == "output" && json.Unmarshal(e.Body, &out) == nil && out.Category == category {
+			sb.WriteString(out.Output)
+		}
+	}
+	return sb.String()
+}
+
+// launch runs program with breakpoints on lines.
+func (c *client) launch(program string, stopOnEntry bool, lines ...int) {
+	require.NoError(c.t, c.call("initialize", InitializeArguments{ClientID: "test"}, nil))
+	require.NoError(c.t, c.call("launch", LaunchArguments{Program: program, StopOnEntry: stopOnEntry}, nil))
+	c.event("initialized", nil)
+	args := SetBreakpointsArguments{Source: Source{Path: program}, Breakpoints: []SourceBreakpoint{}}
+	for _, line := range lines {
+		args.Breakpoints = append(args.Breakpoints, SourceBreakpoint{Line: line})
+	}
+	require.NoError(c.t, c.call("setBreakpoints", args, nil))
+	require.NoError(c.t, c.call("configurationDone", nil, nil))
+}
+
+// stopped waits for the program to pause, returning why.
+func (c *client) stopped() string {
+	var ev StoppedEventBody
+	c.event("stopped", &ev)
+	assert.Equal(c.t, threadID, ev.ThreadID)
+	return ev.Reason
+}
+
+// stack returns the name and line of each frame, innermost first.
+func (c *client) stack() []string {
+	var res StackTraceResponseBody
+	require.NoError(c.t, c.call("stackTrace", StackTraceArguments{ThreadID: threadID}, &res))
+	var frames []string
+	for _, f := range res.StackFrames {
+		frames = append(frames, f.Name+":"+strconv.Itoa(f.Line))
+	}
+	return frames
+}
+
+// scopes returns the references of the scopes of a frame, by name.
+func (c *client) scopes(frame int) ([]string, map[string]int) {
+	var res ScopesResponseBody
+	require.NoError(c.t, c.call("scopes", ScopesArguments{FrameID: frame}, &res))
+	var names []string
+	refs := make(map[string]int)
+	for _, s := range res.Scopes {
+		names = append(names, s.Name)
+		refs[s.Name] = s.VariablesReference
+	}
+	return names, refs
+}
+
+// variables returns "name = value" for each variable of ref, and
+// the references of the ones that expand, by name.
+func (c *client) variables(ref int) ([]string, map[string]int) {
+	var res VariablesResponseBody
+	require.NoError(c.t, c.call("variables", VariablesArguments{VariablesReference: ref}, &res))
+	var vars []string
+	refs := make(map[string]int)
+	for _, v := range res.Variables {
+		vars = append(vars, v.Name+" = "+v.Value)
+		if v.VariablesReference != 0 {
+			refs[v.Name] = v.VariablesReference
+		}
+	}
+	return vars, refs
+}
+
+func (c *client) step(command string) {
+	require.NoError(c.t, c.call(command, ThreadArguments{ThreadID: threadID}, nil))
+}
+
+func (c *client) disconnect() error {
+	require.NoError(c.t, c.call("disconnect", nil, nil))
+	return <-c.done
+}
+
+const (
+	counter = "testdata/counter.lx"
+	fails   = "testdata/fails.lx"
+)
+
+func TestServer_Breakpoints(t *testing.T) {
+	c := newClient(t)
+	c.launch(counter, false, 6)
+
+	assert.Equal(t, "breakpoint", c.stopped())
+	assert.Equal(t, []string{"add:6", "total:14", "<script>:20"}, c.stack())
+
+	scopes, refs := c.scopes(1)
+	assert.Equal(t, []string{"Locals", "Scope 1", "Globals"}, scopes)
+	vars, _ := c.variables(refs["Locals"])
+	assert.Equal(t, []string{"n = 1"}, vars)
+	vars, fields := c.variables(refs["Scope 1"])
+	assert.Equal(t, []string{"this = <instance 'Counter'>"}, vars)
+	vars, _ = c.variables(fields["this"])
+	assert.Equal(t, []string{"count = 0"}, vars)
+	vars, _ = c.variables(refs["Globals"])
+	assert.Equal(t, []string{"Counter = <class 'Counter'>", "items = [1, 2, 3]", "total = <fun total>"}, vars)
+
+	_, refs = c.scopes(2)
+	vars, _ = c.variables(refs["Locals"])
+	assert.Equal(t, []string{"i = 0"}, vars)
+	vars, elems := c.variables(refs["Scope 1"])
+	assert.Equal(t, []string{"c = <instance 'Counter'>", "items = [1, 2, 3]"}, vars)
+	vars, _ = c.variables(elems["items"])
+	assert.Equal(t, []string{"0 = 1", "1 = 2", "2 = 3"}, vars)
+
+	c.step("stepOut")
+	assert.Equal(t, "step", c.stopped())
+	assert.Equal(t, []string{"total:14", "<script>:20"}, c.stack())
+	_, refs = c.scopes(1)
+	vars, _ = c.variables(refs["Locals"])
+	assert.Equal(t, []string{"i = 1"}, vars)
+
+	require.NoError(t, c.call("setBreakpoints", SetBreakpointsArguments{Source: Source{Path: counter}}, nil))
+	c.step("next")
+	assert.Equal(t, "step", c.stopped())
+	assert.Equal(t, []string{"total:14", "<script>:20"}, c.stack())
+	c.step("next")
+	c.stopped()
+	assert.Equal(t, []string{"total:16", "<script>:20"}, c.stack())
+	c.step("stepOut")
+	c.stopped()
+	assert.Equal(t, []string{"<script>:21"}, c.stack())
+
+	c.step("continue")
+	var exited ExitedEventBody
+	c.event("exited", &exited)
+	c.event("terminated", nil)
+	assert.Equal(t, 0, exited.ExitCode)
+	assert.Equal(t, "6\ndone\n", c.output("stdout"))
+	assert.NoError(t, c.disconnect())
+}
+
+func TestServer_StopOnEntry(t *testing.T) {
+	c := newClient(t)
+	c.launch(counter, true)
+
+	assert.Equal(t, "entry", c.stopped())
+	assert.Equal(t, []string{"<script>:1"}, c.stack())
+	scopes, _ := c.scopes(1)
+	assert.Equal(t, []string{"Globals"}, scopes)
+	c.step("stepIn")
+	assert.Equal(t, "step", c.stopped())
+	assert.Equal(t, []string{"<script>:11"}, c.stack())
+
+	// Disconnecting stops the paused program.
+	assert.NoError(t, c.disconnect())
+	assert.Equal(t, "", c.output("stdout"))
+}
+
+func TestServer_LinesStartAt0(t *testing.T) {
+	c := newClient(t)
+	zero := false
+	require.NoError(t, c.call("initialize", InitializeArguments{LinesStartAt1: &zero}, nil))
+	require.NoError(t, c.call("launch", LaunchArguments{Program: counter}, nil))
+	c.event("initialized", nil)
+	var res SetBreakpointsResponseBody
+	require.NoError(t, c.call("setBreakpoints", SetBreakpointsArguments{
+		Source:      Source{Path: counter},
+		Breakpoints: []SourceBreakpoint{{Line: 19}},
+	}, &res))
+	assert.Equal(t, []Breakpoint{{Verified: true, Line: 19}}, res.Breakpoints)
+	require.NoError(t, c.call("configurationDone", nil, nil))
+
+	assert.Equal(t, "breakpoint", c.stopped())
+	assert.Equal(t, []string{"<script>:19"}, c.stack())
+	assert.NoError(t, c.disconnect())
+}
+
+func TestServer_ProgramFails(t *testing.T) {
+	c := newClient(t)
+	c.launch(fails, false)
+
+	var exited ExitedEventBody
+	c.event("exited", &exited)
+	c.event("terminated", nil)
+	assert.Equal(t, 1, exited.ExitCode)
+	assert.Equal(t, "1\n", c.output("stdout"))
+	assert.NotEmpty(t, c.output("stderr"))
+	assert.NoError(t, c.disconnect())
+}
+
+func TestServer_Errors(t *testing.T) {
+	c := newClient(t)
+	assert.EqualError(t, c.call("threads", nil, nil), "the server isn't initialized")
+	require.NoError(t, c.call("initialize", nil, nil))
+	assert.EqualError(t, c.call("configurationDone", nil, nil), "no program was launched")
+	assert.EqualError(t, c.call("launch", LaunchArguments{}, nil), "launch needs the path of a program")
+	assert.EqualError(t, c.call("evaluate", nil, nil), "unknown command 'evaluate'")
+
+	require.NoError(t, c.call("launch", LaunchArguments{Program: counter, StopOnEntry: true}, nil))
+	c.event("initialized", nil)
+	assert.EqualError(t, c.call("stackTrace", StackTraceArguments{ThreadID: threadID}, nil), "the program isn't paused")
+	assert.EqualError(t, c.call("continue", ThreadArguments{ThreadID: threadID}, nil), "the program isn't paused")
+	var res SetBreakpointsResponseBody
+	require.NoError(t, c.call("setBreakpoints", SetBreakpointsArguments{
+		Source:      Source{Path: fails},
+		Breakpoints: []SourceBreakpoint{{Line: 2}},
+	}, &res))
+	assert.Equal(t, []Breakpoint{{Line: 2, Message: "breakpoints can only be set in the program launched"}}, res.Breakpoints)
+	require.NoError(t, c.call("configurationDone", nil, nil))
+
+	c.stopped()
+	assert.EqualError(t, c.call("scopes", ScopesArguments{FrameID: 2}, nil), "no frame 2")
+	assert.EqualError(t, c.call("variables", VariablesArguments{VariablesReference: 1}, nil), "no variables with reference 1")
+	assert.NoError(t, c.disconnect())
+}
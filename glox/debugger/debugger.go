@@ -0,0 +1,275 @@
+/*
+Package debugger steps through lox programs run by the tree walker.
+
+The debugger pauses a program before its first statement, then reads
+commands, one per line, until one of them resumes the program:
+
+	break N, b N     pause before the statements on line N
+	clear N          remove the breakpoint on line N
+	step, s          resume until the next statement, entering calls
+	next, n          resume until the next statement of this function
+	out, o           resume until the function being run returns
+	continue, c      resume until a breakpoint
+	locals, l        print the variables of each enclosing scope
+	print E, p E     print the value of the expression E
+	backtrace, bt    print the calls in progress
+	quit, q          stop the program
+	help, h          list the commands
+
+Commands are read from any reader, so sessions can be scripted. When
+the commands run out the program runs to the end without pausing.
+*/
+package debugger
+
+import (
+	"bufio"
+	stderrors "errors"
+	"fmt"
+	"glox/ast"
+	"glox/errors"
+	"glox/lexer"
+	"glox/parser"
+	"glox/runtime"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrQuit stops a program when the quit command is given.
+var ErrQuit = stderrors.New("program stopped by the debugger")
+
+// Debugger pauses a program run by a runtime.Lox, as its Hook.
+type Debugger struct {
+	in  *bufio.Scanner
+	out io.Writer
+	// Prompt is written before reading each command.
+	Prompt string
+
+	// Lines of the program's source.
+	source  []string
+	stepper *Stepper
+	// Set by the quit command, which stops the finally
+	// blocks the program unwinds through too.
+	quit bool
+}
+
+// New returns a debugger that reads commands from in
+// and writes what they print to out.
+func New(in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		in:      bufio.NewScanner(in),
+		out:     out,
+		stepper: NewStepper(),
+	}
+}
+
+// Run runs the lox file at path with l, pausing it as the
+// commands say. It returns the error the program failed with.
+func (d *Debugger) Run(l *runtime.Lox, path string) error {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	d.source = strings.Split(string(source), "\n")
+	Attach(l, d)
+	_, err = l.RunFile(path)
+	switch {
+	case err == nil:
+		fmt.Fprintln(d.out, "program exited")
+	case stderrors.Is(err, ErrQuit):
+		return nil
+	default:
+		fmt.Fprintln(d.out, "program failed")
+	}
+	return err
+}
+
+// BeforeStatement implements runtime.Hook.
+func (d *Debugger) BeforeStatement(te *runtime.TreeEvaluator, stmt ast.Stmt, line int) error {
+	if d.quit {
+		return ErrQuit
+	}
+	pause, breakpoint := d.stepper.Pause(stmt, line, te.Depth())
+	if !pause {
+		return nil
+	}
+	if breakpoint {
+		fmt.Fprintf(d.out, "breakpoint at line %d\n", line)
+	}
+	d.show(line)
+	return d.commands(te, line)
+}
+
+// show prints a line of the source.
+func (d *Debugger) show(line int) {
+	text := ""
+	if line <= len(d.source) {
+		text = strings.TrimSpace(d.source[line-1])
+	}
+	fmt.Fprintf(d.out, "%d\t%s\n", line, text)
+}
+
+// commands runs commands until one resumes the program.
+func (d *Debugger) commands(te *runtime.TreeEvaluator, line int) error {
+	for {
+		fmt.Fprint(d.out, d.Prompt)
+		if !d.in.Scan() {
+			d.stepper.Detach()
+			return nil
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimSpace(d.in.Text()), " ")
+		arg = strings.TrimSpace(arg)
+		switch cmd {
+		case "":
+		case "step", "s":
+			d.stepper.StepInto()
+			return nil
+		case "next", "n":
+			d.stepper.StepOver()
+			return nil
+		case "out", "o":
+			d.stepper.StepOut()
+			return nil
+		case "continue", "c":
+			d.stepper.Continue()
+			return nil
+		case "quit", "q":
+			d.quit = true
+			return ErrQuit
+		case "break", "b":
+			if n, ok := d.lineArg(arg); ok {
+				d.stepper.Breakpoints[n] = true
+				fmt.Fprintf(d.out, "breakpoint set at line %d\n", n)
+			}
+		case "clear":
+			if n, ok := d.lineArg(arg); ok {
+				delete(d.stepper.Breakpoints, n)
+				fmt.Fprintf(d.out, "breakpoint cleared at line %d\n", n)
+			}
+		case "locals", "l":
+			d.locals(te)
+		case "print", "p":
+			d.print(te, arg)
+		case "backtrace", "bt":
+			d.backtrace(te, line)
+		case "help", "h":
+			fmt.Fprint(d.out, help)
+		default:
+			fmt.Fprintf(d.out, "unknown command '%s', try 'help'\n", cmd)
+		}
+	}
+}
+
+const help = `break N, b N     pause before the statements on line N
+clear N          remove the breakpoint on line N
+step, s          resume until the next statement, entering calls
+next, n          resume until the next statement of this function
+out, o           resume until the function being run returns
+continue, c      resume until a breakpoint
+locals, l        print the variables of each enclosing scope
+print E, p E     print the value of the expression E
+backtrace, bt    print the calls in progress
+quit, q          stop the program
+`
+
+func (d *Debugger) lineArg(arg string) (int, bool) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 {
+		fmt.Fprintf(d.out, "expect a line number, found '%s'\n", arg)
+		return 0, false
+	}
+	return n, true
+}
+
+// locals prints the variables of the scopes
+// enclosing the statement, innermost first.
+func (d *Debugger) locals(te *runtime.TreeEvaluator) {
+	n := 0
+	for _, env := range te.Scopes() {
+		names := env.Names()
+		if len(names) == 0 {
+			continue
+		}
+		fmt.Fprintf(d.out, "scope %d:\n", n)
+		for _, name := range names {
+			val, _ := env.Get(name)
+			fmt.Fprintf(d.out, "  %s = %s\n", name, Repr(val))
+		}
+		n++
+	}
+	if n == 0 {
+		fmt.Fprintln(d.out, "no locals")
+	}
+}
+
+// print evaluates the expression src in the paused program.
+func (d *Debugger) print(te *runtime.TreeEvaluator, src string) {
+	expr, err := parseExpression(src)
+	if err == nil {
+		var val any
+		if val, err = te.EvaluateInScope(expr); err == nil {
+			fmt.Fprintln(d.out, Repr(val))
+			return
+		}
+	}
+	fmt.Fprintf(d.out, "error: %s\n", message(err))
+}
+
+func parseExpression(src string) (ast.Expr, error) {
+	tokens, err := lexer.ScanSource(strings.TrimSuffix(src, ";") + ";")
+	if err != nil {
+		return nil, err
+	}
+	stmts, err := parser.Parse(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if len(stmts) != 1 {
+		return nil, fmt.Errorf("expect an expression")
+	}
+	stmt, ok := stmts[0].(*ast.Expression)
+	if !ok {
+		return nil, fmt.Errorf("expect an expression")
+	}
+	return stmt.Expression, nil
+}
+
+// backtrace prints the calls in progress, innermost first,
+// with the line each of them is at.
+func (d *Debugger) backtrace(te *runtime.TreeEvaluator, line int) {
+	frames := te.Trace()
+	for i := len(frames) - 1; i >= 0; i-- {
+		fmt.Fprintf(d.out, "#%d %s() at line %d\n", len(frames)-1-i, frames[i].Function, line)
+		line = frames[i].Line
+	}
+	fmt.Fprintf(d.out, "#%d <script> at line %d\n", len(frames), line)
+}
+
+// Attach has l call hook before each statement it executes. Only
+// the tree walker calls hooks, so l runs programs with it.
+func Attach(l *runtime.Lox, hook runtime.Hook) {
+	l.Hook = hook
+	l.Backend = nil
+}
+
+// Repr formats a value like print, but quotes strings.
+func Repr(val any) string {
+	if s, ok := val.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprint(val)
+}
+
+func message(err error) string {
+	if list, ok := err.(errors.ErrorList); ok && len(list) > 0 {
+		err = list[0]
+	}
+	if conv, ok := err.(interface{ LoxError() *errors.LoxError }); ok {
+		err = conv.LoxError()
+	}
+	if le, ok := err.(*errors.LoxError); ok {
+		return fmt.Sprintf("at '%s': %s", le.Context, le.Message)
+	}
+	return strings.TrimSpace(err.Error())
+}
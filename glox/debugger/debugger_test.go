@@ -0,0 +1,212 @@
+package debugger
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"glox/runtime"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// debug runs testdata/counter.lx with the commands of script,
+// returning what the debugger and the program printed.
+func debug(script string) (string, error) {
+	return debugFile("testdata/counter.lx", script)
+}
+
+func debugFile(path, script string) (string, error) {
+	out := &bytes.Buffer{}
+	l := runtime.NewLoxInterpreter()
+	l.Stdout = out
+	l.Stderr = io.Discard
+	d := New(strings.NewReader(script), out)
+	err := d.Run(l, path)
+	return out.String(), err
+}
+
+func TestDebugger(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{
+			name:   "no commands",
+			script: "",
+			want:   "1\tclass Counter {\n6\ndone\nprogram exited\n",
+		},
+		{
+			name:   "step over",
+			script: "n\nn\nn\nn\nn\n",
+			want: `1	class Counter {
+11	fun total(items) {
+19	var items = [1, 2, 3];
+20	var sum = total(items);
+21	print sum;
+6
+22	print "done";
+done
+program exited
+`,
+		},
+		{
+			name:   "step into and out",
+			script: "b 20\nc\ns\ns\nbt\ns\ns\ns\nbt\no\nbt\no\nc\n",
+			want: `1	class Counter {
+breakpoint set at line 20
+breakpoint at line 20
+20	var sum = total(items);
+12	var c = Counter(0);
+3	this.count = start;
+#0 init() at line 3
+#1 total() at line 12
+#2 <script> at line 20
+13	for (var i = 0; i < items.len(); i = i + 1) {
+14	c.add(items[i]);
+6	this.count = this.count + n;
+#0 add() at line 6
+#1 total() at line 14
+#2 <script> at line 20
+14	c.add(items[i]);
+#0 total() at line 14
+#1 <script> at line 20
+21	print sum;
+6
+done
+program exited
+`,
+		},
+		{
+			name:   "breakpoints",
+			script: "b 6\nc\np n\nc\np n\nclear 6\nc\n",
+			want: `1	class Counter {
+breakpoint set at line 6
+breakpoint at line 6
+6	this.count = this.count + n;
+1
+breakpoint at line 6
+6	this.count = this.count + n;
+2
+breakpoint cleared at line 6
+6
+done
+program exited
+`,
+		},
+		{
+			name:   "locals",
+			script: "b 7\nc\nl\nb 14\nc\nl\n",
+			want: `1	class Counter {
+breakpoint set at line 7
+breakpoint at line 7
+7	return this.count;
+scope 0:
+  n = 1
+scope 1:
+  this = <instance 'Counter'>
+breakpoint set at line 14
+breakpoint at line 14
+14	c.add(items[i]);
+scope 0:
+  i = 1
+scope 1:
+  c = <instance 'Counter'>
+  items = [1, 2, 3]
+6
+done
+program exited
+`,
+		},
+		{
+			name:   "evaluating expressions",
+			script: "l\nb 7\nc\np this.count * 10\np [n, \"n\"]\np this.add(100)\np total([4])\np missing\np n +\n",
+			want: `1	class Counter {
+no locals
+breakpoint set at line 7
+breakpoint at line 7
+7	return this.count;
+10
+[1, "n"]
+101
+4
+error: at 'missing': undefined variable
+error: at ';': unexpected token.
+` + "106\ndone\nprogram exited\n",
+		},
+		{
+			name:   "quit",
+			script: "n\nq\n",
+			want:   "1\tclass Counter {\n11\tfun total(items) {\n",
+		},
+		{
+			name:   "bad commands",
+			script: "jump\nb x\nclear\nh\nq\n",
+			want:   "1\tclass Counter {\nunknown command 'jump', try 'help'\nexpect a line number, found 'x'\nexpect a line number, found ''\n" + help,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := debug(tt.script)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+		})
+	}
+}
+
+func TestDebugger_LoopBreakpoint(t *testing.T) {
+	// The body of the loop is a single statement on a line
+	// of its own, paused at on every pass.
+	out, err := debugFile("testdata/loop.lx", "b 3\nc\np i\nc\np i\nc\np i\nc\n")
+	assert.NoError(t, err)
+	assert.Equal(t, `1	var i = 0;
+breakpoint set at line 3
+breakpoint at line 3
+3	i = i + 1;
+0
+breakpoint at line 3
+3	i = i + 1;
+1
+breakpoint at line 3
+3	i = i + 1;
+2
+3
+program exited
+`, out)
+}
+
+func TestDebugger_LineBreakpoint(t *testing.T) {
+	// A line of several statements is paused at once for each
+	// time the program gets to it, which a loop on a single
+	// line does on every pass.
+	out, err := debugFile("testdata/line.lx", "b 3\nb 4\nc\np a\nc\np i\nc\np i\nc\n")
+	assert.NoError(t, err)
+	assert.Equal(t, `1	var i = 0;
+breakpoint set at line 3
+breakpoint set at line 4
+breakpoint at line 3
+3	a = 1; a = 2; a = 3;
+0
+breakpoint at line 4
+4	while (i < 2) { i = i + 1; a = a + 1; }
+0
+breakpoint at line 4
+4	while (i < 2) { i = i + 1; a = a + 1; }
+1
+5
+program exited
+`, out)
+}
+
+func TestDebugger_ProgramFails(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := runtime.NewLoxInterpreter()
+	l.Stdout = out
+	l.Stderr = io.Discard
+	d := New(strings.NewReader(""), out)
+	err := d.Run(l, "testdata/fails.lx")
+	assert.Error(t, err)
+	assert.Equal(t, "1\tvar a = 1;\nprogram failed\n", out.String())
+}
@@ -0,0 +1,86 @@
+package debugger
+
+import "glox/ast"
+
+type mode int
+
+const (
+	stepInto mode = iota
+	stepOver
+	stepOut
+	running
+	// The program runs to the end without pausing.
+	detached
+)
+
+// A Stepper decides which statements a program pauses before, from its
+// breakpoints and the command that last resumed it. Debuggers call
+// Pause from their runtime.Hook, and a new Stepper pauses at the first
+// statement, as if stepping into the program.
+type Stepper struct {
+	// Breakpoints are the lines to pause on.
+	Breakpoints map[int]bool
+
+	mode mode
+	// Depth of the calls when the program last paused.
+	depth int
+	// Line and depth of the last statement executed, and the
+	// statements executed since the program got to the line, so
+	// each line is only stopped at once, even with several
+	// statements, unless a loop goes round to it again.
+	line, lineDepth int
+	ran             map[ast.Stmt]bool
+}
+
+// NewStepper returns a Stepper without breakpoints.
+func NewStepper() *Stepper {
+	return &Stepper{Breakpoints: make(map[int]bool)}
+}
+
+// Pause reports whether to pause before stmt, a statement on line,
+// with depth lox function calls in progress, and whether it's because
+// of a breakpoint.
+func (s *Stepper) Pause(stmt ast.Stmt, line, depth int) (pause, breakpoint bool) {
+	if line == 0 || s.mode == detached {
+		return false, false
+	}
+	// The program gets to the line again when it comes back from
+	// another line, or when it runs a statement of the line again,
+	// like on each pass of a loop whose body is on a single line.
+	repeat := line == s.line && depth == s.lineDepth && !s.ran[stmt]
+	if !repeat {
+		s.line, s.lineDepth = line, depth
+		s.ran = make(map[ast.Stmt]bool)
+	}
+	s.ran[stmt] = true
+
+	switch {
+	case repeat:
+		return false, false
+	case s.Breakpoints[line]:
+		breakpoint = true
+	case s.mode == stepInto:
+	case s.mode == stepOver && depth <= s.depth:
+	case s.mode == stepOut && depth < s.depth:
+	default:
+		return false, false
+	}
+	s.depth = depth
+	return true, breakpoint
+}
+
+// StepInto resumes the program until the next statement.
+func (s *Stepper) StepInto() { s.mode = stepInto }
+
+// StepOver resumes the program until the next statement
+// of the function it paused in, or of its callers.
+func (s *Stepper) StepOver() { s.mode = stepOver }
+
+// StepOut resumes the program until the function it paused in returns.
+func (s *Stepper) StepOut() { s.mode = stepOut }
+
+// Continue resumes the program until a breakpoint.
+func (s *Stepper) Continue() { s.mode = running }
+
+// Detach lets the program run to the end, ignoring breakpoints.
+func (s *Stepper) Detach() { s.mode = detached }
@@ -1,11 +1,42 @@
 package errors
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
+
+// Span locates a piece of source code. Columns count characters
+// from 1, and EndColumn is the column just past the span.
+type Span struct {
+	Line      int
+	Column    int
+	EndColumn int
+	// Byte offset of the start of the span.
+	Offset int
+}
 
 type LoxError struct {
 	LineNumber int
 	Context    string
 	Message    string
+	// Where in the source the error occurred, the zero
+	// Span if that isn't known.
+	Span Span
+	// The calls in progress when the error occurred,
+	// outermost first. Empty for errors outside functions.
+	Trace []Frame
+	// The error returned by the native function the
+	// error was raised by, if it was.
+	Err error
+}
+
+// Frame is a function call in progress.
+type Frame struct {
+	// Name of the function called.
+	Function string
+	// Line of the call, and of the function's declaration.
+	Line     int
+	DeclLine int
 }
 
 func NewLoxError(ln int, ctx, msg string) *LoxError {
@@ -19,3 +50,30 @@ func NewLoxError(ln int, ctx, msg string) *LoxError {
 func (le *LoxError) Error() string {
 	return fmt.Sprintf("[line %d] at '%s': %s\n", le.LineNumber, le.Context, le.Message)
 }
+
+func (le *LoxError) Unwrap() error {
+	return le.Err
+}
+
+// ErrorList collects the errors found in a single pass
+// over a program, like every syntax error in a file.
+type ErrorList []error
+
+func (el ErrorList) Error() string {
+	var sb strings.Builder
+	for _, err := range el {
+		sb.WriteString(err.Error())
+		if !strings.HasSuffix(sb.String(), "\n") {
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// Err returns the list as an error, or nil if it's empty.
+func (el ErrorList) Err() error {
+	if len(el) == 0 {
+		return nil
+	}
+	return el
+}
@@ -0,0 +1,119 @@
+package errors
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	colorRed   = "\u001b[31;1m"
+	colorBlue  = "\u001b[34m"
+	colorReset = "\u001b[0m"
+)
+
+/*
+Render writes err to w followed by the line of source it occurred
+on, with the offending span underlined:
+
+	[line 2] at '+': type string doesn't support addition
+	 2 | var x = "a" + 1;
+	   |             ^
+
+Errors raised inside functions are preceded by a traceback of the
+calls that led to them. Errors that don't point into source are
+written on their own, and each error of an ErrorList is rendered
+in turn. Besides *LoxError, errors with a LoxError method giving
+their *LoxError form are understood.
+*/
+func Render(w io.Writer, source string, err error, color bool) {
+	if list, ok := err.(ErrorList); ok {
+		for _, e := range list {
+			Render(w, source, e, color)
+		}
+		return
+	}
+	paint := func(c, s string) string {
+		if !color {
+			return s
+		}
+		return c + s + colorReset
+	}
+	le, ok := err.(*LoxError)
+	if conv, isConv := err.(interface{ LoxError() *LoxError }); isConv {
+		le, ok = conv.LoxError(), true
+	}
+	if ok && len(le.Trace) > 0 {
+		renderTrace(w, le)
+	}
+	fmt.Fprintln(w, paint(colorRed, strings.TrimRight(err.Error(), "\n")))
+	if !ok || le.Span.Column == 0 {
+		return
+	}
+	lines := strings.Split(source, "\n")
+	if le.Span.Line < 1 || le.Span.Line > len(lines) {
+		return
+	}
+	line := strings.TrimRight(lines[le.Span.Line-1], "\r")
+	gutter := fmt.Sprint(le.Span.Line)
+	fmt.Fprintf(w, " %s %s\n", paint(colorBlue, gutter+" |"), line)
+
+	// Line the carets up with the source, tabs included.
+	var underline strings.Builder
+	col := 1
+	for _, r := range line {
+		if col >= le.Span.Column {
+			break
+		}
+		if r == '\t' {
+			underline.WriteRune('\t')
+		} else {
+			underline.WriteRune(' ')
+		}
+		col++
+	}
+	width := le.Span.EndColumn - le.Span.Column
+	if width < 1 {
+		width = 1
+	}
+	underline.WriteString(paint(colorRed, strings.Repeat("^", width)))
+	fmt.Fprintf(w, " %s %s\n", paint(colorBlue, strings.Repeat(" ", len(gutter))+" |"), underline.String())
+}
+
+// renderTrace writes the calls leading up to le, most recent call
+// last. Runs of the same line, as in deep recursion, are collapsed.
+//
+//	Traceback (most recent call last):
+//	  line 9, in <script>
+//	  line 5, in outer (declared on line 4)
+//	  line 2, in inner (declared on line 1)
+func renderTrace(w io.Writer, le *LoxError) {
+	var lines []string
+	// Functions called from Go have no call site in a script.
+	if le.Trace[0].Line > 0 {
+		lines = append(lines, fmt.Sprintf("  line %d, in <script>", le.Trace[0].Line))
+	}
+	for i, frame := range le.Trace {
+		line := le.LineNumber
+		if i+1 < len(le.Trace) {
+			line = le.Trace[i+1].Line
+		}
+		lines = append(lines, fmt.Sprintf("  line %d, in %s (declared on line %d)", line, frame.Function, frame.DeclLine))
+	}
+
+	fmt.Fprintln(w, "Traceback (most recent call last):")
+	const maxRepeats = 3
+	for i := 0; i < len(lines); {
+		n := 1
+		for i+n < len(lines) && lines[i+n] == lines[i] {
+			n++
+		}
+		for j := 0; j < n && j < maxRepeats; j++ {
+			fmt.Fprintln(w, lines[i])
+		}
+		if n > maxRepeats {
+			fmt.Fprintf(w, "  [previous line repeated %d more times]\n", n-maxRepeats)
+		}
+		i += n
+	}
+}
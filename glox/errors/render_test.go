@@ -0,0 +1,87 @@
+package errors
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	err := &LoxError{
+		LineNumber: 2,
+		Context:    "+",
+		Message:    "type string doesn't support addition",
+		Span:       Span{Line: 2, Column: 12, EndColumn: 13},
+	}
+	var buf bytes.Buffer
+	Render(&buf, "var a = 1;\n\tvar x = a + \"b\";\n", err, false)
+	assert.Equal(t, ""+
+		"[line 2] at '+': type string doesn't support addition\n"+
+		" 2 | \tvar x = a + \"b\";\n"+
+		"   | \t          ^\n", buf.String())
+}
+
+func TestRender_Color(t *testing.T) {
+	err := &LoxError{LineNumber: 1, Context: "foo", Message: "undefined variable", Span: Span{Line: 1, Column: 1, EndColumn: 4}}
+	var buf bytes.Buffer
+	Render(&buf, "foo;", err, true)
+	assert.Contains(t, buf.String(), colorRed+"^^^"+colorReset)
+}
+
+func TestRender_NoSpan(t *testing.T) {
+	var buf bytes.Buffer
+	Render(&buf, "foo;", fmt.Errorf("no such file"), false)
+	assert.Equal(t, "no such file\n", buf.String())
+
+	buf.Reset()
+	Render(&buf, "foo;", NewLoxError(1, "foo", "oops"), false)
+	assert.Equal(t, "[line 1] at 'foo': oops\n", buf.String())
+}
+
+func TestRender_ErrorList(t *testing.T) {
+	list := ErrorList{
+		&LoxError{LineNumber: 1, Context: "=", Message: "a", Span: Span{Line: 1, Column: 5, EndColumn: 6}},
+		&LoxError{LineNumber: 2, Context: ")", Message: "b", Span: Span{Line: 2, Column: 1, EndColumn: 2}},
+	}
+	var buf bytes.Buffer
+	Render(&buf, "var = 1;\n);", list, false)
+	assert.Equal(t, ""+
+		"[line 1] at '=': a\n"+
+		" 1 | var = 1;\n"+
+		"   |     ^\n"+
+		"[line 2] at ')': b\n"+
+		" 2 | );\n"+
+		"   | ^\n", buf.String())
+	assert.Equal(t, "[line 1] at '=': a\n[line 2] at ')': b\n", list.Error())
+	assert.Nil(t, ErrorList(nil).Err())
+}
+
+func TestRender_Trace(t *testing.T) {
+	err := &LoxError{
+		LineNumber: 2,
+		Context:    "/",
+		Message:    "divide by 0",
+		Trace: []Frame{
+			{Function: "outer", Line: 7, DeclLine: 4},
+			{Function: "inner", Line: 5, DeclLine: 1},
+			{Function: "inner", Line: 2, DeclLine: 1},
+			{Function: "inner", Line: 2, DeclLine: 1},
+			{Function: "inner", Line: 2, DeclLine: 1},
+			{Function: "inner", Line: 2, DeclLine: 1},
+			{Function: "inner", Line: 2, DeclLine: 1},
+		},
+	}
+	var buf bytes.Buffer
+	Render(&buf, "", err, false)
+	assert.Equal(t, ""+
+		"Traceback (most recent call last):\n"+
+		"  line 7, in <script>\n"+
+		"  line 5, in outer (declared on line 4)\n"+
+		"  line 2, in inner (declared on line 1)\n"+
+		"  line 2, in inner (declared on line 1)\n"+
+		"  line 2, in inner (declared on line 1)\n"+
+		"  [previous line repeated 3 more times]\n"+
+		"[line 2] at '/': divide by 0\n", buf.String())
+}
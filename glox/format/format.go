@@ -0,0 +1,495 @@
+/*
+Package format prints lox programs in a canonical layout.
+
+Statements go on lines of their own, indented by a tab for each
+block they're nested in, with the braces of blocks, functions and
+classes on the line that opens them. Comments are kept: a comment
+on a line of its own stays before the statement that follows it,
+and a comment after a statement stays at the end of its line.
+Comments inside a statement, like between the arguments of a call,
+have nowhere to go, so programs with them aren't formatted. A
+single blank line is kept wherever the source separates statements
+with one or more. Formatting formatted source doesn't change it.
+*/
+package format
+
+import (
+	"bytes"
+	"glox/ast"
+	"glox/lexer"
+	"glox/parser"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Source formats the lox program src. Programs with syntax errors
+// can't be formatted, and the error describing them is returned.
+func Source(src []byte) ([]byte, error) {
+	tokens, comments, err := lexer.ScanSourceWithComments(string(src))
+	if err != nil {
+		return nil, err
+	}
+	stmts, ranges, err := parser.ParseRanges(tokens)
+	if err != nil {
+		return nil, err
+	}
+	p := &printer{tokens: tokens, comments: comments, ranges: ranges}
+	p.stmts(stmts, tokens[len(tokens)-1])
+	if p.err != nil {
+		return nil, p.err
+	}
+	p.buf.WriteByte('\n')
+	return bytes.TrimLeft(p.buf.Bytes(), "\n"), nil
+}
+
+type printer struct {
+	buf    bytes.Buffer
+	indent int
+	tokens []lexer.Token
+	// Comments not printed yet, in source order.
+	comments []lexer.Token
+	ranges   map[ast.Stmt]parser.Range
+	// The first comment found inside a statement.
+	err error
+	// Source line of the last statement or comment printed,
+	// to tell where the source had blank lines.
+	line int
+}
+
+// newline starts a new line at the current indentation.
+func (p *printer) newline() {
+	p.buf.WriteByte('\n')
+	for i := 0; i < p.indent; i++ {
+		p.buf.WriteByte('\t')
+	}
+}
+
+// startLine starts the line of something from line of the source,
+// after a blank line if the source had one there. The first thing
+// in a block never has a blank line before it.
+func (p *printer) startLine(line int, first bool) {
+	if !first && line > p.line+1 {
+		p.buf.WriteByte('\n')
+	}
+	p.newline()
+}
+
+func (p *printer) write(s ...string) {
+	for _, s := range s {
+		p.buf.WriteString(s)
+	}
+}
+
+// stmts prints a list of statements on lines of their own, with
+// the comments before end, the token that closes the list.
+func (p *printer) stmts(stmts []ast.Stmt, end lexer.Token) {
+	p.list(stmts, end, p.stmt)
+}
+
+// list prints the statements of a list with print.
+func (p *printer) list(stmts []ast.Stmt, end lexer.Token, print func(ast.Stmt)) {
+	// Comments before the token opening the list, like the '{' of
+	// a block, are in the statement the list is part of.
+	open := end
+	if len(stmts) > 0 {
+		open = p.ranges[stmts[0]].First
+	}
+	for p.commentsBefore(open) && p.comments[0].Offset < p.before(open) {
+		p.inside()
+	}
+	first := true
+	for i, stmt := range stmts {
+		r := p.ranges[stmt]
+		for len(p.comments) > 0 && p.comments[0].Offset < r.First.Offset {
+			p.comment(first)
+			first = false
+		}
+		p.startLine(r.First.Line, first)
+		first = false
+		print(stmt)
+		p.line = r.Last.Line
+		next := end
+		if i+1 < len(stmts) {
+			next = p.ranges[stmts[i+1]].First
+		}
+		p.trailing(r.Last, next)
+	}
+	for len(p.comments) > 0 && p.comments[0].Offset < end.Offset {
+		p.comment(first)
+		first = false
+	}
+	p.line = end.Line
+}
+
+// comment prints the next comment on a line of its own.
+func (p *printer) comment(first bool) {
+	c := p.comments[0]
+	p.comments = p.comments[1:]
+	p.startLine(c.Line, first)
+	p.write(c.Lexeme)
+	p.line = endLine(c)
+}
+
+// inside drops the next comment, which is inside a statement,
+// and fails the formatting of the program.
+func (p *printer) inside() {
+	c := p.comments[0]
+	p.comments = p.comments[1:]
+	if p.err == nil {
+		p.err = c.MakeError("can't format a comment inside a statement, move it before or after the statement")
+	}
+}
+
+// before returns the offset of the token before tok, or -1 if
+// tok is the first.
+func (p *printer) before(tok lexer.Token) int {
+	i := sort.Search(len(p.tokens), func(i int) bool { return p.tokens[i].Offset >= tok.Offset })
+	if i == 0 {
+		return -1
+	}
+	return p.tokens[i-1].Offset
+}
+
+// trailing prints the comments that follow a statement ending with
+// last on its line. Those inside it are left over from its parts.
+// Comments after next, the start of the next statement or the token
+// closing the statement's list, belong to what next starts.
+func (p *printer) trailing(last, next lexer.Token) {
+	for len(p.comments) > 0 {
+		c := p.comments[0]
+		if c.Offset > last.Offset && (c.Line != p.line || c.Offset > next.Offset) {
+			return
+		}
+		if c.Offset < last.Offset {
+			p.inside()
+			continue
+		}
+		p.comments = p.comments[1:]
+		if strings.HasPrefix(p.lastLine(), "//") {
+			p.newline()
+		} else {
+			p.write(" ")
+		}
+		p.write(c.Lexeme)
+		p.line = endLine(c)
+	}
+}
+
+// lastLine returns what's been printed on the current line,
+// without its indentation and up to the last comment on it.
+func (p *printer) lastLine() string {
+	out := p.buf.Bytes()
+	line := string(out[bytes.LastIndexByte(out, '\n')+1:])
+	if i := strings.LastIndex(line, "//"); i >= 0 {
+		return line[i:]
+	}
+	return strings.TrimLeft(line, "\t")
+}
+
+func endLine(c lexer.Token) int {
+	return c.Line + strings.Count(c.Lexeme, "\n")
+}
+
+// block prints statements between braces, starting on the current
+// line. end is the closing brace.
+func (p *printer) block(stmts []ast.Stmt, end lexer.Token) {
+	p.braces(stmts, end, p.stmt)
+}
+
+func (p *printer) braces(stmts []ast.Stmt, end lexer.Token, print func(ast.Stmt)) {
+	p.write("{")
+	if len(stmts) == 0 && !p.commentsBefore(end) {
+		p.write("}")
+		return
+	}
+	p.indent++
+	p.list(stmts, end, print)
+	p.indent--
+	p.newline()
+	p.write("}")
+}
+
+func (p *printer) commentsBefore(tok lexer.Token) bool {
+	return len(p.comments) > 0 && p.comments[0].Offset < tok.Offset
+}
+
+// body prints the statement of an if or a loop, a block on the
+// current line and anything else indented on the next one.
+func (p *printer) body(stmt ast.Stmt) {
+	if b, ok := stmt.(*ast.Block); ok && !p.isFor(b) {
+		p.write(" ")
+		p.block(b.Statements, p.ranges[b].Last)
+		return
+	}
+	p.indent++
+	p.newline()
+	p.stmt(stmt)
+	p.indent--
+}
+
+// isFor reports whether b is the block a for loop with an
+// initializer is parsed into, rather than a block in the source.
+func (p *printer) isFor(b *ast.Block) bool {
+	return p.ranges[b].First.Type == lexer.FOR
+}
+
+func (p *printer) stmt(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case *ast.Expression:
+		p.expr(s.Expression)
+		p.write(";")
+	case *ast.Print:
+		p.write("print ")
+		p.expr(s.Expression)
+		p.write(";")
+	case *ast.Var:
+		p.write("var ", s.Name.Lexeme)
+		if s.Initializer != nil {
+			p.write(" = ")
+			p.expr(s.Initializer)
+		}
+		p.write(";")
+	case *ast.Return:
+		p.write("return")
+		if s.Expression != nil {
+			p.write(" ")
+			p.expr(s.Expression)
+		}
+		p.write(";")
+	case *ast.Throw:
+		p.write("throw ")
+		p.expr(s.Expression)
+		p.write(";")
+	case *ast.Break:
+		if s.Continue {
+			p.write("continue;")
+		} else {
+			p.write("break;")
+		}
+	case *ast.Import:
+		// The lexeme of a string keeps its escape sequences.
+		p.write("import \"", s.Path.Lexeme, "\" as ", s.Name.Lexeme, ";")
+	case *ast.Block:
+		if p.isFor(s) {
+			p.forLoop(s.Statements[0], s.Statements[1].(*ast.While))
+		} else {
+			p.block(s.Statements, p.ranges[s].Last)
+		}
+	case *ast.If:
+		p.write("if (")
+		p.expr(s.Condition)
+		p.write(")")
+		p.body(s.ThenBranch)
+		if s.ElseBranch == nil {
+			break
+		}
+		if _, ok := s.ThenBranch.(*ast.Block); ok && !p.isFor(s.ThenBranch.(*ast.Block)) {
+			p.write(" else")
+		} else {
+			p.newline()
+			p.write("else")
+		}
+		if elif, ok := s.ElseBranch.(*ast.If); ok {
+			p.write(" ")
+			p.stmt(elif)
+		} else {
+			p.body(s.ElseBranch)
+		}
+	case *ast.While:
+		if s.Keyword.Type == lexer.FOR {
+			p.forLoop(nil, s)
+			break
+		}
+		p.write("while (")
+		p.expr(s.Condition)
+		p.write(")")
+		p.body(s.Do)
+	case *ast.Try:
+		p.write("try ")
+		p.block(s.Body.Statements, p.ranges[s.Body].Last)
+		if s.Catch != nil {
+			p.write(" catch (", s.CatchName.Lexeme, ") ")
+			p.block(s.Catch.Statements, p.ranges[s.Catch].Last)
+		}
+		if s.Finally != nil {
+			p.write(" finally ")
+			p.block(s.Finally.Statements, p.ranges[s.Finally].Last)
+		}
+	case *ast.Function:
+		p.write("fun ")
+		p.function(s)
+	case *ast.Class:
+		p.write("class ", s.Name.Lexeme, " ")
+		if s.Superclass != nil {
+			p.write("< ", s.Superclass.Name.Lexeme, " ")
+		}
+		methods := make([]ast.Stmt, len(s.Methods))
+		for i, m := range s.Methods {
+			methods[i] = m
+		}
+		p.braces(methods, p.ranges[s].Last, func(m ast.Stmt) {
+			p.function(m.(*ast.Function))
+		})
+	}
+}
+
+// forLoop prints the loop a for statement is parsed into,
+// along with its initializer if it has one.
+func (p *printer) forLoop(init ast.Stmt, loop *ast.While) {
+	p.write("for (")
+	if init != nil {
+		p.stmt(init)
+	} else {
+		p.write(";")
+	}
+	p.write(" ")
+	p.expr(loop.Condition)
+	p.write(";")
+	if loop.Increment != nil {
+		p.write(" ")
+		p.expr(loop.Increment)
+	}
+	p.write(")")
+	p.body(loop.Do)
+}
+
+// function prints the name, parameters and body of a function.
+func (p *printer) function(fn *ast.Function) {
+	p.write(fn.Name.Lexeme)
+	p.params(fn.Params)
+	p.write(" ")
+	p.block(fn.Body, p.ranges[fn].Last)
+}
+
+func (p *printer) params(params []lexer.Token) {
+	p.write("(")
+	for i, param := range params {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.write(param.Lexeme)
+	}
+	p.write(")")
+}
+
+func (p *printer) exprs(exprs []ast.Expr) {
+	for i, e := range exprs {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expr(e)
+	}
+}
+
+func (p *printer) expr(expr ast.Expr) {
+	switch e := expr.(type) {
+	case *ast.Literal:
+		p.write(literal(e.Value))
+	case *ast.Variable:
+		p.write(e.Name.Lexeme)
+	case *ast.This:
+		p.write("this")
+	case *ast.Super:
+		p.write("super.", e.Method.Lexeme)
+	case *ast.Grouping:
+		p.write("(")
+		p.expr(e.Expression)
+		p.write(")")
+	case *ast.Unary:
+		p.write(e.Operator.Lexeme)
+		p.expr(e.Right)
+	case *ast.Binary:
+		p.expr(e.Left)
+		p.write(" ", e.Operator.Lexeme, " ")
+		p.expr(e.Right)
+	case *ast.Logical:
+		p.expr(e.Left)
+		p.write(" ", e.Operator.Lexeme, " ")
+		p.expr(e.Right)
+	case *ast.Assignment:
+		p.write(e.Name.Lexeme, " = ")
+		p.expr(e.Value)
+	case *ast.Call:
+		p.expr(e.Callee)
+		p.write("(")
+		p.exprs(e.Args)
+		p.write(")")
+	case *ast.Get:
+		p.expr(e.Object)
+		p.write(".", e.Name.Lexeme)
+	case *ast.Set:
+		p.expr(e.Object)
+		p.write(".", e.Name.Lexeme, " = ")
+		p.expr(e.Value)
+	case *ast.Index:
+		p.expr(e.Object)
+		p.write("[")
+		p.expr(e.Index)
+		p.write("]")
+	case *ast.IndexSet:
+		p.expr(e.Object)
+		p.write("[")
+		p.expr(e.Index)
+		p.write("] = ")
+		p.expr(e.Value)
+	case *ast.List:
+		p.write("[")
+		p.exprs(e.Elements)
+		p.write("]")
+	case *ast.Map:
+		p.write("{")
+		for i := range e.Keys {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expr(e.Keys[i])
+			p.write(": ")
+			p.expr(e.Values[i])
+		}
+		p.write("}")
+	case *ast.Lambda:
+		fn := e.Function
+		if ret, ok := arrowBody(fn); ok {
+			p.params(fn.Params)
+			p.write(" => ")
+			p.expr(ret.Expression)
+			break
+		}
+		p.write("fun ")
+		p.params(fn.Params)
+		p.write(" ")
+		p.block(fn.Body, p.ranges[fn].Last)
+	}
+}
+
+// arrowBody returns the return statement that makes up the
+// body of fn, if it's an arrow function.
+func arrowBody(fn *ast.Function) (*ast.Return, bool) {
+	if len(fn.Body) != 1 {
+		return nil, false
+	}
+	ret, ok := fn.Body[0].(*ast.Return)
+	return ret, ok && ret.Token.Type == lexer.ARROW
+}
+
+func literal(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return quote(v)
+	}
+	return ""
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// quote returns s as a lox string literal.
+func quote(s string) string {
+	return `"` + escaper.Replace(s) + `"`
+}
@@ -0,0 +1,246 @@
+package format
+
+import (
+	"fmt"
+	"glox/errors"
+	"glox/lexer"
+	"glox/parser"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "spacing",
+			src:  "var a=1;print a+-2*(3);",
+			want: "var a = 1;\nprint a + -2 * (3);\n",
+		},
+		{
+			name: "blocks",
+			src:  "fun f(a,b){if(a){return b;}else return a;}",
+			want: "fun f(a, b) {\n\tif (a) {\n\t\treturn b;\n\t} else\n\t\treturn a;\n}\n",
+		},
+		{
+			name: "empty block",
+			src:  "while (x) {   }",
+			want: "while (x) {}\n",
+		},
+		{
+			name: "for loops",
+			src:  "for (var i = 0; i < 3; i = i + 1) { print i; }\nfor (;i < 3;) print i;",
+			want: "for (var i = 0; i < 3; i = i + 1) {\n\tprint i;\n}\nfor (; i < 3;)\n\tprint i;\n",
+		},
+		{
+			name: "else if",
+			src:  "if (a) { print 1; } else if (b) { print 2; } else { print 3; }",
+			want: "if (a) {\n\tprint 1;\n} else if (b) {\n\tprint 2;\n} else {\n\tprint 3;\n}\n",
+		},
+		{
+			name: "classes",
+			src:  "class A < B { init(x) { this.x = x; } get() { return super.get(); } }",
+			want: "class A < B {\n\tinit(x) {\n\t\tthis.x = x;\n\t}\n\tget() {\n\t\treturn super.get();\n\t}\n}\n",
+		},
+		{
+			name: "literals",
+			src:  `var s = "a\"b\\c\n"; var n = 1.50; var l = [nil, true, {"k": 2}];`,
+			want: "var s = \"a\\\"b\\\\c\\n\";\nvar n = 1.5;\nvar l = [nil, true, {\"k\": 2}];\n",
+		},
+		{
+			name: "functions as values",
+			src:  "var f = (x, y) => x + y; var g = fun () { return 1; };",
+			want: "var f = (x, y) => x + y;\nvar g = fun () {\n\treturn 1;\n};\n",
+		},
+		{
+			name: "try",
+			src:  `try { throw "x"; } catch (e) { print e; } finally { print "done"; }`,
+			want: "try {\n\tthrow \"x\";\n} catch (e) {\n\tprint e;\n} finally {\n\tprint \"done\";\n}\n",
+		},
+		{
+			name: "blank lines",
+			src:  "var a = 1;\n\n\n\nvar b = 2;\nvar c = 3;\n",
+			want: "var a = 1;\n\nvar b = 2;\nvar c = 3;\n",
+		},
+		{
+			name: "comments",
+			src: `// header
+
+import "lib/x" as x;   // trailing
+fun f() {
+    // leading
+    return 1; /* block */
+    // before the brace
+} // after the brace
+/* at the end */`,
+			want: `// header
+
+import "lib/x" as x; // trailing
+fun f() {
+	// leading
+	return 1; /* block */
+	// before the brace
+} // after the brace
+/* at the end */
+`,
+		},
+		{
+			name: "comments between statements on a line",
+			src:  "var a = 1; /* mid */ var b = 2; // tail",
+			want: "var a = 1; /* mid */\nvar b = 2; // tail\n",
+		},
+		{
+			name: "comments in empty blocks",
+			src:  "while (x) {\n// nothing yet\n}",
+			want: "while (x) {\n\t// nothing yet\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Source([]byte(tt.src))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(out))
+		})
+	}
+}
+
+func TestSource_SyntaxError(t *testing.T) {
+	_, err := Source([]byte("var = 1;"))
+	assert.Error(t, err)
+}
+
+func TestSource_CommentInsideStatement(t *testing.T) {
+	tests := map[string]struct {
+		src  string
+		line int
+	}{
+		"call":      {"print 1;\nf(\n\t1, // the first\n\t2\n);", 3},
+		"list":      {"var l = [\n\t/* none yet */\n];", 2},
+		"in a body": {"fun f() {\n\treturn g(1, /* x */ 2);\n}", 2},
+		"condition": {"if (a // why\n) {\n\tprint 1;\n}", 1},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Source([]byte(tt.src))
+			require.Error(t, err)
+			assert.ErrorContains(t, err, "can't format a comment inside a statement")
+			assert.Equal(t, tt.line, err.(*errors.LoxError).LineNumber)
+		})
+	}
+
+	// Comments after the brace opening a block are in the block.
+	out, err := Source([]byte("if (a) { // why\n\tprint 1;\n}"))
+	require.NoError(t, err)
+	assert.Equal(t, "if (a) {\n\t// why\n\tprint 1;\n}\n", string(out))
+}
+
+// Formatting the lox files of the repository must keep their meaning,
+// which is checked by comparing their syntax trees, and formatting
+// them again must not change them.
+func TestSource_RoundTrip(t *testing.T) {
+	var files []string
+	for _, dir := range []string{"..", filepath.Join("..", "runtime", "testdata"), filepath.Join("..", "loxtest", "testdata")} {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// Only the examples at the top of the module.
+			if d.IsDir() && path != dir && dir == ".." {
+				return fs.SkipDir
+			}
+			if !d.IsDir() && filepath.Ext(path) == ".lx" {
+				files = append(files, path)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+	}
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		file := file
+		t.Run(filepath.ToSlash(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			require.NoError(t, err)
+			want, err := dump(src)
+			if err != nil {
+				t.Skip("doesn't parse:", err)
+			}
+
+			out, err := Source(src)
+			require.NoError(t, err)
+			got, err := dump(out)
+			require.NoError(t, err, string(out))
+			assert.Equal(t, want, got)
+
+			again, err := Source(out)
+			require.NoError(t, err)
+			assert.Equal(t, string(out), string(again))
+		})
+	}
+}
+
+// dump describes the syntax tree of src, leaving out where
+// its tokens are in the source.
+func dump(src []byte) (string, error) {
+	tokens, err := lexer.ScanSource(string(src))
+	if err != nil {
+		return "", err
+	}
+	stmts, err := parser.Parse(tokens)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, stmt := range stmts {
+		dumpValue(&sb, reflect.ValueOf(stmt))
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+var tokenType = reflect.TypeOf(lexer.Token{})
+
+func dumpValue(sb *strings.Builder, v reflect.Value) {
+	switch {
+	case v.Type() == tokenType:
+		tok := v.Interface().(lexer.Token)
+		if strings.HasPrefix(tok.Lexeme, "anonymous@") {
+			tok.Lexeme = "anonymous"
+		}
+		fmt.Fprintf(sb, "%s(%s)", tok.Type, tok.Lexeme)
+	case v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr:
+		if v.IsNil() {
+			sb.WriteString("nil")
+			return
+		}
+		dumpValue(sb, v.Elem())
+	case v.Kind() == reflect.Struct:
+		fmt.Fprintf(sb, "%s{", v.Type().Name())
+		for i := 0; i < v.NumField(); i++ {
+			fmt.Fprintf(sb, "%s: ", v.Type().Field(i).Name)
+			dumpValue(sb, v.Field(i))
+			sb.WriteString(" ")
+		}
+		sb.WriteString("}")
+	case v.Kind() == reflect.Slice:
+		sb.WriteString("[")
+		for i := 0; i < v.Len(); i++ {
+			dumpValue(sb, v.Index(i))
+			sb.WriteString(" ")
+		}
+		sb.WriteString("]")
+	default:
+		fmt.Fprintf(sb, "%#v", v.Interface())
+	}
+}
@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"glox/errors"
 	"strings"
 	"unicode/utf8"
 )
@@ -12,6 +13,8 @@ type Lexer struct {
 	// index of the start of current lexeme
 	lexemeStart     int
 	lexemeStartLine int
+	// index of the start of the line the lexeme starts on
+	lineStart int
 
 	// width of last character
 	lastWidth int
@@ -19,6 +22,10 @@ type Lexer struct {
 	current int
 	// currentLine number
 	currentLine int
+
+	// Comments are only kept when keepComments is set.
+	keepComments bool
+	comments     []Token
 }
 
 func NewLexer(source string) *Lexer {
@@ -30,11 +37,15 @@ func NewLexer(source string) *Lexer {
 }
 
 func (l *Lexer) Next() rune {
+	// Nothing is taken at the end or at invalid UTF-8,
+	// so there's nothing for Back to put back either.
 	if l.IsAtEnd() {
+		l.lastWidth = 0
 		return utf8.RuneError
 	}
 	r, size := utf8.DecodeRuneInString(l.source[l.current:])
 	if r == utf8.RuneError {
+		l.lastWidth = 0
 		return r
 	}
 	l.lastWidth = size
@@ -67,23 +78,59 @@ func (l *Lexer) Peek() rune {
 }
 
 func (l *Lexer) Emit(typ TokenType, literal any) {
+	l.tokens = append(l.tokens, l.token(typ, literal))
+	l.Discard()
+}
+
+// EmitComment keeps the current lexeme as a comment, if
+// comments are being kept, and discards it otherwise.
+func (l *Lexer) EmitComment() {
+	if l.keepComments {
+		l.comments = append(l.comments, l.token(COMMENT, nil))
+	}
+	l.Discard()
+}
+
+// token makes a token of the current lexeme.
+func (l *Lexer) token(typ TokenType, literal any) Token {
 	var sb strings.Builder
 	sb.WriteString(l.source[l.lexemeStart:l.current])
 	res := sb.String()
-	t := Token{
-		Type:   typ,
-		Line:   l.lexemeStartLine,
-		Lexeme: res,
-		Value:  literal,
+	span := l.Span()
+	return Token{
+		Type:      typ,
+		Line:      l.lexemeStartLine,
+		Lexeme:    res,
+		Value:     literal,
+		Offset:    span.Offset,
+		Column:    span.Column,
+		EndColumn: span.EndColumn,
 	}
-	l.tokens = append(l.tokens, t)
-	l.Discard()
 }
 
 func (l *Lexer) Discard() {
 	l.lexemeStart = l.current
 	l.lexemeStartLine = l.currentLine
 	l.lastWidth = 0
+	if i := strings.LastIndexByte(l.source[l.lineStart:l.lexemeStart], '\n'); i >= 0 {
+		l.lineStart += i + 1
+	}
+}
+
+// Span returns the position of the current lexeme. Lexemes spanning
+// several lines are cut off at the end of their first line.
+func (l *Lexer) Span() errors.Span {
+	lexeme := l.Lexeme()
+	if i := strings.IndexByte(lexeme, '\n'); i >= 0 {
+		lexeme = lexeme[:i]
+	}
+	column := utf8.RuneCountInString(l.source[l.lineStart:l.lexemeStart]) + 1
+	return errors.Span{
+		Line:      l.lexemeStartLine,
+		Column:    column,
+		EndColumn: column + utf8.RuneCountInString(lexeme),
+		Offset:    l.lexemeStart,
+	}
 }
 
 func (l *Lexer) IsAtEnd() bool {
@@ -41,6 +41,9 @@ func TestLexer_Next_AtEnd(t *testing.T) {
 	c := l.current
 	assert.Equal(t, utf8.RuneError, l.Next())
 	assert.Equal(t, c, l.current)
+	// Backing up after the end puts nothing back.
+	l.Back()
+	assert.Equal(t, c, l.current)
 }
 
 func TestLexer_Peek_AtEnd(t *testing.T) {
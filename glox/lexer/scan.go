@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"fmt"
+	"glox/errors"
 	"strconv"
 	"strings"
 	"unicode"
@@ -11,6 +12,7 @@ import (
 type ScanError struct {
 	Line    int
 	Message string
+	Span    errors.Span
 }
 
 func (s *ScanError) Error() string {
@@ -24,8 +26,34 @@ func NewScanError(line int, msg string) *ScanError {
 	}
 }
 
+// scanError makes a ScanError pointing at the current lexeme,
+// on the line it starts on.
+func (l *Lexer) scanError(msg string) *ScanError {
+	err := NewScanError(l.lexemeStartLine, msg)
+	err.Span = l.Span()
+	return err
+}
+
+// LoxError converts the error to the type other stages report.
+func (s *ScanError) LoxError() *errors.LoxError {
+	return &errors.LoxError{LineNumber: s.Line, Message: s.Message, Span: s.Span}
+}
+
 func ScanSource(source string) ([]Token, error) {
+	return scan(NewLexer(source))
+}
+
+// ScanSourceWithComments scans source like ScanSource, also returning
+// its comments as COMMENT tokens, in the order they appear. Tools that
+// rewrite source, like the formatter, use them to keep the comments.
+func ScanSourceWithComments(source string) ([]Token, []Token, error) {
 	l := NewLexer(source)
+	l.keepComments = true
+	tokens, err := scan(l)
+	return tokens, l.comments, err
+}
+
+func scan(l *Lexer) ([]Token, error) {
 	emitTernary := func(r rune, ifTrue TokenType, ifFalse TokenType) {
 		if l.Next() == r {
 			l.Emit(ifTrue, nil)
@@ -56,6 +84,11 @@ func ScanSource(source string) ([]Token, error) {
 			emitTernary('=', BANG_EQUAL, BANG)
 			continue
 		case '=':
+			if l.Next() == '>' {
+				l.Emit(ARROW, nil)
+				continue
+			}
+			l.Back()
 			emitTernary('=', DOUBLE_EQUAL, EQUAL)
 			continue
 		case '<':
@@ -81,7 +114,7 @@ func ScanSource(source string) ([]Token, error) {
 				l.Next()
 			}
 			if unicode.IsLetter(l.Peek()) {
-				return nil, NewScanError(l.currentLine, "numbers must be separated from letters by whitespace")
+				return nil, l.scanError("numbers must be separated from letters by whitespace")
 			}
 			v, _ := strconv.ParseFloat(l.Lexeme(), 64)
 			l.Emit(NUMBER, v)
@@ -110,7 +143,7 @@ func ScanSource(source string) ([]Token, error) {
 				for l.Peek() != '\n' && !l.IsAtEnd() {
 					l.Next()
 				}
-				l.Discard()
+				l.EmitComment()
 			} else if l.Peek() == '*' {
 				l.Next()
 				if err := BlockComment(l); err != nil {
@@ -122,7 +155,7 @@ func ScanSource(source string) ([]Token, error) {
 			continue
 		}
 
-		return nil, NewScanError(l.currentLine, fmt.Sprintf("unexpected character: %c", r))
+		return nil, l.scanError(fmt.Sprintf("unexpected character: %c", r))
 	}
 	l.Emit(EOF, nil)
 	return l.tokens, nil
@@ -145,7 +178,7 @@ func StringLiteral(l *Lexer) error {
 		if c == '\\' {
 			actual := EscapeSequence(l)
 			if actual == utf8.RuneError {
-				return NewScanError(l.currentLine, fmt.Sprintf("invalid escape sequence"))
+				return l.scanError(fmt.Sprintf("invalid escape sequence"))
 			}
 			sb.WriteRune(actual)
 		} else {
@@ -153,7 +186,13 @@ func StringLiteral(l *Lexer) error {
 		}
 	}
 	if l.IsAtEnd() {
-		return NewScanError(l.currentLine, "unterminated string")
+		// The lexeme starts after the opening quote, which is
+		// where the string that isn't closed is.
+		err := l.scanError("unterminated string")
+		err.Span.Column--
+		err.Span.EndColumn = err.Span.Column + 1
+		err.Span.Offset--
+		return err
 	}
 	l.Emit(STRING, sb.String())
 
@@ -210,8 +249,8 @@ func BlockComment(l *Lexer) error {
 		}
 	}
 	if nestLevel > 0 {
-		return NewScanError(l.currentLine, "unterminated block comment")
+		return l.scanError("unterminated block comment")
 	}
-	l.Discard()
+	l.EmitComment()
 	return nil
 }
@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"fmt"
+	"glox/errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -47,6 +48,22 @@ func TestScan_BinaryOp(t *testing.T) {
 	}
 }
 
+func TestScan_OperatorAtEnd(t *testing.T) {
+	tests := map[string]TokenType{
+		"a =":  EQUAL,
+		"a !":  BANG,
+		"a <":  LT,
+		"a >":  GT,
+		"a ==": DOUBLE_EQUAL,
+		"a =>": ARROW,
+	}
+	for src, typ := range tests {
+		t.Run(src, func(t *testing.T) {
+			assertScansTypes(t, []TokenType{IDENT, typ, EOF}, src)
+		})
+	}
+}
+
 func TestScan_Comment(t *testing.T) {
 	program := "// this is a comment\nprint a / b;"
 	assertScansTypes(t, []TokenType{
@@ -82,3 +99,79 @@ func TestStringLiteral(t *testing.T) {
 		"\"Hello\n\tWorld!\" + \"How are you????\"",
 	)
 }
+
+func TestScan_Brackets(t *testing.T) {
+	assertScansTypes(t, []TokenType{
+		IDENT, LEFT_BRACKET, NUMBER, RIGHT_BRACKET, EQUAL, LEFT_BRACKET, RIGHT_BRACKET, SEMICOLON, EOF,
+	}, "xs[0] = [];")
+}
+
+func TestScan_Arrow(t *testing.T) {
+	assertScansTypes(t, []TokenType{
+		LEFT_PAREN, IDENT, RIGHT_PAREN, ARROW, IDENT, DOUBLE_EQUAL, EQUAL, NUMBER, EOF,
+	}, "(a) => a == = 1")
+}
+
+func TestScan_Columns(t *testing.T) {
+	toks, err := ScanSource("var s = \"é\" +\n\t1; /* a\nb */ x")
+	assert.NoError(t, err)
+	type pos struct{ line, col, end, offset int }
+	var got []pos
+	for _, tok := range toks {
+		got = append(got, pos{tok.Line, tok.Column, tok.EndColumn, tok.Offset})
+	}
+	assert.Equal(t, []pos{
+		{1, 1, 4, 0},    // var
+		{1, 5, 6, 4},    // s
+		{1, 7, 8, 6},    // =
+		{1, 10, 11, 9},  // é, without its quotes
+		{1, 13, 14, 13}, // +
+		{2, 2, 3, 16},   // 1
+		{2, 3, 4, 17},   // ;
+		{3, 6, 7, 29},   // x
+		{3, 7, 7, 30},   // EOF
+	}, got)
+}
+
+func TestScan_ErrorSpan(t *testing.T) {
+	_, err := ScanSource("var x = 1;\nvar y = 2 $ 3;")
+	assert.Error(t, err)
+	se := err.(*ScanError)
+	assert.Equal(t, 2, se.Span.Line)
+	assert.Equal(t, 11, se.Span.Column)
+	assert.Equal(t, 12, se.Span.EndColumn)
+}
+
+func TestScan_UnterminatedString(t *testing.T) {
+	_, err := ScanSource("var a = 1;\nvar s = \"abc\ndef;\n")
+	assert.Error(t, err)
+	se := err.(*ScanError)
+	assert.Equal(t, 2, se.Line)
+	assert.Equal(t, errors.Span{Line: 2, Column: 9, EndColumn: 10, Offset: 19}, se.Span)
+}
+
+func TestScanSourceWithComments(t *testing.T) {
+	toks, comments, err := ScanSourceWithComments("// head\nvar a = 1; // tail\n/* block\n /* nested */ */ a;")
+	assert.NoError(t, err)
+	var types []TokenType
+	for _, tok := range toks {
+		types = append(types, tok.Type)
+	}
+	assert.Equal(t, []TokenType{VAR, IDENT, EQUAL, NUMBER, SEMICOLON, IDENT, SEMICOLON, EOF}, types)
+
+	type comment struct {
+		lexeme string
+		line   int
+		offset int
+	}
+	var got []comment
+	for _, c := range comments {
+		assert.Equal(t, COMMENT, c.Type)
+		got = append(got, comment{c.Lexeme, c.Line, c.Offset})
+	}
+	assert.Equal(t, []comment{
+		{"// head", 1, 0},
+		{"// tail", 2, 19},
+		{"/* block\n /* nested */ */", 3, 27},
+	}, got)
+}
@@ -12,17 +12,20 @@ const (
 	// One character tokens
 	NOT_INITIALIZED TokenType = iota
 
-	LEFT_PAREN  // (
-	RIGHT_PAREN // )
-	LEFT_BRACE  // {
-	RIGHT_BRACE // }
-	COMMA       // ,
-	DOT         // .
-	MINUS       // -
-	PLUS        // +
-	SEMICOLON   // ;
-	SLASH       // /
-	STAR        // *
+	LEFT_PAREN    // (
+	RIGHT_PAREN   // )
+	LEFT_BRACE    // {
+	RIGHT_BRACE   // }
+	LEFT_BRACKET  // [
+	RIGHT_BRACKET // ]
+	COMMA         // ,
+	COLON         // :
+	DOT           // .
+	MINUS         // -
+	PLUS          // +
+	SEMICOLON     // ;
+	SLASH         // /
+	STAR          // *
 
 	// One or two character tokens
 	BANG         // !
@@ -33,6 +36,7 @@ const (
 	GTE          // >=
 	LT           // <
 	LTE          // <=
+	ARROW        // =>
 
 	// Literals
 	IDENT  // generic identities
@@ -58,6 +62,15 @@ const (
 	WHILE
 	BREAK
 	CONTINUE
+	THROW
+	TRY
+	CATCH
+	FINALLY
+	IMPORT
+	AS
+
+	// Comments, only kept by ScanSourceWithComments
+	COMMENT // a line or /* block */ comment
 
 	EOF
 )
@@ -73,6 +86,10 @@ func matchSingleChar(r rune) TokenType {
 		return LEFT_BRACE
 	case '}':
 		return RIGHT_BRACE
+	case '[':
+		return LEFT_BRACKET
+	case ']':
+		return RIGHT_BRACKET
 	case '+':
 		return PLUS
 	case '-':
@@ -81,6 +98,8 @@ func matchSingleChar(r rune) TokenType {
 		return STAR
 	case ',':
 		return COMMA
+	case ':':
+		return COLON
 	case ';':
 		return SEMICOLON
 	case '.':
@@ -128,6 +147,18 @@ func matchKeyword(s string) TokenType {
 		return BREAK
 	case "continue":
 		return CONTINUE
+	case "throw":
+		return THROW
+	case "try":
+		return TRY
+	case "catch":
+		return CATCH
+	case "finally":
+		return FINALLY
+	case "import":
+		return IMPORT
+	case "as":
+		return AS
 	}
 	return NOT_INITIALIZED
 }
@@ -137,12 +168,24 @@ type Token struct {
 	Lexeme string
 	Line   int
 	Value  any
+
+	// Byte offset of the token in the source, and the
+	// columns it starts at and ends just before.
+	Offset    int
+	Column    int
+	EndColumn int
 }
 
 func (t Token) String() string {
 	return fmt.Sprintf("%s %s", t.Type, t.Lexeme)
 }
 
+func (t Token) Span() errors.Span {
+	return errors.Span{Line: t.Line, Column: t.Column, EndColumn: t.EndColumn, Offset: t.Offset}
+}
+
 func (t Token) MakeError(msg string) error {
-	return errors.NewLoxError(t.Line, t.Lexeme, msg)
+	err := errors.NewLoxError(t.Line, t.Lexeme, msg)
+	err.Span = t.Span()
+	return err
 }
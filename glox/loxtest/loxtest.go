@@ -0,0 +1,234 @@
+/*
+Package loxtest runs tests written in lox.
+
+Tests live in files named *_test.lx. Every top level function of
+such a file whose name starts with test_ is a test, and runs in an
+interpreter of its own after the file's top level code. A test fails
+when it raises an error, usually through one of the assertions:
+
+	assert(cond)             fails unless cond is truthy
+	assert_eq(actual, want)  fails unless actual equals want, comparing
+	                         lists and maps by their elements
+	assert_raises(fn)        calls fn, failing unless it raises an
+	                         error, and returns what it raised
+*/
+package loxtest
+
+import (
+	"fmt"
+	"glox/ast"
+	"glox/errors"
+	"glox/lexer"
+	"glox/parser"
+	"glox/runtime"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Runner runs the tests of lox files.
+type Runner struct {
+	// NewLox returns the interpreter a test runs in.
+	NewLox func() *runtime.Lox
+	// Out receives the result of each test and the summary.
+	Out io.Writer
+}
+
+// Result is the outcome of a test.
+type Result struct {
+	File string
+	// Name of the test function, empty when the file
+	// itself failed to load.
+	Name string
+	// Line the test failed on, if known.
+	Line int
+	// Err is nil for tests that passed.
+	Err error
+}
+
+// Summary counts the results of a run.
+type Summary struct {
+	Passed int
+	Failed int
+}
+
+// Discover returns the test files under path, which can be a test
+// file itself or a directory searched recursively, in lexical order.
+func Discover(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	var files []string
+	err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(file, "_test.lx") {
+			files = append(files, file)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// Run runs the tests of the files under path, reporting each
+// result and a summary to r.Out.
+func (r *Runner) Run(path string) (Summary, error) {
+	var sum Summary
+	files, err := Discover(path)
+	if err != nil {
+		return sum, err
+	}
+	for _, file := range files {
+		for _, res := range r.RunFile(file) {
+			r.report(res)
+			if res.Err != nil {
+				sum.Failed++
+			} else {
+				sum.Passed++
+			}
+		}
+	}
+	fmt.Fprintf(r.Out, "\n%d passed, %d failed\n", sum.Passed, sum.Failed)
+	return sum, nil
+}
+
+// RunFile runs the tests of a file, in the order they're declared.
+func (r *Runner) RunFile(file string) []Result {
+	tests, err := testNames(file)
+	if err != nil {
+		return []Result{failure(file, "", err)}
+	}
+	results := make([]Result, 0, len(tests))
+	for _, name := range tests {
+		l := r.newLox()
+		if _, err := l.RunFile(file); err != nil {
+			// The file fails to load for every test the same way.
+			return append(results, failure(file, "", err))
+		}
+		if _, err := l.Call(name); err != nil {
+			results = append(results, failure(file, name, err))
+		} else {
+			results = append(results, Result{File: file, Name: name})
+		}
+	}
+	return results
+}
+
+func (r *Runner) newLox() *runtime.Lox {
+	l := r.NewLox()
+	// Failures are reported with the results.
+	l.Stderr = io.Discard
+	DefineAssertions(l)
+	return l
+}
+
+func (r *Runner) report(res Result) {
+	where := res.File
+	if res.Line > 0 {
+		where = fmt.Sprintf("%s:%d", res.File, res.Line)
+	}
+	switch {
+	case res.Err == nil:
+		fmt.Fprintf(r.Out, "PASS %s %s\n", where, res.Name)
+	case res.Name == "":
+		fmt.Fprintf(r.Out, "FAIL %s: %s\n", where, message(res.Err))
+	default:
+		fmt.Fprintf(r.Out, "FAIL %s %s: %s\n", where, res.Name, message(res.Err))
+	}
+}
+
+func failure(file, name string, err error) Result {
+	res := Result{File: file, Name: name, Err: err}
+	if le := loxError(err); le != nil {
+		res.Line = le.LineNumber
+	}
+	return res
+}
+
+func loxError(err error) *errors.LoxError {
+	switch e := err.(type) {
+	case *errors.LoxError:
+		return e
+	case interface{ LoxError() *errors.LoxError }:
+		return e.LoxError()
+	case errors.ErrorList:
+		if len(e) > 0 {
+			return loxError(e[0])
+		}
+	}
+	return nil
+}
+
+func message(err error) string {
+	if le := loxError(err); le != nil {
+		return le.Message
+	}
+	return strings.TrimSpace(err.Error())
+}
+
+// testNames returns the names of the test functions declared in file.
+func testNames(file string) ([]string, error) {
+	source, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := lexer.ScanSource(string(source))
+	if err != nil {
+		return nil, err
+	}
+	stmts, err := parser.Parse(tokens)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, stmt := range stmts {
+		if fn, ok := stmt.(*ast.Function); ok && strings.HasPrefix(fn.Name.Lexeme, "test_") {
+			names = append(names, fn.Name.Lexeme)
+		}
+	}
+	return names, nil
+}
+
+// DefineAssertions declares the assertion natives in l.
+func DefineAssertions(l *runtime.Lox) {
+	l.DefineNative(runtime.Native{Name: "assert", Arity: 1, F: func(_ *runtime.TreeEvaluator, args []any) (any, error) {
+		if !runtime.Truthy(args[0]) {
+			return nil, fmt.Errorf("assertion failed")
+		}
+		return nil, nil
+	}})
+	l.DefineNative(runtime.Native{Name: "assert_eq", Arity: 2, F: func(_ *runtime.TreeEvaluator, args []any) (any, error) {
+		actual, want := args[0], args[1]
+		if !runtime.Equality(actual, want) && !reflect.DeepEqual(runtime.FromLox(actual), runtime.FromLox(want)) {
+			return nil, fmt.Errorf("expected %s, found %s", repr(want), repr(actual))
+		}
+		return nil, nil
+	}})
+	l.DefineNative(runtime.Native{Name: "assert_raises", Arity: 1, F: func(_ *runtime.TreeEvaluator, args []any) (any, error) {
+		_, err := l.CallValue(args[0])
+		if err == nil {
+			return nil, fmt.Errorf("expected an error to be raised")
+		}
+		if val, ok := runtime.CaughtValue(err); ok {
+			return val, nil
+		}
+		return nil, err
+	}})
+}
+
+func repr(val any) string {
+	if s, ok := val.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprintf("%v", val)
+}
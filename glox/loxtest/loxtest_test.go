@@ -0,0 +1,59 @@
+package loxtest_test
+
+import (
+	"bytes"
+	"glox/loxtest"
+	"glox/runtime"
+	"glox/vm"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscover(t *testing.T) {
+	files, err := loxtest.Discover("testdata")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join("testdata", "math_test.lx"),
+		filepath.Join("testdata", "nested", "broken_test.lx"),
+	}, files)
+
+	files, err = loxtest.Discover(filepath.Join("testdata", "helper.lx"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	_, err = loxtest.Discover("missing")
+	assert.Error(t, err)
+}
+
+func TestRunner_Run(t *testing.T) {
+	backends := map[string]func() *runtime.Lox{
+		"tree": runtime.NewLoxInterpreter,
+		"vm": func() *runtime.Lox {
+			l := runtime.NewLoxInterpreter()
+			l.Backend = vm.New()
+			return l
+		},
+	}
+	for name, newLox := range backends {
+		t.Run(name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			runner := &loxtest.Runner{NewLox: newLox, Out: out}
+			sum, err := runner.Run("testdata")
+			assert.NoError(t, err)
+			assert.Equal(t, loxtest.Summary{Passed: 3, Failed: 3}, sum)
+
+			math := filepath.Join("testdata", "math_test.lx")
+			broken := filepath.Join("testdata", "nested", "broken_test.lx")
+			assert.Equal(t, ""+
+				"PASS "+math+" test_square\n"+
+				"PASS "+math+" test_lists\n"+
+				"PASS "+math+" test_raises\n"+
+				"FAIL "+math+":21 test_wrong: expected 5, found 4\n"+
+				"FAIL "+math+":25 test_no_raise: expected an error to be raised\n"+
+				"FAIL "+broken+":3: unexpected token.\n"+
+				"\n3 passed, 3 failed\n", out.String())
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package lsp
+
+import (
+	"fmt"
+	"glox/ast"
+	"glox/errors"
+	"glox/lexer"
+	"glox/parser"
+	"glox/runtime/variable_resolver"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// A document is an open file, analysed every time it changes.
+type document struct {
+	uri   string
+	lines []string
+	// Identifiers of the document, in source order.
+	tokens      []lexer.Token
+	diagnostics []Diagnostic
+	symbols     []DocumentSymbol
+
+	// Declarations by the offset of their name, and the
+	// declaration each variable reference is bound to.
+	decls    map[int]*declaration
+	bindings map[int]int
+	// Names of properties and the methods declared with each name,
+	// which can't be told apart until the program runs.
+	properties map[string][]lexer.Token
+	methods    map[string][]*declaration
+}
+
+// declaration is a name declared by a program.
+type declaration struct {
+	name lexer.Token
+	kind SymbolKind
+	// Function declared, for functions and methods.
+	fn *ast.Function
+	// Class a method belongs to, or a class' superclass.
+	class string
+}
+
+func newDocument(uri, text string) *document {
+	d := &document{
+		uri:        uri,
+		lines:      strings.Split(text, "\n"),
+		decls:      make(map[int]*declaration),
+		bindings:   make(map[int]int),
+		properties: make(map[string][]lexer.Token),
+		methods:    make(map[string][]*declaration),
+	}
+	tokens, err := lexer.ScanSource(text)
+	if err != nil {
+		d.diagnose(err)
+		return d
+	}
+	for _, tok := range tokens {
+		if tok.Type == lexer.IDENT {
+			d.tokens = append(d.tokens, tok)
+		}
+	}
+	stmts, ranges, err := parser.ParseRanges(tokens)
+	d.diagnose(err)
+	bindings, err := variable_resolver.ResolveBindings(stmts)
+	d.diagnose(err)
+
+	ix := &indexer{doc: d, ranges: ranges}
+	for _, stmt := range stmts {
+		stmt.Accept(ix)
+	}
+	for expr, decl := range bindings {
+		switch e := expr.(type) {
+		case *ast.Variable:
+			d.bindings[e.Name.Offset] = decl.Offset
+		case *ast.Assignment:
+			d.bindings[e.Name.Offset] = decl.Offset
+		}
+	}
+	return d
+}
+
+// diagnose reports the errors in err, as they're
+// returned by the lexer, parser and resolver.
+func (d *document) diagnose(err error) {
+	switch e := err.(type) {
+	case nil:
+	case errors.ErrorList:
+		for _, err := range e {
+			d.diagnose(err)
+		}
+	case interface{ LoxError() *errors.LoxError }:
+		d.diagnose(e.LoxError())
+	case *errors.LoxError:
+		r := d.spanRange(e.Span)
+		if e.Span.Line == 0 {
+			r = d.lineRange(e.LineNumber)
+		}
+		d.diagnostics = append(d.diagnostics, Diagnostic{
+			Range:    r,
+			Severity: SeverityError,
+			Source:   "glox",
+			Message:  e.Message,
+		})
+	default:
+		d.diagnostics = append(d.diagnostics, Diagnostic{
+			Severity: SeverityError,
+			Source:   "glox",
+			Message:  err.Error(),
+		})
+	}
+}
+
+// position converts a line and column of the source, both
+// counted from 1 and the column in characters, to a Position.
+func (d *document) position(line, column int) Position {
+	if line < 1 || line > len(d.lines) {
+		return Position{Line: max(line-1, 0)}
+	}
+	runes := []rune(d.lines[line-1])
+	if column-1 < len(runes) {
+		runes = runes[:max(column-1, 0)]
+	}
+	return Position{Line: line - 1, Character: len(utf16.Encode(runes))}
+}
+
+// column converts the character offset of p to a column of the source.
+func (d *document) column(p Position) int {
+	if p.Line < 0 || p.Line >= len(d.lines) {
+		return 0
+	}
+	units := 0
+	for i, r := range []rune(d.lines[p.Line]) {
+		if units >= p.Character {
+			return i + 1
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return len([]rune(d.lines[p.Line])) + 1
+}
+
+func (d *document) spanRange(s errors.Span) Range {
+	return Range{Start: d.position(s.Line, s.Column), End: d.position(s.Line, s.EndColumn)}
+}
+
+func (d *document) tokenRange(tok lexer.Token) Range {
+	return d.spanRange(tok.Span())
+}
+
+// lineRange covers a whole line of the source.
+func (d *document) lineRange(line int) Range {
+	end := 1
+	if line >= 1 && line <= len(d.lines) {
+		end = len([]rune(d.lines[line-1])) + 1
+	}
+	return Range{Start: d.position(line, 1), End: d.position(line, end)}
+}
+
+func (d *document) location(tok lexer.Token) Location {
+	return Location{URI: d.uri, Range: d.tokenRange(tok)}
+}
+
+// identAt returns the identifier at p, including
+// when p is just past its end.
+func (d *document) identAt(p Position) (lexer.Token, bool) {
+	line, col := p.Line+1, d.column(p)
+	for _, tok := range d.tokens {
+		if tok.Line == line && tok.Column <= col && col <= tok.EndColumn {
+			return tok, true
+		}
+	}
+	return lexer.Token{}, false
+}
+
+// declarationOf returns the declaration of the variable, function
+// or class named by ident, or nil if ident isn't one or isn't declared.
+func (d *document) declarationOf(ident lexer.Token) *declaration {
+	if decl, ok := d.decls[ident.Offset]; ok {
+		return decl
+	}
+	if offset, ok := d.bindings[ident.Offset]; ok {
+		return d.decls[offset]
+	}
+	return nil
+}
+
+// definition returns where the identifier at p is declared. Properties
+// are taken to be declared by every method with their name.
+func (d *document) definition(p Position) []Location {
+	ident, ok := d.identAt(p)
+	if !ok {
+		return nil
+	}
+	if decl := d.declarationOf(ident); decl != nil {
+		return []Location{d.location(decl.name)}
+	}
+	var locs []Location
+	for _, m := range d.methods[ident.Lexeme] {
+		locs = append(locs, d.location(m.name))
+	}
+	return locs
+}
+
+// references returns where the name at p is used, in source order.
+func (d *document) references(p Position, includeDecl bool) []Location {
+	ident, ok := d.identAt(p)
+	if !ok {
+		return nil
+	}
+	var toks []lexer.Token
+	decl := d.declarationOf(ident)
+	switch {
+	case decl != nil && decl.kind != SymbolMethod:
+		if includeDecl {
+			toks = append(toks, decl.name)
+		}
+		for ref, offset := range d.bindings {
+			if offset == decl.name.Offset {
+				toks = append(toks, d.tokenAt(ref))
+			}
+		}
+	case decl != nil || len(d.properties[ident.Lexeme]) > 0:
+		if includeDecl {
+			for _, m := range d.methods[ident.Lexeme] {
+				toks = append(toks, m.name)
+			}
+		}
+		toks = append(toks, d.properties[ident.Lexeme]...)
+	}
+	sort.Slice(toks, func(i, j int) bool { return toks[i].Offset < toks[j].Offset })
+	locs := make([]Location, len(toks))
+	for i, tok := range toks {
+		locs[i] = d.location(tok)
+	}
+	return locs
+}
+
+func (d *document) tokenAt(offset int) lexer.Token {
+	i := sort.Search(len(d.tokens), func(i int) bool { return d.tokens[i].Offset >= offset })
+	return d.tokens[i]
+}
+
+// hover describes the declaration of the identifier at p.
+func (d *document) hover(p Position) *Hover {
+	ident, ok := d.identAt(p)
+	if !ok {
+		return nil
+	}
+	var decls []*declaration
+	if decl := d.declarationOf(ident); decl != nil {
+		decls = append(decls, decl)
+	} else {
+		decls = d.methods[ident.Lexeme]
+	}
+	if len(decls) == 0 {
+		return nil
+	}
+	lines := make([]string, len(decls))
+	for i, decl := range decls {
+		lines[i] = decl.signature()
+	}
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: "```lox\n" + strings.Join(lines, "\n") + "\n```"},
+		Range:    d.tokenRange(ident),
+	}
+}
+
+// signature describes a declaration the way it's written in lox.
+func (decl *declaration) signature() string {
+	name := decl.name.Lexeme
+	switch decl.kind {
+	case SymbolFunction:
+		return "fun " + name + params(decl.fn)
+	case SymbolMethod:
+		return decl.class + "." + name + params(decl.fn)
+	case SymbolClass:
+		if decl.class != "" {
+			return fmt.Sprintf("class %s < %s", name, decl.class)
+		}
+		return "class " + name
+	case SymbolModule:
+		return "import " + name
+	}
+	if decl.fn != nil {
+		return "var " + name + " = fun " + params(decl.fn)
+	}
+	return "var " + name
+}
+
+func params(fn *ast.Function) string {
+	names := make([]string, len(fn.Params))
+	for i, p := range fn.Params {
+		names[i] = p.Lexeme
+	}
+	return "(" + strings.Join(names, ", ") + ")"
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,261 @@
+package lsp
+
+import (
+	"glox/ast"
+	"glox/lexer"
+	"glox/parser"
+)
+
+// indexer walks a document's syntax tree, recording its declarations
+// and property names, and the symbols of its top level declarations.
+type indexer struct {
+	doc    *document
+	ranges map[ast.Stmt]parser.Range
+	// Number of blocks and functions the walk is in.
+	depth int
+}
+
+func (ix *indexer) declare(name lexer.Token, kind SymbolKind, fn *ast.Function, class string) *declaration {
+	decl := &declaration{name: name, kind: kind, fn: fn, class: class}
+	ix.doc.decls[name.Offset] = decl
+	if kind == SymbolMethod {
+		ix.doc.methods[name.Lexeme] = append(ix.doc.methods[name.Lexeme], decl)
+	}
+	return decl
+}
+
+// symbol returns the document symbol of the declaration
+// of name by stmt, or nil if stmt isn't at the top level.
+func (ix *indexer) symbol(stmt ast.Stmt, name lexer.Token, kind SymbolKind) *DocumentSymbol {
+	r, ok := ix.ranges[stmt]
+	if ix.depth > 0 || !ok {
+		return nil
+	}
+	d := ix.doc
+	return &DocumentSymbol{
+		Name:           name.Lexeme,
+		Kind:           kind,
+		Range:          Range{Start: d.tokenRange(r.First).Start, End: d.tokenRange(r.Last).End},
+		SelectionRange: d.tokenRange(name),
+	}
+}
+
+func (ix *indexer) addSymbol(sym *DocumentSymbol) {
+	if sym != nil {
+		ix.doc.symbols = append(ix.doc.symbols, *sym)
+	}
+}
+
+func (ix *indexer) stmts(stmts []ast.Stmt) {
+	ix.depth++
+	defer func() { ix.depth-- }()
+	for _, s := range stmts {
+		s.Accept(ix)
+	}
+}
+
+func (ix *indexer) exprs(exprs ...ast.Expr) {
+	for _, e := range exprs {
+		if e != nil {
+			e.Accept(ix)
+		}
+	}
+}
+
+// function indexes the parameters and body of fn.
+func (ix *indexer) function(fn *ast.Function) {
+	for _, p := range fn.Params {
+		ix.declare(p, SymbolVariable, nil, "")
+	}
+	ix.stmts(fn.Body)
+}
+
+// ---------------- Statements ----------------
+
+func (ix *indexer) VisitVar(s *ast.Var) error {
+	var fn *ast.Function
+	if l, ok := s.Initializer.(*ast.Lambda); ok {
+		fn = l.Function
+	}
+	ix.declare(s.Name, SymbolVariable, fn, "")
+	ix.addSymbol(ix.symbol(s, s.Name, SymbolVariable))
+	ix.exprs(s.Initializer)
+	return nil
+}
+
+func (ix *indexer) VisitFunction(s *ast.Function) error {
+	ix.declare(s.Name, SymbolFunction, s, "")
+	ix.addSymbol(ix.symbol(s, s.Name, SymbolFunction))
+	ix.function(s)
+	return nil
+}
+
+func (ix *indexer) VisitClass(s *ast.Class) error {
+	var super string
+	if s.Superclass != nil {
+		super = s.Superclass.Name.Lexeme
+	}
+	ix.declare(s.Name, SymbolClass, nil, super)
+	sym := ix.symbol(s, s.Name, SymbolClass)
+	for _, m := range s.Methods {
+		ix.declare(m.Name, SymbolMethod, m, s.Name.Lexeme)
+		if sym != nil {
+			if msym := ix.symbol(m, m.Name, SymbolMethod); msym != nil {
+				sym.Children = append(sym.Children, *msym)
+			}
+		}
+		ix.function(m)
+	}
+	ix.addSymbol(sym)
+	return nil
+}
+
+func (ix *indexer) VisitImport(s *ast.Import) error {
+	ix.declare(s.Name, SymbolModule, nil, "")
+	ix.addSymbol(ix.symbol(s, s.Name, SymbolModule))
+	return nil
+}
+
+func (ix *indexer) VisitBlock(s *ast.Block) error {
+	ix.stmts(s.Statements)
+	return nil
+}
+
+func (ix *indexer) VisitTry(s *ast.Try) error {
+	ix.stmts(s.Body.Statements)
+	if s.Catch != nil {
+		ix.declare(s.CatchName, SymbolVariable, nil, "")
+		ix.stmts(s.Catch.Statements)
+	}
+	if s.Finally != nil {
+		ix.stmts(s.Finally.Statements)
+	}
+	return nil
+}
+
+func (ix *indexer) VisitIf(s *ast.If) error {
+	ix.exprs(s.Condition)
+	ix.stmts([]ast.Stmt{s.ThenBranch})
+	if s.ElseBranch != nil {
+		ix.stmts([]ast.Stmt{s.ElseBranch})
+	}
+	return nil
+}
+
+func (ix *indexer) VisitWhile(s *ast.While) error {
+	ix.exprs(s.Condition, s.Increment)
+	ix.stmts([]ast.Stmt{s.Do})
+	return nil
+}
+
+func (ix *indexer) VisitExpression(s *ast.Expression) error {
+	ix.exprs(s.Expression)
+	return nil
+}
+
+func (ix *indexer) VisitPrint(s *ast.Print) error {
+	ix.exprs(s.Expression)
+	return nil
+}
+
+func (ix *indexer) VisitReturn(s *ast.Return) error {
+	ix.exprs(s.Expression)
+	return nil
+}
+
+func (ix *indexer) VisitThrow(s *ast.Throw) error {
+	ix.exprs(s.Expression)
+	return nil
+}
+
+func (ix *indexer) VisitBreak(s *ast.Break) error {
+	return nil
+}
+
+// ---------------- Expressions ----------------
+
+func (ix *indexer) VisitGet(e *ast.Get) error {
+	ix.doc.properties[e.Name.Lexeme] = append(ix.doc.properties[e.Name.Lexeme], e.Name)
+	ix.exprs(e.Object)
+	return nil
+}
+
+func (ix *indexer) VisitSet(e *ast.Set) error {
+	ix.doc.properties[e.Name.Lexeme] = append(ix.doc.properties[e.Name.Lexeme], e.Name)
+	ix.exprs(e.Object, e.Value)
+	return nil
+}
+
+func (ix *indexer) VisitSuper(e *ast.Super) error {
+	ix.doc.properties[e.Method.Lexeme] = append(ix.doc.properties[e.Method.Lexeme], e.Method)
+	return nil
+}
+
+func (ix *indexer) VisitLambda(e *ast.Lambda) error {
+	ix.function(e.Function)
+	return nil
+}
+
+func (ix *indexer) VisitIndexSet(e *ast.IndexSet) error {
+	ix.exprs(e.Object, e.Index, e.Value)
+	return nil
+}
+
+func (ix *indexer) VisitIndex(e *ast.Index) error {
+	ix.exprs(e.Object, e.Index)
+	return nil
+}
+
+func (ix *indexer) VisitLogical(e *ast.Logical) error {
+	ix.exprs(e.Left, e.Right)
+	return nil
+}
+
+func (ix *indexer) VisitBinary(e *ast.Binary) error {
+	ix.exprs(e.Left, e.Right)
+	return nil
+}
+
+func (ix *indexer) VisitGrouping(e *ast.Grouping) error {
+	ix.exprs(e.Expression)
+	return nil
+}
+
+func (ix *indexer) VisitUnary(e *ast.Unary) error {
+	ix.exprs(e.Right)
+	return nil
+}
+
+func (ix *indexer) VisitAssignment(e *ast.Assignment) error {
+	ix.exprs(e.Value)
+	return nil
+}
+
+func (ix *indexer) VisitCall(e *ast.Call) error {
+	ix.exprs(e.Callee)
+	ix.exprs(e.Args...)
+	return nil
+}
+
+func (ix *indexer) VisitList(e *ast.List) error {
+	ix.exprs(e.Elements...)
+	return nil
+}
+
+func (ix *indexer) VisitMap(e *ast.Map) error {
+	ix.exprs(e.Keys...)
+	ix.exprs(e.Values...)
+	return nil
+}
+
+func (ix *indexer) VisitVariable(e *ast.Variable) error {
+	return nil
+}
+
+func (ix *indexer) VisitThis(e *ast.This) error {
+	return nil
+}
+
+func (ix *indexer) VisitLiteral(e *ast.Literal) error {
+	return nil
+}
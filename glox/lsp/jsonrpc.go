@@ -0,0 +1,112 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+// message is a JSON-RPC 2.0 request, notification or response.
+// Notifications are requests without an ID.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	// A response has either a result, which can be null, or an error.
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *responseError  `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *responseError) Error() string {
+	return e.Message
+}
+
+// Error codes of JSON-RPC and LSP.
+const (
+	codeParseError           = -32700
+	codeInvalidParams        = -32602
+	codeMethodNotFound       = -32601
+	codeServerNotInitialized = -32002
+	codeInvalidRequest       = -32600
+)
+
+// conn reads and writes messages framed by a Content-Length
+// header, as LSP sends them over stdio.
+type conn struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: bufio.NewReader(r), w: w}
+}
+
+func (c *conn) read() (*message, error) {
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("bad Content-Length header '%s'", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return nil, err
+	}
+	msg := &message{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, &responseError{Code: codeParseError, Message: err.Error()}
+	}
+	return msg, nil
+}
+
+func (c *conn) write(msg *message) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(body)
+	return err
+}
+
+// reply responds to the request with id, with result or err.
+func (c *conn) reply(id *json.RawMessage, result any, err error) error {
+	msg := &message{ID: id}
+	if err != nil {
+		rerr, ok := err.(*responseError)
+		if !ok {
+			rerr = &responseError{Code: codeInvalidRequest, Message: err.Error()}
+		}
+		msg.Error = rerr
+	} else {
+		data, merr := json.Marshal(result)
+		if merr != nil {
+			return merr
+		}
+		msg.Result = data
+	}
+	return c.write(msg)
+}
+
+// notify sends a notification to the client.
+func (c *conn) notify(method string, params any) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.write(&message{Method: method, Params: data})
+}
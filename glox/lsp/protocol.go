@@ -0,0 +1,132 @@
+package lsp
+
+// The parts of the Language Server Protocol the server speaks, see
+// https://microsoft.github.io/language-server-protocol/specifications/specification-current/
+
+// Position is a zero based line, and a character offset in UTF-16
+// code units from the start of the line.
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// Range runs from Start up to, but not including, End.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+type TextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type TextDocumentItem struct {
+	URI        string `json:"uri"`
+	LanguageID string `json:"languageId"`
+	Version    int    `json:"version"`
+	Text       string `json:"text"`
+}
+
+type DidOpenTextDocumentParams struct {
+	TextDocument TextDocumentItem `json:"textDocument"`
+}
+
+// TextDocumentContentChangeEvent replaces the whole text of a
+// document, since the server only asks for full syncs.
+type TextDocumentContentChangeEvent struct {
+	Text string `json:"text"`
+}
+
+type DidChangeTextDocumentParams struct {
+	TextDocument   TextDocumentIdentifier           `json:"textDocument"`
+	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+}
+
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+type TextDocumentPositionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+type ReferenceParams struct {
+	TextDocumentPositionParams
+	Context struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+}
+
+type DocumentSymbolParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+const (
+	SeverityError = 1
+)
+
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+type PublishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+type MarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type Hover struct {
+	Contents MarkupContent `json:"contents"`
+	Range    Range         `json:"range"`
+}
+
+// SymbolKind is the kind of a DocumentSymbol.
+type SymbolKind int
+
+const (
+	SymbolModule   SymbolKind = 2
+	SymbolClass    SymbolKind = 5
+	SymbolMethod   SymbolKind = 6
+	SymbolFunction SymbolKind = 12
+	SymbolVariable SymbolKind = 13
+)
+
+type DocumentSymbol struct {
+	Name string     `json:"name"`
+	Kind SymbolKind `json:"kind"`
+	// Range covers the whole declaration, SelectionRange its name.
+	Range          Range            `json:"range"`
+	SelectionRange Range            `json:"selectionRange"`
+	Children       []DocumentSymbol `json:"children,omitempty"`
+}
+
+type InitializeResult struct {
+	Capabilities ServerCapabilities `json:"capabilities"`
+	ServerInfo   struct {
+		Name string `json:"name"`
+	} `json:"serverInfo"`
+}
+
+// Text documents are synced by sending their full text.
+const SyncFull = 1
+
+type ServerCapabilities struct {
+	TextDocumentSync       int  `json:"textDocumentSync"`
+	DefinitionProvider     bool `json:"definitionProvider"`
+	ReferencesProvider     bool `json:"referencesProvider"`
+	HoverProvider          bool `json:"hoverProvider"`
+	DocumentSymbolProvider bool `json:"documentSymbolProvider"`
+}
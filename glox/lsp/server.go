@@ -0,0 +1,195 @@
+/*
+Package lsp is a Language Server Protocol server for lox.
+
+It analyses open documents with the lexer, parser and variable
+resolver of the interpreter, and answers with:
+
+  - diagnostics for the syntax and resolution errors of a
+    document, published each time it changes
+  - the definition of, and references to, variables, functions,
+    classes and methods
+  - the parameters of functions and methods on hover
+  - the symbols a document declares at the top level
+
+Methods are found by name, since which one a property refers to
+depends on the object it's looked up on when the program runs.
+*/
+package lsp
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+)
+
+// ErrNoShutdown is returned by Serve when the client
+// exits without asking the server to shut down first.
+var ErrNoShutdown = stderrors.New("exit without shutdown")
+
+// Server answers the requests of a single client.
+type Server struct {
+	conn        *conn
+	docs        map[string]*document
+	initialized bool
+	shutdown    bool
+}
+
+// NewServer returns a server reading messages from r and writing to w.
+func NewServer(r io.Reader, w io.Writer) *Server {
+	return &Server{conn: newConn(r, w), docs: make(map[string]*document)}
+}
+
+// Serve answers the messages of the client until it exits.
+func Serve(r io.Reader, w io.Writer) error {
+	return NewServer(r, w).Serve()
+}
+
+// Serve answers the messages of the client until it sends the exit
+// notification, or the connection fails.
+func (s *Server) Serve() error {
+	for {
+		msg, err := s.conn.read()
+		if rerr, ok := err.(*responseError); ok && rerr.Code == codeParseError {
+			// Without the message there's no ID to reply to.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return ErrNoShutdown
+			}
+			return nil
+		}
+		result, err := s.handle(msg)
+		if msg.ID == nil {
+			continue
+		}
+		if err := s.conn.reply(msg.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+// handle runs the method of a request or notification.
+func (s *Server) handle(msg *message) (any, error) {
+	if !s.initialized && msg.Method != "initialize" {
+		return nil, &responseError{Code: codeServerNotInitialized, Message: "server not initialized"}
+	}
+	switch msg.Method {
+	case "initialize":
+		s.initialized = true
+		res := InitializeResult{Capabilities: ServerCapabilities{
+			TextDocumentSync:       SyncFull,
+			DefinitionProvider:     true,
+			ReferencesProvider:     true,
+			HoverProvider:          true,
+			DocumentSymbolProvider: true,
+		}}
+		res.ServerInfo.Name = "glox"
+		return res, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if err := unmarshal(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		return nil, s.open(p.TextDocument.URI, p.TextDocument.Text)
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if err := unmarshal(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		if len(p.ContentChanges) == 0 {
+			return nil, nil
+		}
+		return nil, s.open(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if err := unmarshal(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		delete(s.docs, p.TextDocument.URI)
+		return nil, s.publish(p.TextDocument.URI, nil)
+	case "textDocument/definition":
+		var p TextDocumentPositionParams
+		doc, err := s.document(msg.Params, &p, &p.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		return nonNil(doc.definition(p.Position)), nil
+	case "textDocument/references":
+		var p ReferenceParams
+		doc, err := s.document(msg.Params, &p, &p.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		return nonNil(doc.references(p.Position, p.Context.IncludeDeclaration)), nil
+	case "textDocument/hover":
+		var p TextDocumentPositionParams
+		doc, err := s.document(msg.Params, &p, &p.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		if h := doc.hover(p.Position); h != nil {
+			return h, nil
+		}
+		return nil, nil
+	case "textDocument/documentSymbol":
+		var p DocumentSymbolParams
+		doc, err := s.document(msg.Params, &p, &p.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		return nonNil(doc.symbols), nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method '%s' not found", msg.Method)}
+}
+
+// open analyses the text of a document, publishing its diagnostics.
+func (s *Server) open(uri, text string) error {
+	doc := newDocument(uri, text)
+	s.docs[uri] = doc
+	return s.publish(uri, doc.diagnostics)
+}
+
+func (s *Server) publish(uri string, diagnostics []Diagnostic) error {
+	return s.conn.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: nonNil(diagnostics),
+	})
+}
+
+// document decodes the params of a request into p, returning
+// the open document they identify with id.
+func (s *Server) document(params json.RawMessage, p any, id *TextDocumentIdentifier) (*document, error) {
+	if err := unmarshal(params, p); err != nil {
+		return nil, err
+	}
+	doc, ok := s.docs[id.URI]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("document '%s' isn't open", id.URI)}
+	}
+	return doc, nil
+}
+
+func unmarshal(params json.RawMessage, p any) error {
+	if err := json.Unmarshal(params, p); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// nonNil makes empty results encode as [] rather than null.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
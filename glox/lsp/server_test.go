@@ -0,0 +1,333 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// client drives a server over pipes, the way an editor does over stdio.
+type client struct {
+	t      *testing.T
+	conn   *conn
+	nextID int
+	done   chan error
+}
+
+func newClient(t *testing.T) *client {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &client{t: t, conn: newConn(clientIn, clientOut), done: make(chan error, 1)}
+	go func() {
+		err := Serve(serverIn, serverOut)
+		serverOut.Close()
+		c.done <- err
+	}()
+	return c
+}
+
+// call sends a request and decodes the result of its response into result.
+func (c *client) call(method string, params any, result any) *responseError {
+	c.nextID++
+	id := json.RawMessage(fmt.Sprint(c.nextID))
+	data, err := json.Marshal(params)
+	require.NoError(c.t, err)
+	require.NoError(c.t, c.conn.write(&message{ID: &id, Method: method, Params: data}))
+
+	msg, err := c.conn.read()
+	require.NoError(c.t, err)
+	require.Equal(c.t, string(id), string(*msg.ID))
+	if msg.Error != nil {
+		return msg.Error
+	}
+	require.NoError(c.t, json.Unmarshal(msg.Result, result))
+	return nil
+}
+
+func (c *client) notify(method string, params any) {
+	require.NoError(c.t, c.conn.notify(method, params))
+}
+
+// diagnostics reads the diagnostics the server publishes next.
+func (c *client) diagnostics() PublishDiagnosticsParams {
+	msg, err := c.conn.read()
+	require.NoError(c.t, err)
+	require.Equal(c.t, "textDocument/publishDiagnostics", msg.Method)
+	var p PublishDiagnosticsParams
+	require.NoError(c.t, json.Unmarshal(msg.Params, &p))
+	return p
+}
+
+func (c *client) open(uri, text string) PublishDiagnosticsParams {
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: uri, LanguageID: "lox", Version: 1, Text: text},
+	})
+	return c.diagnostics()
+}
+
+func (c *client) initialize() InitializeResult {
+	var res InitializeResult
+	require.Nil(c.t, c.call("initialize", map[string]any{"capabilities": map[string]any{}}, &res))
+	c.notify("initialized", struct{}{})
+	return res
+}
+
+// exit shuts the server down, returning the error Serve returned.
+func (c *client) exit() error {
+	var res any
+	require.Nil(c.t, c.call("shutdown", nil, &res))
+	c.notify("exit", nil)
+	return <-c.done
+}
+
+func at(line, char int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: line, Character: char},
+	}
+}
+
+func rng(line, start, end int) Range {
+	return Range{Start: Position{Line: line, Character: start}, End: Position{Line: line, Character: end}}
+}
+
+const uri = "file:///shapes.lx"
+
+const shapes = `class Shape {
+	init(name) { this.name = name; }
+	area() { return 0; }
+}
+
+class Square < Shape {
+	init(side) {
+		super.init("square");
+		this.side = side;
+	}
+	area() { return this.side * this.side; }
+}
+
+fun total(shapes, scale) {
+	var sum = 0;
+	for (var i = 0; i < shapes.len(); i = i + 1) {
+		sum = sum + shapes[i].area() * scale;
+	}
+	return sum;
+}
+
+var squares = [Square(1), Square(2)];
+print total(squares, 1);
+`
+
+func TestServer_Initialize(t *testing.T) {
+	c := newClient(t)
+	res := c.initialize()
+	assert.Equal(t, SyncFull, res.Capabilities.TextDocumentSync)
+	assert.True(t, res.Capabilities.DefinitionProvider)
+	assert.True(t, res.Capabilities.ReferencesProvider)
+	assert.True(t, res.Capabilities.HoverProvider)
+	assert.True(t, res.Capabilities.DocumentSymbolProvider)
+	assert.NoError(t, c.exit())
+}
+
+func TestServer_NotInitialized(t *testing.T) {
+	c := newClient(t)
+	var res any
+	err := c.call("textDocument/hover", at(0, 0), &res)
+	require.NotNil(t, err)
+	assert.Equal(t, codeServerNotInitialized, err.Code)
+}
+
+func TestServer_ExitWithoutShutdown(t *testing.T) {
+	c := newClient(t)
+	c.initialize()
+	c.notify("exit", nil)
+	assert.Equal(t, ErrNoShutdown, <-c.done)
+}
+
+func TestServer_UnknownMethod(t *testing.T) {
+	c := newClient(t)
+	c.initialize()
+	var res any
+	err := c.call("textDocument/rename", at(0, 0), &res)
+	require.NotNil(t, err)
+	assert.Equal(t, codeMethodNotFound, err.Code)
+	assert.NoError(t, c.exit())
+}
+
+func TestServer_Diagnostics(t *testing.T) {
+	c := newClient(t)
+	c.initialize()
+
+	diags := c.open(uri, "var a = 1;\nprint a +;\nreturn a;\n")
+	assert.Equal(t, uri, diags.URI)
+	assert.Equal(t, []Diagnostic{
+		{Range: rng(1, 9, 10), Severity: SeverityError, Source: "glox", Message: "unexpected token."},
+		{Range: rng(2, 0, 6), Severity: SeverityError, Source: "glox", Message: "return outside a function or method"},
+	}, diags.Diagnostics)
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentIdentifier{URI: uri},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "var a = 1;\nprint a + 1;\n"}},
+	})
+	assert.Empty(t, c.diagnostics().Diagnostics)
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentIdentifier{URI: uri},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: `print "oops;`}},
+	})
+	diags = c.diagnostics()
+	require.Len(t, diags.Diagnostics, 1)
+	assert.Equal(t, "unterminated string", diags.Diagnostics[0].Message)
+
+	c.notify("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}})
+	assert.Empty(t, c.diagnostics().Diagnostics)
+	assert.NoError(t, c.exit())
+}
+
+func TestServer_Diagnostics_Unfinished(t *testing.T) {
+	c := newClient(t)
+	c.initialize()
+
+	// Source is scanned as it's typed, ending anywhere.
+	diags := c.open(uri, "var a =")
+	assert.Equal(t, []Diagnostic{
+		{Range: rng(0, 6, 7), Severity: SeverityError, Source: "glox", Message: "unexpected token."},
+	}, diags.Diagnostics)
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentIdentifier{URI: uri},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "class Dog <"}},
+	})
+	assert.Len(t, c.diagnostics().Diagnostics, 1)
+	assert.NoError(t, c.exit())
+}
+
+func TestServer_Definition(t *testing.T) {
+	c := newClient(t)
+	c.initialize()
+	assert.Empty(t, c.open(uri, shapes).Diagnostics)
+
+	tests := []struct {
+		name string
+		pos  TextDocumentPositionParams
+		want []Range
+	}{
+		{"local variable", at(16, 3), []Range{rng(14, 5, 8)}},
+		{"parameter", at(16, 36), []Range{rng(13, 18, 23)}},
+		{"function", at(22, 7), []Range{rng(13, 4, 9)}},
+		{"global used before its line", at(22, 14), []Range{rng(21, 4, 11)}},
+		{"class", at(21, 15), []Range{rng(5, 6, 12)}},
+		{"superclass", at(5, 16), []Range{rng(0, 6, 11)}},
+		{"methods by name", at(16, 26), []Range{rng(2, 1, 5), rng(10, 1, 5)}},
+		{"super method", at(7, 9), []Range{rng(1, 1, 5), rng(6, 1, 5)}},
+		{"declaration", at(13, 5), []Range{rng(13, 4, 9)}},
+		{"property without a method", at(15, 29), nil},
+		{"not an identifier", at(22, 0), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var locs []Location
+			require.Nil(t, c.call("textDocument/definition", tt.pos, &locs))
+			var got []Range
+			for _, loc := range locs {
+				assert.Equal(t, uri, loc.URI)
+				got = append(got, loc.Range)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+	assert.NoError(t, c.exit())
+}
+
+func TestServer_References(t *testing.T) {
+	c := newClient(t)
+	c.initialize()
+	c.open(uri, shapes)
+
+	refs := func(pos TextDocumentPositionParams, includeDecl bool) []Range {
+		p := ReferenceParams{TextDocumentPositionParams: pos}
+		p.Context.IncludeDeclaration = includeDecl
+		var locs []Location
+		require.Nil(t, c.call("textDocument/references", p, &locs))
+		var got []Range
+		for _, loc := range locs {
+			got = append(got, loc.Range)
+		}
+		return got
+	}
+	// sum, from its declaration and from a use.
+	sum := []Range{rng(14, 5, 8), rng(16, 2, 5), rng(16, 8, 11), rng(18, 8, 11)}
+	assert.Equal(t, sum, refs(at(14, 6), true))
+	assert.Equal(t, sum[1:], refs(at(18, 9), false))
+	// A class, and the calls constructing instances of it.
+	assert.Equal(t, []Range{rng(5, 6, 12), rng(21, 15, 21), rng(21, 26, 32)}, refs(at(5, 7), true))
+	// The area methods and their calls.
+	assert.Equal(t, []Range{rng(2, 1, 5), rng(10, 1, 5), rng(16, 24, 28)}, refs(at(2, 2), true))
+	assert.NoError(t, c.exit())
+}
+
+func TestServer_Hover(t *testing.T) {
+	c := newClient(t)
+	c.initialize()
+	c.open(uri, shapes+"var double = (x) => x * 2;\nprint double(2);\n")
+
+	hover := func(pos TextDocumentPositionParams) string {
+		var h *Hover
+		require.Nil(t, c.call("textDocument/hover", pos, &h))
+		if h == nil {
+			return ""
+		}
+		assert.Equal(t, "markdown", h.Contents.Kind)
+		return h.Contents.Value
+	}
+	assert.Equal(t, "```lox\nfun total(shapes, scale)\n```", hover(at(22, 8)))
+	assert.Equal(t, "```lox\nShape.area()\nSquare.area()\n```", hover(at(16, 26)))
+	assert.Equal(t, "```lox\nclass Square < Shape\n```", hover(at(21, 16)))
+	assert.Equal(t, "```lox\nvar double = fun (x)\n```", hover(at(23, 7)))
+	assert.Equal(t, "", hover(at(22, 0)))
+	assert.NoError(t, c.exit())
+}
+
+func TestServer_DocumentSymbol(t *testing.T) {
+	c := newClient(t)
+	c.initialize()
+	c.open(uri, shapes)
+
+	var syms []DocumentSymbol
+	require.Nil(t, c.call("textDocument/documentSymbol", DocumentSymbolParams{TextDocument: TextDocumentIdentifier{URI: uri}}, &syms))
+	type symbol struct {
+		name     string
+		kind     SymbolKind
+		lines    [2]int
+		children []string
+	}
+	var got []symbol
+	for _, s := range syms {
+		sym := symbol{s.Name, s.Kind, [2]int{s.Range.Start.Line, s.Range.End.Line}, nil}
+		for _, child := range s.Children {
+			sym.children = append(sym.children, child.Name)
+		}
+		got = append(got, sym)
+	}
+	assert.Equal(t, []symbol{
+		{"Shape", SymbolClass, [2]int{0, 3}, []string{"init", "area"}},
+		{"Square", SymbolClass, [2]int{5, 11}, []string{"init", "area"}},
+		{"total", SymbolFunction, [2]int{13, 19}, nil},
+		{"squares", SymbolVariable, [2]int{21, 21}, nil},
+	}, got)
+	assert.NoError(t, c.exit())
+}
+
+func TestServer_DocumentNotOpen(t *testing.T) {
+	c := newClient(t)
+	c.initialize()
+	var res any
+	err := c.call("textDocument/definition", at(0, 0), &res)
+	require.NotNil(t, err)
+	assert.Equal(t, codeInvalidParams, err.Code)
+	assert.NoError(t, c.exit())
+}
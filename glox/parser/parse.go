@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"glox/ast"
+	"glox/errors"
 	"glox/lexer"
 )
 
@@ -10,22 +11,51 @@ import (
 // implement Lox's grammar rules.
 type RecursiveDescent struct {
 	Parser
+
+	// Errors the parser recovered from.
+	errors errors.ErrorList
+	// Number of blocks being parsed.
+	blockDepth int
+	// Tokens of each statement parsed, when they're wanted.
+	ranges map[ast.Stmt]Range
+}
+
+// Range is the first and last token of a statement in the source.
+type Range struct {
+	First, Last lexer.Token
 }
 
 // Parse converts a sequence of tokens into a syntax tree.
-// Returns the AST or any parse error that was encountered.
 // basically the grammar start rule, [program -> declaration* EOF ;]
+//
+// The parser recovers from syntax errors at the next statement, so
+// every error in the program is found. They are returned together
+// as an errors.ErrorList, along with the declarations that parsed.
 func Parse(tokens []lexer.Token) ([]ast.Stmt, error) {
-	tree := &RecursiveDescent{Parser: Parser{tokens: tokens}}
+	return parse(&RecursiveDescent{Parser: Parser{tokens: tokens}})
+}
+
+// ParseRanges parses tokens like Parse, also returning the range of
+// tokens each statement was parsed from, including the statements in
+// blocks, functions and classes. Tools that work on the source, like
+// the formatter, use them to find the comments around statements.
+func ParseRanges(tokens []lexer.Token) ([]ast.Stmt, map[ast.Stmt]Range, error) {
+	tree := &RecursiveDescent{Parser: Parser{tokens: tokens}, ranges: make(map[ast.Stmt]Range)}
+	stmts, err := parse(tree)
+	return stmts, tree.ranges, err
+}
+
+func parse(tree *RecursiveDescent) ([]ast.Stmt, error) {
 	var ret []ast.Stmt
 	for !tree.IsAtEnd() {
 		s, err := tree.Declaration()
 		if err != nil {
-			return nil, err
+			tree.errors = append(tree.errors, err)
+			continue
 		}
 		ret = append(ret, s)
 	}
-	return ret, nil
+	return ret, tree.errors.Err()
 }
 
 // Take the next token only if it matches the given type.
@@ -38,50 +68,77 @@ func (p *RecursiveDescent) Consume(typ lexer.TokenType, ifNot string) (lexer.Tok
 	return p.Next(), nil
 }
 
-// declaration -> varDecl | functionDecl | classDecl | statement ;
+// declaration -> varDecl | functionDecl | classDecl | importDecl | statement ;
 func (p *RecursiveDescent) Declaration() (ast.Stmt, error) {
+	start := p.current
 	var f func() (ast.Stmt, error)
 	switch p.Next().Type {
 	case lexer.CLASS:
 		f = p.ClassDeclaration
 	case lexer.FUN:
 		f = func() (ast.Stmt, error) { return p.FunctionDeclaration("function") }
+		if p.MatchType(lexer.LEFT_PAREN) {
+			// An anonymous function starting an expression statement.
+			p.Back()
+			f = p.Statement
+		}
 	case lexer.VAR:
 		f = p.VarDeclaration
+	case lexer.IMPORT:
+		f = p.ImportDeclaration
 	default:
 		p.Back()
 		f = p.Statement
 	}
 	s, err := f()
 	if err != nil {
-		p.synchronize()
+		p.synchronize(start)
 		return nil, err
 	}
+	p.track(s, start)
 	return s, nil
 }
 
+// track records that stmt was parsed from the tokens since start.
+func (p *RecursiveDescent) track(stmt ast.Stmt, start int) {
+	if p.ranges != nil {
+		p.ranges[stmt] = Range{First: p.tokens[start], Last: p.tokens[p.current-1]}
+	}
+}
+
+// classDecl -> "class" IDENTIFIER ( "<" IDENTIFIER )? "{" function* "}" ;
 func (p *RecursiveDescent) ClassDeclaration() (ast.Stmt, error) {
 	name, err := p.Consume(lexer.IDENT, "expect class name")
 	if err != nil {
 		return nil, err
 	}
+	var superclass *ast.Variable
+	if p.TakeIfType(lexer.LT) {
+		superName, err := p.Consume(lexer.IDENT, "expect superclass name after '<'")
+		if err != nil {
+			return nil, err
+		}
+		superclass = &ast.Variable{Name: superName}
+	}
 	_, err = p.Consume(lexer.LEFT_BRACE, "expect '{' after class name")
 	if err != nil {
 		return nil, err
 	}
 	var methods []*ast.Function
 	for p.Peek().Type != lexer.RIGHT_BRACE && !p.IsAtEnd() {
+		start := p.current
 		m, err := p.FunctionDeclaration("method")
 		if err != nil {
 			return nil, err
 		}
+		p.track(m, start)
 		methods = append(methods, m.(*ast.Function))
 	}
 	_, err = p.Consume(lexer.RIGHT_BRACE, "Expect '}' at end of class declaration")
 	if err != nil {
 		return nil, err
 	}
-	return &ast.Class{Name: name, Methods: methods}, nil
+	return &ast.Class{Name: name, Superclass: superclass, Methods: methods}, nil
 }
 
 // Parse a function declaration. Kind is one of "function" or "method".
@@ -94,6 +151,16 @@ func (p *RecursiveDescent) FunctionDeclaration(kind string) (ast.Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
+	fn, err := p.functionBody(name)
+	if err != nil {
+		return nil, err
+	}
+	return fn, nil
+}
+
+// Parse the parameters and body of a function, after the opening '('.
+func (p *RecursiveDescent) functionBody(name lexer.Token) (*ast.Function, error) {
+	start := p.current
 	params := make([]lexer.Token, 0)
 	if !p.MatchType(lexer.RIGHT_PAREN) {
 		for {
@@ -122,11 +189,13 @@ func (p *RecursiveDescent) FunctionDeclaration(kind string) (ast.Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &ast.Function{
+	fn := &ast.Function{
 		Name:   name,
 		Params: params,
 		Body:   body.(*ast.Block).Statements,
-	}, nil
+	}
+	p.track(fn, start)
+	return fn, nil
 }
 
 // varDecl -> "var" IDENTIFIER ("=" expression)? ";" ;
@@ -147,17 +216,51 @@ func (p *RecursiveDescent) VarDeclaration() (ast.Stmt, error) {
 			return nil, err
 		}
 	}
-	if tok := p.Next(); tok.Type != lexer.SEMICOLON && tok.Type != lexer.EOF {
-		return nil, tok.MakeError("expect ';' after variable declaration")
+	if !p.TakeIfType(lexer.SEMICOLON, lexer.EOF) {
+		return nil, p.Peek().MakeError("expect ';' after variable declaration")
 	}
 	return &ast.Var{Name: id, Initializer: initializer}, nil
 }
 
+// importDecl -> "import" STRING "as" IDENTIFIER ";" ;
+func (p *RecursiveDescent) ImportDeclaration() (ast.Stmt, error) {
+	p.Back()
+	keyword := p.Next()
+	path, err := p.Consume(lexer.STRING, "expect module path after 'import'")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.Consume(lexer.AS, "expect 'as' after module path"); err != nil {
+		return nil, err
+	}
+	name, err := p.Consume(lexer.IDENT, "expect module name after 'as'")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.Consume(lexer.SEMICOLON, "expect ';' after import"); err != nil {
+		return nil, err
+	}
+	return &ast.Import{Keyword: keyword, Path: path, Name: name}, nil
+}
+
 // statement -> printStmt | block | ifStmt | exprStmt ;
 func (p *RecursiveDescent) Statement() (ast.Stmt, error) {
+	start := p.current
+	s, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+	p.track(s, start)
+	return s, nil
+}
+
+func (p *RecursiveDescent) statement() (ast.Stmt, error) {
 	if p.TakeIfType(lexer.PRINT) {
 		return p.PrintStatement()
 	}
+	if p.MatchType(lexer.LEFT_BRACE) && p.isMapLiteral() {
+		return p.ExpressionStatement()
+	}
 	if p.TakeIfType(lexer.LEFT_BRACE) {
 		return p.BlockStatement()
 	}
@@ -179,17 +282,86 @@ func (p *RecursiveDescent) Statement() (ast.Stmt, error) {
 	if p.TakeIfType(lexer.RETURN) {
 		return p.ReturnStatement()
 	}
+	if p.TakeIfType(lexer.THROW) {
+		return p.ThrowStatement()
+	}
+	if p.TakeIfType(lexer.TRY) {
+		return p.TryStatement()
+	}
 	return p.ExpressionStatement()
 }
 
+// throwStmt -> "throw" expression ";" ;
+func (p *RecursiveDescent) ThrowStatement() (ast.Stmt, error) {
+	p.Back()
+	keyword := p.Next()
+	value, err := p.Expression()
+	if err != nil {
+		return nil, err
+	}
+	if !p.TakeIfType(lexer.SEMICOLON) {
+		return nil, p.Peek().MakeError("expect ';' after thrown value")
+	}
+	return &ast.Throw{Keyword: keyword, Expression: value}, nil
+}
+
+// tryStmt -> "try" block ( "catch" "(" IDENTIFIER ")" block )? ( "finally" block )? ;
+// At least one of the catch or finally clauses is required.
+func (p *RecursiveDescent) TryStatement() (ast.Stmt, error) {
+	p.Back()
+	stmt := &ast.Try{Keyword: p.Next()}
+	block := func(after string) (*ast.Block, error) {
+		if _, err := p.Consume(lexer.LEFT_BRACE, fmt.Sprintf("expect '{' after '%s'", after)); err != nil {
+			return nil, err
+		}
+		b, err := p.BlockStatement()
+		if err != nil {
+			return nil, err
+		}
+		return b.(*ast.Block), nil
+	}
+
+	var err error
+	if stmt.Body, err = block("try"); err != nil {
+		return nil, err
+	}
+	if p.TakeIfType(lexer.CATCH) {
+		if _, err := p.Consume(lexer.LEFT_PAREN, "expect '(' after 'catch'"); err != nil {
+			return nil, err
+		}
+		if stmt.CatchName, err = p.Consume(lexer.IDENT, "expect exception variable name"); err != nil {
+			return nil, err
+		}
+		if _, err := p.Consume(lexer.RIGHT_PAREN, "expect ')' after exception variable"); err != nil {
+			return nil, err
+		}
+		if stmt.Catch, err = block("catch"); err != nil {
+			return nil, err
+		}
+	}
+	if p.TakeIfType(lexer.FINALLY) {
+		if stmt.Finally, err = block("finally"); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.Catch == nil && stmt.Finally == nil {
+		return nil, p.Peek().MakeError("expect 'catch' or 'finally' after try block")
+	}
+	return stmt, nil
+}
+
 func (p *RecursiveDescent) BreakStatement(cont bool) (ast.Stmt, error) {
+	p.Back()
+	keyword := p.Next()
 	if !p.TakeIfType(lexer.SEMICOLON) {
 		return nil, p.Peek().MakeError("expect ';' after break/continue")
 	}
-	return &ast.Break{Continue: cont}, nil
+	return &ast.Break{Keyword: keyword, Continue: cont}, nil
 }
 
 func (p *RecursiveDescent) ForStatement() (ast.Stmt, error) {
+	p.Back()
+	keyword := p.Next()
 	if !p.TakeIfType(lexer.LEFT_PAREN) {
 		return nil, p.Peek().MakeError("expect '(' after 'for'")
 	}
@@ -213,9 +385,8 @@ func (p *RecursiveDescent) ForStatement() (ast.Stmt, error) {
 		}
 	}
 
-	if p.TakeIfType(lexer.SEMICOLON) {
-		condition = nil
-	} else {
+	// The condition and the increment can be left out.
+	if !p.MatchType(lexer.SEMICOLON) {
 		condition, err = p.Expression()
 		if err != nil {
 			return nil, err
@@ -225,9 +396,7 @@ func (p *RecursiveDescent) ForStatement() (ast.Stmt, error) {
 		return nil, p.Peek().MakeError("expect ';' after loop condition")
 	}
 
-	if p.TakeIfType(lexer.SEMICOLON) {
-		increment = nil
-	} else {
+	if !p.MatchType(lexer.RIGHT_PAREN) {
 		increment, err = p.Expression()
 		if err != nil {
 			return nil, err
@@ -241,18 +410,16 @@ func (p *RecursiveDescent) ForStatement() (ast.Stmt, error) {
 		return nil, err
 	}
 
-	if increment != nil {
-		body = &ast.Block{Statements: []ast.Stmt{
-			body,
-			&ast.Expression{Expression: increment},
-		}}
-	}
 	if condition == nil {
 		condition = &ast.Literal{Value: true}
 	}
+	// The increment is kept apart from the body so that
+	// a `continue` in the body still runs it.
 	body = &ast.While{
+		Keyword:   keyword,
 		Condition: condition,
 		Do:        body,
+		Increment: increment,
 	}
 
 	if initializer != nil {
@@ -265,12 +432,18 @@ func (p *RecursiveDescent) ForStatement() (ast.Stmt, error) {
 
 // block -> "{" declaration* "}" ;
 func (p *RecursiveDescent) BlockStatement() (ast.Stmt, error) {
+	start := p.current
 	var ret []ast.Stmt
+	p.blockDepth++
+	defer func() { p.blockDepth-- }()
 
 	for !p.MatchType(lexer.RIGHT_BRACE) && !p.IsAtEnd() {
 		d, err := p.Declaration()
 		if err != nil {
-			return nil, err
+			// Declaration has skipped to the next statement, so
+			// carry on with the rest of the block.
+			p.errors = append(p.errors, err)
+			continue
 		}
 		ret = append(ret, d)
 	}
@@ -278,7 +451,10 @@ func (p *RecursiveDescent) BlockStatement() (ast.Stmt, error) {
 		return nil, p.Peek().MakeError("expect closing '}'")
 	}
 	p.Next()
-	return &ast.Block{Statements: ret}, nil
+	block := &ast.Block{Statements: ret}
+	// The caller has taken the opening '{'.
+	p.track(block, start-1)
+	return block, nil
 }
 
 // ifStmt -> "if" "(" expression ")" statement ("else" statement)? ;
@@ -314,6 +490,8 @@ func (p *RecursiveDescent) IfStatement() (ast.Stmt, error) {
 }
 
 func (p *RecursiveDescent) WhileStatement() (ast.Stmt, error) {
+	p.Back()
+	keyword := p.Next()
 	if !p.TakeIfType(lexer.LEFT_PAREN) {
 		return nil, p.Peek().MakeError("expect '(' after 'while'")
 	}
@@ -329,6 +507,7 @@ func (p *RecursiveDescent) WhileStatement() (ast.Stmt, error) {
 		return nil, err
 	}
 	return &ast.While{
+		Keyword:   keyword,
 		Condition: condition,
 		Do:        doBlock,
 	}, nil
@@ -340,8 +519,8 @@ func (p *RecursiveDescent) PrintStatement() (ast.Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
-	if tok := p.Next(); tok.Type != lexer.SEMICOLON {
-		return nil, tok.MakeError("expect ';' after value")
+	if !p.TakeIfType(lexer.SEMICOLON) {
+		return nil, p.Peek().MakeError("expect ';' after value")
 	}
 	return &ast.Print{Expression: val}, nil
 }
@@ -352,8 +531,8 @@ func (p *RecursiveDescent) ExpressionStatement() (ast.Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
-	if tok := p.Next(); tok.Type != lexer.SEMICOLON && tok.Type != lexer.EOF {
-		return nil, tok.MakeError("expect ';' after value")
+	if !p.TakeIfType(lexer.SEMICOLON, lexer.EOF) {
+		return nil, p.Peek().MakeError("expect ';' after value")
 	}
 	return &ast.Expression{Expression: val}, nil
 }
@@ -384,6 +563,13 @@ func (p *RecursiveDescent) Assignment() (ast.Expr, error) {
 				Name:   v.Name,
 				Value:  value,
 			}, nil
+		case *ast.Index:
+			return &ast.IndexSet{
+				Object:  v.Object,
+				Bracket: v.Bracket,
+				Index:   v.Index,
+				Value:   value,
+			}, nil
 		default:
 			return nil, eq.MakeError("Invalid assignment target")
 		}
@@ -481,6 +667,17 @@ func (p *RecursiveDescent) Call() (ast.Expr, error) {
 				return nil, err
 			}
 			callee = &ast.Get{Object: callee, Name: name}
+		case lexer.LEFT_BRACKET:
+			p.Back()
+			bracket := p.Next()
+			index, err := p.Expression()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.Consume(lexer.RIGHT_BRACKET, "expect closing ']' after index"); err != nil {
+				return nil, err
+			}
+			callee = &ast.Index{Object: callee, Bracket: bracket, Index: index}
 		default:
 			p.Back()
 			return callee, nil
@@ -489,7 +686,7 @@ func (p *RecursiveDescent) Call() (ast.Expr, error) {
 	return callee, nil
 }
 
-// primary -> "true" | "false" | "nil" | NUMBER | STRING | "(" expression ")" | IDENT ;
+// primary -> "true" | "false" | "nil" | "this" | NUMBER | STRING | "(" expression ")" | IDENT | "super" "." IDENT | list | map | lambda | arrow ;
 func (p *RecursiveDescent) Primary() (ast.Expr, error) {
 	switch p.Next().Type {
 	case lexer.FALSE:
@@ -502,41 +699,208 @@ func (p *RecursiveDescent) Primary() (ast.Expr, error) {
 		p.Back()
 		t := p.Next()
 		return &ast.This{Keyword: t}, nil
+	case lexer.SUPER:
+		p.Back()
+		keyword := p.Next()
+		if _, err := p.Consume(lexer.DOT, "expect '.' after 'super'"); err != nil {
+			return nil, err
+		}
+		method, err := p.Consume(lexer.IDENT, "expect superclass method name")
+		if err != nil {
+			return nil, err
+		}
+		return &ast.Super{Keyword: keyword, Method: method}, nil
 	case lexer.NUMBER, lexer.STRING:
 		p.Back()
 		return &ast.Literal{Value: p.Next().Value}, nil
+	case lexer.FUN:
+		p.Back()
+		return p.Lambda()
 	case lexer.LEFT_PAREN:
+		if p.isArrowFunction() {
+			p.Back()
+			return p.ArrowFunction()
+		}
 		e, err := p.Expression()
 		if err != nil {
 			return nil, err
 		}
-		if p.Next().Type != lexer.RIGHT_PAREN {
-			return nil, p.Peek().MakeError("expected ending ')'.")
+		if _, err := p.Consume(lexer.RIGHT_PAREN, "expected ending ')'."); err != nil {
+			return nil, err
 		}
 		return &ast.Grouping{Expression: e}, nil
 	case lexer.IDENT:
 		p.Back()
 		return &ast.Variable{Name: p.Next()}, nil
+	case lexer.LEFT_BRACKET:
+		p.Back()
+		return p.ListLiteral()
+	case lexer.LEFT_BRACE:
+		p.Back()
+		return p.MapLiteral()
 	}
 	p.Back()
 	return nil, p.Peek().MakeError("unexpected token.")
 }
 
+// lambda -> "fun" "(" parameters? ")" block ;
+func (p *RecursiveDescent) Lambda() (ast.Expr, error) {
+	keyword := p.Next()
+	if _, err := p.Consume(lexer.LEFT_PAREN, "expect '(' after 'fun'"); err != nil {
+		return nil, err
+	}
+	fn, err := p.functionBody(anonymousName(keyword))
+	if err != nil {
+		return nil, err
+	}
+	return &ast.Lambda{Function: fn}, nil
+}
+
+// arrow -> "(" parameters? ")" "=>" expression ;
+func (p *RecursiveDescent) ArrowFunction() (ast.Expr, error) {
+	paren := p.Next()
+	var params []lexer.Token
+	// isArrowFunction has checked the parameter list.
+	for p.Peek().Type != lexer.RIGHT_PAREN {
+		params = append(params, p.Next())
+		p.TakeIfType(lexer.COMMA)
+	}
+	p.Next()
+	arrow := p.Next()
+	body, err := p.Expression()
+	if err != nil {
+		return nil, err
+	}
+	return &ast.Lambda{Function: &ast.Function{
+		Name:   anonymousName(paren),
+		Params: params,
+		Body:   []ast.Stmt{&ast.Return{Expression: body, Token: arrow}},
+	}}, nil
+}
+
+// isArrowFunction reports whether the '(' just taken opens
+// the parameter list of an arrow function.
+func (p *RecursiveDescent) isArrowFunction() bool {
+	i := 0
+	if p.Peek().Type != lexer.RIGHT_PAREN {
+		for {
+			if p.PeekAhead(i).Type != lexer.IDENT {
+				return false
+			}
+			if p.PeekAhead(i+1).Type != lexer.COMMA {
+				break
+			}
+			i += 2
+		}
+		i++
+	}
+	return p.PeekAhead(i).Type == lexer.RIGHT_PAREN && p.PeekAhead(i+1).Type == lexer.ARROW
+}
+
+// anonymousName is the name given to functions
+// without one, after the token that starts them.
+func anonymousName(tok lexer.Token) lexer.Token {
+	return lexer.Token{
+		Type:   lexer.IDENT,
+		Lexeme: fmt.Sprintf("anonymous@line %d", tok.Line),
+		Line:   tok.Line,
+	}
+}
+
+// list -> "[" ( expression ( "," expression )* )? "]" ;
+func (p *RecursiveDescent) ListLiteral() (ast.Expr, error) {
+	bracket := p.Next()
+	elements := make([]ast.Expr, 0)
+	if !p.MatchType(lexer.RIGHT_BRACKET) {
+		for {
+			expr, err := p.Expression()
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, expr)
+			if !p.TakeIfType(lexer.COMMA) {
+				break
+			}
+		}
+	}
+	if _, err := p.Consume(lexer.RIGHT_BRACKET, "expect closing ']' in list literal"); err != nil {
+		return nil, err
+	}
+	return &ast.List{Bracket: bracket, Elements: elements}, nil
+}
+
+// map -> "{" ( expression ":" expression ( "," expression ":" expression )* )? "}" ;
+func (p *RecursiveDescent) MapLiteral() (ast.Expr, error) {
+	brace := p.Next()
+	keys := make([]ast.Expr, 0)
+	values := make([]ast.Expr, 0)
+	if !p.MatchType(lexer.RIGHT_BRACE) {
+		for {
+			key, err := p.Expression()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.Consume(lexer.COLON, "expect ':' after map key"); err != nil {
+				return nil, err
+			}
+			value, err := p.Expression()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+			values = append(values, value)
+			if !p.TakeIfType(lexer.COMMA) {
+				break
+			}
+		}
+	}
+	if _, err := p.Consume(lexer.RIGHT_BRACE, "expect closing '}' in map literal"); err != nil {
+		return nil, err
+	}
+	return &ast.Map{Brace: brace, Keys: keys, Values: values}, nil
+}
+
+// A '{' at the start of a statement opens a block, unless it's
+// followed by a simple key and a ':', in which case it's a map literal
+// used as an expression statement. Empty braces are always a block.
+func (p *RecursiveDescent) isMapLiteral() bool {
+	switch p.PeekAhead(1).Type {
+	case lexer.STRING, lexer.NUMBER, lexer.TRUE, lexer.FALSE, lexer.NIL, lexer.IDENT:
+		return p.PeekAhead(2).Type == lexer.COLON
+	}
+	return false
+}
+
 // When a parser encounters an error while parsing a statement,
 // it can call synchronize to discard tokens until it reaches the start of
-// a new statement.
-func (p *RecursiveDescent) synchronize() {
-	var prevType lexer.TokenType
-	prevType = p.Next().Type
-	for !p.IsAtEnd() {
-		if prevType == lexer.SEMICOLON {
+// a new statement, given the token the failed statement started at.
+// Errors are made at the token that couldn't be parsed, which is kept
+// if it starts a statement, so that the errors in it are found too.
+func (p *RecursiveDescent) synchronize(start int) {
+	if p.current == start {
+		// Nothing could be parsed, so the token the error is at
+		// has to go for the parser to move on.
+		switch p.Next().Type {
+		case lexer.SEMICOLON, lexer.RIGHT_BRACE:
 			return
 		}
+	}
+	for !p.IsAtEnd() {
 		switch p.Peek().Type {
-		case lexer.CLASS, lexer.FUN, lexer.VAR, lexer.FOR, lexer.IF, lexer.WHILE, lexer.PRINT, lexer.RETURN:
+		case lexer.RIGHT_BRACE:
+			// The end of the enclosing block is left for the block
+			// to take. A stray '}' outside of blocks ends the
+			// statement instead.
+			if p.blockDepth == 0 {
+				p.Next()
+			}
+			return
+		case lexer.CLASS, lexer.FUN, lexer.VAR, lexer.FOR, lexer.IF, lexer.WHILE, lexer.PRINT, lexer.RETURN, lexer.THROW, lexer.TRY, lexer.IMPORT:
+			return
+		}
+		if p.Next().Type == lexer.SEMICOLON {
 			return
 		}
-		prevType = p.Next().Type
 	}
 }
 
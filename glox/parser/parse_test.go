@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"glox/ast"
+	"glox/errors"
+	"glox/lexer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse_Recovery(t *testing.T) {
+	prgm := `
+	var a = ;
+	print a;
+	fun f() {
+		var b = 1
+		return b;
+		print );
+	}
+	class { }
+	print "done";
+	`
+	tokens, err := lexer.ScanSource(prgm)
+	assert.NoError(t, err)
+	stmts, err := Parse(tokens)
+
+	assert.Equal(t, []int{2, 6, 7, 9}, errorLines(t, err))
+
+	// Declarations that parsed are still returned.
+	assert.Len(t, stmts, 3)
+	assert.IsType(t, &ast.Print{}, stmts[0])
+	assert.IsType(t, &ast.Function{}, stmts[1])
+	assert.IsType(t, &ast.Print{}, stmts[2])
+}
+
+func TestParse_RecoveryAtBrace(t *testing.T) {
+	// The '}' the error is at still closes the function.
+	tokens, err := lexer.ScanSource("fun f() {\n\tvar x = \n}\nprint \"a\";")
+	assert.NoError(t, err)
+	stmts, err := Parse(tokens)
+	assert.Equal(t, []int{3}, errorLines(t, err))
+	assert.Len(t, stmts, 2)
+	assert.IsType(t, &ast.Function{}, stmts[0])
+	assert.IsType(t, &ast.Print{}, stmts[1])
+
+	// A stray '}' is an error of its own.
+	tokens, err = lexer.ScanSource("}\n{ ) }")
+	assert.NoError(t, err)
+	_, err = Parse(tokens)
+	assert.Equal(t, []int{1, 2}, errorLines(t, err))
+}
+
+func TestParse_RecoveryAtStatement(t *testing.T) {
+	// The statement the error is at is parsed for errors of its own.
+	tokens, err := lexer.ScanSource("print 1\nprint +;\nprint 2;")
+	assert.NoError(t, err)
+	stmts, err := Parse(tokens)
+	assert.Equal(t, []int{2, 2}, errorLines(t, err))
+	assert.ErrorContains(t, err, "expect ';' after value")
+	assert.ErrorContains(t, err, "at '+'")
+	assert.Len(t, stmts, 1)
+}
+
+// errorLines returns the lines of the errors in the ErrorList err.
+func errorLines(t *testing.T, err error) []int {
+	list, ok := err.(errors.ErrorList)
+	assert.True(t, ok)
+	var lines []int
+	for _, e := range list {
+		lines = append(lines, e.(*errors.LoxError).LineNumber)
+	}
+	return lines
+}
+
+func TestParse_NoErrors(t *testing.T) {
+	tokens, err := lexer.ScanSource("var a = 1; { print a; }")
+	assert.NoError(t, err)
+	stmts, err := Parse(tokens)
+	assert.NoError(t, err)
+	assert.Len(t, stmts, 2)
+}
+
+func TestParseRanges(t *testing.T) {
+	tokens, err := lexer.ScanSource("var a = 1;\nfun f() {\n\tprint a;\n}\nfor (var i = 0; i < 1; i = i + 1) {}")
+	assert.NoError(t, err)
+	stmts, ranges, err := ParseRanges(tokens)
+	assert.NoError(t, err)
+	assert.Len(t, stmts, 3)
+
+	span := func(stmt ast.Stmt) [2]string {
+		r := ranges[stmt]
+		return [2]string{r.First.Lexeme, r.Last.Lexeme}
+	}
+	assert.Equal(t, [2]string{"var", ";"}, span(stmts[0]))
+	fn := stmts[1].(*ast.Function)
+	assert.Equal(t, [2]string{"fun", "}"}, span(fn))
+	assert.Equal(t, 3, ranges[fn.Body[0]].First.Line)
+	// The block a for loop is parsed into starts at the 'for'.
+	assert.Equal(t, [2]string{"for", "}"}, span(stmts[2]))
+}
@@ -17,6 +17,15 @@ func (p *Parser) Peek() lexer.Token {
 	return p.tokens[p.current]
 }
 
+// PeekAhead returns the token n places after the current one
+// without consuming anything. PeekAhead(0) is the same as Peek.
+func (p *Parser) PeekAhead(n int) lexer.Token {
+	if p.current+n >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.current+n]
+}
+
 func (p *Parser) Next() lexer.Token {
 	ret := p.Peek()
 	if !p.IsAtEnd() {
@@ -0,0 +1,155 @@
+package profile
+
+import (
+	"compress/gzip"
+	"glox/runtime"
+	"io"
+	"strings"
+)
+
+// WritePprof writes p to w as a gzipped profile.proto, the format read
+// by `go tool pprof`. Each call stack is a sample, of the statements
+// executed and the time spent in it, and each function and line it's
+// on a location, so pprof shows lox code rather than the tree walker.
+func WritePprof(w io.Writer, p *runtime.Profiler) error {
+	e := &encoder{strings: map[string]int{"": 0}, stringTable: []string{""}}
+	var prof buffer
+
+	for _, st := range [][2]string{{"statements", "count"}, {"time", "nanoseconds"}} {
+		var vt buffer
+		vt.int(1, int64(e.str(st[0])))
+		vt.int(2, int64(e.str(st[1])))
+		prof.message(1, &vt)
+	}
+
+	funcs := make(map[*runtime.FuncProfile]uint64)
+	type loc struct {
+		fn   *runtime.FuncProfile
+		line int
+	}
+	locs := make(map[loc]uint64)
+	var functions, locations []buffer
+	for _, s := range p.Samples() {
+		ids := make([]uint64, len(s.Stack))
+		for i, f := range s.Stack {
+			fid, ok := funcs[f.Func]
+			if !ok {
+				fid = uint64(len(funcs) + 1)
+				funcs[f.Func] = fid
+				var fn buffer
+				fn.int(1, int64(fid))
+				// pprof drops what's between angle brackets,
+				// as it does with C++ templates.
+				name := strings.Trim(f.Func.Name, "<>")
+				fn.int(2, int64(e.str(name)))
+				fn.int(3, int64(e.str(name)))
+				fn.int(4, int64(e.str(f.Func.File)))
+				fn.int(5, int64(f.Func.Line))
+				functions = append(functions, fn)
+			}
+			key := loc{f.Func, f.Line}
+			lid, ok := locs[key]
+			if !ok {
+				lid = uint64(len(locs) + 1)
+				locs[key] = lid
+				var line, l buffer
+				line.int(1, int64(fid))
+				line.int(2, int64(f.Line))
+				l.int(1, int64(lid))
+				l.message(4, &line)
+				locations = append(locations, l)
+			}
+			ids[i] = lid
+		}
+		var sample buffer
+		sample.packed(1, ids)
+		sample.packed(2, []uint64{uint64(s.Statements), uint64(s.Time.Nanoseconds())})
+		prof.message(2, &sample)
+	}
+	for i := range locations {
+		prof.message(4, &locations[i])
+	}
+	for i := range functions {
+		prof.message(5, &functions[i])
+	}
+
+	// The time is the sample shown by default.
+	defaultType := e.str("time")
+	for _, s := range e.stringTable {
+		prof.bytes(6, []byte(s))
+	}
+	prof.int(10, p.Duration().Nanoseconds())
+	prof.int(14, int64(defaultType))
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(prof.data); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// encoder collects the string table of a profile.
+type encoder struct {
+	strings     map[string]int
+	stringTable []string
+}
+
+// str returns the index of s in the string table.
+func (e *encoder) str(s string) int {
+	if i, ok := e.strings[s]; ok {
+		return i
+	}
+	e.strings[s] = len(e.stringTable)
+	e.stringTable = append(e.stringTable, s)
+	return e.strings[s]
+}
+
+// buffer encodes a protocol buffers message. Fields with the
+// zero value are left out, as they're the default anyway.
+type buffer struct {
+	data []byte
+}
+
+func (b *buffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+// key writes the key of a field with its wire type:
+// 0 for varints and 2 for length delimited data.
+func (b *buffer) key(field, wireType int) {
+	b.varint(uint64(field<<3 | wireType))
+}
+
+func (b *buffer) int(field int, x int64) {
+	if x == 0 {
+		return
+	}
+	b.key(field, 0)
+	b.varint(uint64(x))
+}
+
+// bytes writes a string or bytes field, which, unlike the
+// other fields, is written when empty: the string table
+// starts with the empty string.
+func (b *buffer) bytes(field int, data []byte) {
+	b.key(field, 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *buffer) message(field int, m *buffer) {
+	b.bytes(field, m.data)
+}
+
+// packed writes a repeated field of varints.
+func (b *buffer) packed(field int, xs []uint64) {
+	var p buffer
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.bytes(field, p.data)
+}
@@ -0,0 +1,59 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"glox/runtime"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// twice profiles testdata/twice.lx with a clock a millisecond
+// later every time it's read.
+func twice(t *testing.T) *runtime.Profiler {
+	now := time.Unix(0, 0)
+	prof := runtime.NewProfiler(func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now
+	})
+	l := runtime.NewLoxInterpreter()
+	l.Stdout = &bytes.Buffer{}
+	l.Profiler = prof
+	_, err := l.RunFile("testdata/twice.lx")
+	require.NoError(t, err)
+	prof.Stop()
+	return prof
+}
+
+func TestReport(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Report(&buf, twice(t), 2))
+	assert.Equal(t, `total time 18ms
+
+function  calls  inclusive  exclusive  declared
+<script>  1      18ms       12ms       testdata/twice.lx
+twice     2      4ms        4ms        testdata/twice.lx:1
+push      2      2ms        2ms        built-in
+
+line                 time  statements
+testdata/twice.lx:5  11ms  3
+testdata/twice.lx:2  2ms   2
+`, buf.String())
+}
+
+func TestWritePprof(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WritePprof(&buf, twice(t)))
+	gz, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	data, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	for _, s := range []string{"statements", "count", "time", "nanoseconds", "twice", "push", "script", "testdata/twice.lx"} {
+		assert.Contains(t, string(data), s)
+	}
+	assert.NotContains(t, string(data), "<script>")
+}
@@ -0,0 +1,56 @@
+/*
+Package profile reports the profiles recorded by a runtime.Profiler,
+either as text, or in the format of pprof so that `go tool pprof`
+shows the lox functions and lines a program spent its time in.
+*/
+package profile
+
+import (
+	"fmt"
+	"glox/runtime"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// Report writes the time profiled, the calls and time of each function,
+// the longest running first, and the lines the longest running, up to
+// lines of them, to w. Files under the working directory are shown
+// relative to it.
+func Report(w io.Writer, p *runtime.Profiler, lines int) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "total time %s\n\n", p.Duration())
+	fmt.Fprintln(tw, "function\tcalls\tinclusive\texclusive\tdeclared")
+	for _, fn := range p.Functions() {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", fn.Name, fn.Calls, fn.Inclusive, fn.Exclusive, declared(fn))
+	}
+	fmt.Fprintln(tw, "\nline\ttime\tstatements")
+	for i, l := range p.Lines() {
+		if i == lines {
+			break
+		}
+		fmt.Fprintf(tw, "%s:%d\t%s\t%d\n", relative(l.File), l.Line, l.Time, l.Statements)
+	}
+	return tw.Flush()
+}
+
+func declared(fn *runtime.FuncProfile) string {
+	switch {
+	case fn.Native:
+		return "built-in"
+	case fn.Line == 0:
+		return relative(fn.File)
+	}
+	return fmt.Sprintf("%s:%d", relative(fn.File), fn.Line)
+}
+
+func relative(path string) string {
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return path
+}
@@ -0,0 +1,281 @@
+package runtime
+
+import (
+	"fmt"
+	"reflect"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+Bind makes a Go value available to lox programs as the global name.
+Functions become natives that convert their arguments and results,
+and pointers to structs become proxies exposing their fields and
+methods as properties. Other values are converted like ToLox.
+
+	type Point struct{ X, Y float64 }
+	func (p *Point) Len() float64 { return math.Hypot(p.X, p.Y) }
+
+	l.Bind("origin", &Point{})
+	l.Bind("add", func(a, b int) int { return a + b })
+	l.Run(`origin.x = add(1, 2); print origin.len();`)
+*/
+func (l *Lox) Bind(name string, v any) error {
+	var val any
+	var err error
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Func {
+		val, err = newGoFunction(name, rv)
+	} else {
+		val, err = toLox(rv, true)
+	}
+	if err != nil {
+		return err
+	}
+	l.Globals.Declare(name, val)
+	return nil
+}
+
+// NewGoFunction wraps the Go function fn in a native lox function.
+// Its arguments are converted to the parameter types of fn, and its
+// results back to lox values, a single result being returned as is
+// and several as a list. A last result of type error is returned as
+// a runtime error at the call site when it isn't nil.
+func NewGoFunction(name string, fn any) (*GoCallable, error) {
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func {
+		return nil, fmt.Errorf("can't bind %T as a function", fn)
+	}
+	return newGoFunction(name, rv)
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+func newGoFunction(name string, fn reflect.Value) (*GoCallable, error) {
+	t := fn.Type()
+	if t.IsVariadic() {
+		return nil, fmt.Errorf("can't bind variadic function %s", t)
+	}
+	if name == "" {
+		name = "go function"
+	}
+	returnsErr := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	call := func(_ *TreeEvaluator, args []any) (any, error) {
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			val, err := toGo(arg, t.In(i))
+			if err != nil {
+				return nil, fmt.Errorf("argument %d of '%s': %s", i+1, name, err)
+			}
+			in[i] = val
+		}
+		out := fn.Call(in)
+		if returnsErr {
+			if err := out[len(out)-1]; !err.IsNil() {
+				return nil, err.Interface().(error)
+			}
+			out = out[:len(out)-1]
+		}
+		results := make([]any, len(out))
+		for i, o := range out {
+			val, err := toLox(o, true)
+			if err != nil {
+				return nil, fmt.Errorf("result of '%s': %s", name, err)
+			}
+			results[i] = val
+		}
+		switch len(results) {
+		case 0:
+			return nil, nil
+		case 1:
+			return results[0], nil
+		}
+		return NewLoxList(results), nil
+	}
+	return &GoCallable{F: call, A: t.NumIn(), Name: name}, nil
+}
+
+// toGo converts the lox value v to a Go value of type t.
+func toGo(v any, t reflect.Type) (reflect.Value, error) {
+	if v == nil {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, fmt.Errorf("expected %s, found nil", t)
+	}
+	if p, ok := v.(*Proxy); ok {
+		if p.ptr.Type().AssignableTo(t) {
+			return p.ptr, nil
+		}
+		if p.ptr.Elem().Type().AssignableTo(t) {
+			return p.ptr.Elem(), nil
+		}
+	}
+	if rv := reflect.ValueOf(v); rv.Type().AssignableTo(t) && t.Kind() != reflect.Interface {
+		return rv, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := v.(float64)
+		if !ok {
+			break
+		}
+		if n != float64(int64(n)) {
+			return reflect.Value{}, fmt.Errorf("expected %s, found %s", t, repr(v))
+		}
+		val := reflect.New(t).Elem()
+		if t.Kind() >= reflect.Uint {
+			if n < 0 || val.OverflowUint(uint64(n)) {
+				return reflect.Value{}, fmt.Errorf("%s overflows %s", repr(v), t)
+			}
+			val.SetUint(uint64(n))
+		} else {
+			if val.OverflowInt(int64(n)) {
+				return reflect.Value{}, fmt.Errorf("%s overflows %s", repr(v), t)
+			}
+			val.SetInt(int64(n))
+		}
+		return val, nil
+	case reflect.Float32, reflect.Float64:
+		if n, ok := v.(float64); ok {
+			return reflect.ValueOf(n).Convert(t), nil
+		}
+	case reflect.String:
+		if s, ok := v.(string); ok {
+			return reflect.ValueOf(s).Convert(t), nil
+		}
+	case reflect.Bool:
+		if b, ok := v.(bool); ok {
+			return reflect.ValueOf(b).Convert(t), nil
+		}
+	case reflect.Slice:
+		list, ok := v.(*LoxList)
+		if !ok {
+			break
+		}
+		val := reflect.MakeSlice(t, len(list.Elements), len(list.Elements))
+		for i, e := range list.Elements {
+			elem, err := toGo(e, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("element %d: %s", i, err)
+			}
+			val.Index(i).Set(elem)
+		}
+		return val, nil
+	case reflect.Map:
+		m, ok := v.(*LoxMap)
+		if !ok {
+			break
+		}
+		val := reflect.MakeMapWithSize(t, len(m.keys))
+		for _, k := range m.keys {
+			key, err := toGo(k, t.Key())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("key %s: %s", repr(k), err)
+			}
+			elem, err := toGo(m.data[k], t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("value of %s: %s", repr(k), err)
+			}
+			val.SetMapIndex(key, elem)
+		}
+		return val, nil
+	case reflect.Interface:
+		if p, ok := v.(*Proxy); ok {
+			v = p.Value()
+		} else {
+			v = FromLox(v)
+		}
+		if rv := reflect.ValueOf(v); rv.Type().AssignableTo(t) {
+			return rv, nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("expected %s, found %s", t, repr(v))
+}
+
+// loxPackages are the packages of the backends, whose
+// structs are lox values rather than Go structs to proxy.
+var loxPackages = map[string]bool{reflect.TypeOf(Proxy{}).PkgPath(): true}
+
+// RegisterValues records that the structs of the package v is
+// declared in are lox values of a backend, which are passed to lox
+// as they are rather than proxied. Backends call it when they're
+// initialized.
+func RegisterValues(v any) {
+	loxPackages[reflect.TypeOf(v).PkgPath()] = true
+}
+
+func isLoxValue(ptr reflect.Value) bool {
+	return loxPackages[ptr.Type().Elem().PkgPath()]
+}
+
+// Proxy is the lox value of a pointer to a Go struct. The exported
+// fields and methods of the struct are its properties, which lox code
+// can refer to with the first letter in lower case. Fields can be
+// assigned; the values are converted to the type of the field.
+type Proxy struct {
+	ptr reflect.Value
+}
+
+// NewProxy returns a proxy for ptr, which must point to a struct.
+func NewProxy(ptr any) (*Proxy, error) {
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Ptr || rv.Type().Elem().Kind() != reflect.Struct || rv.IsNil() {
+		return nil, fmt.Errorf("can't proxy %T, expected a pointer to a struct", ptr)
+	}
+	return &Proxy{ptr: rv}, nil
+}
+
+// Value returns the pointer the proxy stands for.
+func (p *Proxy) Value() any {
+	return p.ptr.Interface()
+}
+
+func (p *Proxy) String() string {
+	return fmt.Sprintf("<go %s>", p.ptr.Type())
+}
+
+// Get implements Object.
+func (p *Proxy) Get(name string) (any, bool) {
+	goName := exported(name)
+	if m := p.ptr.MethodByName(goName); m.IsValid() {
+		fn, err := newGoFunction(name, m)
+		return fn, err == nil
+	}
+	field, ok := p.field(goName)
+	if !ok {
+		return nil, false
+	}
+	val, err := toLox(field, true)
+	return val, err == nil
+}
+
+// Set assigns value to the field called name.
+func (p *Proxy) Set(name string, value any) error {
+	field, ok := p.field(exported(name))
+	if !ok {
+		return fmt.Errorf("%s has no field '%s'", p.ptr.Type(), name)
+	}
+	val, err := toGo(value, field.Type())
+	if err != nil {
+		return fmt.Errorf("can't assign field '%s': %s", name, err)
+	}
+	field.Set(val)
+	return nil
+}
+
+func (p *Proxy) field(name string) (reflect.Value, bool) {
+	sf, ok := p.ptr.Type().Elem().FieldByName(name)
+	if !ok || !sf.IsExported() {
+		return reflect.Value{}, false
+	}
+	return p.ptr.Elem().FieldByIndex(sf.Index), true
+}
+
+// exported returns name with its first letter in upper case.
+func exported(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[size:]
+}
@@ -0,0 +1,121 @@
+package runtime_test
+
+import (
+	"errors"
+	"glox/runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type account struct {
+	Owner   string
+	Balance int
+	Tags    []string
+	Limits  map[string]float64
+	secret  string
+}
+
+func (a *account) Deposit(n int) int {
+	a.Balance += n
+	return a.Balance
+}
+
+func (a *account) Withdraw(n int) (int, error) {
+	if n > a.Balance {
+		return a.Balance, errors.New("insufficient funds")
+	}
+	a.Balance -= n
+	return a.Balance, nil
+}
+
+func TestLox_Bind(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		l := newLox()
+		acct := &account{Owner: "bob", Tags: []string{"a"}}
+		assert.NoError(t, l.Bind("acct", acct))
+		assert.NoError(t, l.Bind("join", strings.Join))
+		assert.NoError(t, l.Bind("open", func(owner string) *account {
+			return &account{Owner: owner}
+		}))
+		assert.NoError(t, l.Bind("split", func(s string) (string, string) {
+			before, after, _ := strings.Cut(s, ":")
+			return before, after
+		}))
+
+		val, err := l.Run(`acct.deposit(10); acct.withdraw(3);`)
+		assert.NoError(t, err)
+		assert.Equal(t, 7., val)
+		assert.Equal(t, 7, acct.Balance)
+
+		_, err = l.Run(`acct.owner = "alice"; acct.tags = ["x", "y"]; acct.limits = {"day": 1.5};`)
+		assert.NoError(t, err)
+		assert.Equal(t, "alice", acct.Owner)
+		assert.Equal(t, []string{"x", "y"}, acct.Tags)
+		assert.Equal(t, map[string]float64{"day": 1.5}, acct.Limits)
+
+		val, err = l.Run(`join(acct.tags, "-");`)
+		assert.NoError(t, err)
+		assert.Equal(t, "x-y", val)
+
+		val, err = l.Run(`var b = open("carol"); b.deposit(2); b.owner + to_string(b.balance);`)
+		assert.NoError(t, err)
+		assert.Equal(t, "carol2", val)
+
+		val, err = l.Run(`split("k:v");`)
+		assert.NoError(t, err)
+		assert.Equal(t, []any{"k", "v"}, runtime.FromLox(val))
+
+		// Values of the backend come back from Go as they went in.
+		assert.NoError(t, l.Bind("id", func(v any) any { return v }))
+		val, err = l.Run(`class A { f() { return 2; } } id(fun() { return 1; })() + id(A()).f();`)
+		assert.NoError(t, err)
+		assert.Equal(t, 3., val)
+
+		val, err = l.Run(`to_string(acct) + " " + to_string(join);`)
+		assert.NoError(t, err)
+		assert.Equal(t, "<go *runtime_test.account> <built-in fun join>", val)
+	})
+}
+
+func TestLox_Bind_Errors(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		l := newLox()
+		l.Stderr = &strings.Builder{}
+		acct := &account{Balance: 5}
+		assert.NoError(t, l.Bind("acct", acct))
+		assert.NoError(t, l.Bind("repeat", strings.Repeat))
+
+		_, err := l.Run(`acct.withdraw(10);`)
+		assert.ErrorContains(t, err, "[line 1] at ')': insufficient funds")
+
+		_, err = l.Run(`repeat("a", 1.5);`)
+		assert.ErrorContains(t, err, "[line 1] at ')': argument 2 of 'repeat': expected int, found 1.5")
+
+		_, err = l.Run(`repeat(1, 2);`)
+		assert.ErrorContains(t, err, "argument 1 of 'repeat': expected string, found 1")
+
+		_, err = l.Run(`repeat("a");`)
+		assert.ErrorContains(t, err, "Expect 2 args, found 1")
+
+		_, err = l.Run(`acct.balance = "lots";`)
+		assert.ErrorContains(t, err, "can't assign field 'balance': expected int, found \"lots\"")
+
+		_, err = l.Run(`acct.tags = [1];`)
+		assert.ErrorContains(t, err, "can't assign field 'tags': element 0: expected string, found 1")
+
+		_, err = l.Run(`acct.secret;`)
+		assert.ErrorContains(t, err, "undefined field")
+
+		_, err = l.Run(`acct.secret = "x";`)
+		assert.ErrorContains(t, err, "has no field 'secret'")
+		assert.Equal(t, 5, acct.Balance)
+	})
+
+	l := runtime.NewLoxInterpreter()
+	assert.ErrorContains(t, l.Bind("f", func(...int) {}), "can't bind variadic function")
+	assert.ErrorContains(t, l.Bind("c", make(chan int)), "can't convert chan int to a lox value")
+	_, err := runtime.NewProxy(account{})
+	assert.ErrorContains(t, err, "expected a pointer to a struct")
+}
@@ -2,6 +2,10 @@ package runtime
 
 import (
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
 	"time"
 )
 
@@ -10,15 +14,132 @@ type Callable interface {
 	Call(*TreeEvaluator, []any) (any, error)
 }
 
+// natives are the functions built in to lox.
+var natives = []Native{
+	{Name: "to_string", Arity: 1, F: LoxStringify},
+	{Name: "time", Arity: 0, F: LoxTime, Capability: CapTime},
+	{Name: "read_file", Arity: 1, F: LoxReadFile, Capability: CapFS},
+	{Name: "write_file", Arity: 2, F: LoxWriteFile, Capability: CapFS},
+	{Name: "getenv", Arity: 1, F: LoxGetenv, Capability: CapEnv},
+	{Name: "exec", Arity: 1, F: LoxExec, Capability: CapExec},
+	{Name: "http_get", Arity: 1, F: LoxHTTPGet, Capability: CapNet},
+}
+
 func LoxTime(l *TreeEvaluator, args []any) (any, error) {
 	return float64(time.Now().UnixMilli()) / 1000., nil
 }
 
-func LoxStringify(l *TreeEvaluator, args []any) (any, error) {
+func LoxStringify(te *TreeEvaluator, args []any) (any, error) {
+	// Lists and maps can print as far more than the memory they take,
+	// so the string is counted before it's built, up to what the
+	// memory limit has left.
+	if err := te.alloc(printedLen(args[0], te.Meter.available())); err != nil {
+		return nil, err
+	}
 	return fmt.Sprintf("%v", args[0]), nil
 }
 
-func DefineNativeFunctions(e *Environment) {
-	e.Declare("to_string", NewGoCallable(LoxStringify, 1))
-	e.Declare("time", NewGoCallable(LoxTime, 0))
+// LoxReadFile returns the contents of the file at a path.
+func LoxReadFile(te *TreeEvaluator, args []any) (any, error) {
+	path, err := stringArg("read_file", args[0])
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := te.alloc(len(data)); err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// LoxWriteFile replaces the contents of the file at a path.
+func LoxWriteFile(te *TreeEvaluator, args []any) (any, error) {
+	path, err := stringArg("write_file", args[0])
+	if err != nil {
+		return nil, err
+	}
+	data, err := stringArg("write_file", args[1])
+	if err != nil {
+		return nil, err
+	}
+	return nil, os.WriteFile(path, []byte(data), 0o644)
+}
+
+// LoxGetenv returns the value of an environment
+// variable, or nil if it isn't set.
+func LoxGetenv(te *TreeEvaluator, args []any) (any, error) {
+	name, err := stringArg("getenv", args[0])
+	if err != nil {
+		return nil, err
+	}
+	if val, ok := os.LookupEnv(name); ok {
+		return val, nil
+	}
+	return nil, nil
+}
+
+// LoxExec runs the program and arguments of a list of
+// strings, returning what the program writes to stdout.
+func LoxExec(te *TreeEvaluator, args []any) (any, error) {
+	list, ok := args[0].(*LoxList)
+	if !ok || len(list.Elements) == 0 {
+		return nil, fmt.Errorf("exec expects a list of the program and its arguments, found %s", repr(args[0]))
+	}
+	argv := make([]string, len(list.Elements))
+	for i, e := range list.Elements {
+		s, err := stringArg("exec", e)
+		if err != nil {
+			return nil, err
+		}
+		argv[i] = s
+	}
+	cmd := exec.CommandContext(te.context(), argv[0], argv[1:]...)
+	cmd.Stderr = te.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("exec %s: %s", argv[0], err)
+	}
+	if err := te.alloc(len(out)); err != nil {
+		return nil, err
+	}
+	return string(out), nil
+}
+
+// LoxHTTPGet returns the body of the response to a GET request.
+func LoxHTTPGet(te *TreeEvaluator, args []any) (any, error) {
+	url, err := stringArg("http_get", args[0])
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(te.context(), http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http_get %s: %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := te.alloc(len(body)); err != nil {
+		return nil, err
+	}
+	return string(body), nil
+}
+
+func stringArg(native string, arg any) (string, error) {
+	s, ok := arg.(string)
+	if !ok {
+		return "", fmt.Errorf("%s expects a string, found %s", native, repr(arg))
+	}
+	return s, nil
 }
@@ -0,0 +1,134 @@
+package runtime
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Capability names a group of natives that reach outside the
+// interpreter. Programs can only call the natives of the
+// capabilities their interpreter has been granted.
+type Capability string
+
+const (
+	// Reading and writing files.
+	CapFS Capability = "io.fs"
+	// Reading environment variables.
+	CapEnv Capability = "os.env"
+	// Running other programs.
+	CapExec Capability = "os.exec"
+	// Making network requests.
+	CapNet Capability = "net"
+	// Reading the clock.
+	CapTime Capability = "time"
+)
+
+// AllCapabilities are the capabilities of the natives built in to lox.
+var AllCapabilities = []Capability{CapFS, CapEnv, CapExec, CapNet, CapTime}
+
+// Capabilities is a set of granted capabilities.
+type Capabilities map[Capability]bool
+
+// ParseCapabilities parses a comma separated list of capabilities,
+// where "all" stands for every capability in AllCapabilities.
+func ParseCapabilities(list string) (Capabilities, error) {
+	caps := make(Capabilities)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+		case name == "all":
+			for _, c := range AllCapabilities {
+				caps[c] = true
+			}
+		case isCapability(Capability(name)):
+			caps[Capability(name)] = true
+		default:
+			return nil, fmt.Errorf("unknown capability '%s'", name)
+		}
+	}
+	return caps, nil
+}
+
+func isCapability(c Capability) bool {
+	for _, known := range AllCapabilities {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Capabilities) String() string {
+	names := make([]string, 0, len(c))
+	for name, granted := range c {
+		if granted {
+			names = append(names, string(name))
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// PermissionError is the error of a call to a native whose capability
+// wasn't granted. Programs can catch it, and hosts can find it with
+// errors.As in the runtime error it's raised as.
+type PermissionError struct {
+	Native     string
+	Capability Capability
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("permission denied: '%s' needs the '%s' capability", e.Native, e.Capability)
+}
+
+// Native is a function built in to the interpreter.
+type Native struct {
+	Name  string
+	Arity int
+	F     func(*TreeEvaluator, []any) (any, error)
+	// The capability needed to call the native,
+	// empty for natives any program can call.
+	Capability Capability
+}
+
+// callable returns the value programs call the native through,
+// which checks the capability of the calling program.
+func (n Native) callable() *GoCallable {
+	if n.Capability == "" {
+		return &GoCallable{F: n.F, A: n.Arity, Name: n.Name}
+	}
+	guarded := func(te *TreeEvaluator, args []any) (any, error) {
+		if !te.Capabilities[n.Capability] {
+			return nil, &PermissionError{Native: n.Name, Capability: n.Capability}
+		}
+		return n.F(te, args)
+	}
+	return &GoCallable{F: guarded, A: n.Arity, Name: n.Name}
+}
+
+// DefineNatives declares the natives built in to lox in e.
+// All of them are declared, so that a program calling one it
+// isn't allowed to gets a PermissionError it can handle.
+func DefineNatives(e *Environment) {
+	for _, n := range natives {
+		e.Declare(n.Name, n.callable())
+	}
+}
+
+// DefineNative makes a native of the host available to programs,
+// as long as they're granted its capability.
+func (l *Lox) DefineNative(n Native) {
+	l.Globals.Declare(n.Name, n.callable())
+}
+
+// Grant allows programs run by l to use caps.
+func (l *Lox) Grant(caps ...Capability) {
+	if l.Capabilities == nil {
+		l.Capabilities = make(Capabilities)
+	}
+	for _, c := range caps {
+		l.Capabilities[c] = true
+	}
+}
@@ -0,0 +1,176 @@
+package runtime_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"glox/runtime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLox_Capabilities_Denied(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		l := newLox()
+		l.Stderr = &bytes.Buffer{}
+
+		_, err := l.Run("time();")
+		assert.ErrorContains(t, err, "[line 1] at ')': permission denied: 'time' needs the 'time' capability")
+		var pe *runtime.PermissionError
+		if assert.True(t, errors.As(err, &pe)) {
+			assert.Equal(t, &runtime.PermissionError{Native: "time", Capability: runtime.CapTime}, pe)
+		}
+
+		val, err := l.Run(`
+		var msg;
+		try { read_file("/etc/passwd"); } catch (e) { msg = e.message; }
+		msg;`)
+		assert.NoError(t, err)
+		assert.Equal(t, "permission denied: 'read_file' needs the 'io.fs' capability", val)
+
+		l.Grant(runtime.CapTime)
+		_, err = l.Run("time();")
+		assert.NoError(t, err)
+		_, err = l.Run(`getenv("HOME");`)
+		assert.ErrorContains(t, err, "'getenv' needs the 'os.env' capability")
+
+		val, err = l.Run("to_string(1 + 1);")
+		assert.NoError(t, err)
+		assert.Equal(t, "2", val)
+	})
+}
+
+func TestLox_Capabilities_Granted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "pong")
+	}))
+	defer srv.Close()
+	t.Setenv("GLOX_TEST_VAR", "set")
+
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		l := newLox()
+		l.Stderr = &bytes.Buffer{}
+		caps, err := runtime.ParseCapabilities("all")
+		assert.NoError(t, err)
+		l.Capabilities = caps
+
+		file := filepath.Join(t.TempDir(), "out.txt")
+		assert.NoError(t, l.SetGlobal("file", file))
+		val, err := l.Run(`write_file(file, "hello"); read_file(file);`)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", val)
+		data, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(data))
+
+		val, err = l.Run(`[getenv("GLOX_TEST_VAR"), getenv("GLOX_TEST_UNSET")];`)
+		assert.NoError(t, err)
+		assert.Equal(t, []any{"set", nil}, runtime.FromLox(val))
+
+		val, err = l.Run(`exec(["echo", "hi"]);`)
+		assert.NoError(t, err)
+		assert.Equal(t, "hi\n", val)
+		_, err = l.Run(`exec("echo");`)
+		assert.ErrorContains(t, err, "exec expects a list of the program and its arguments")
+
+		assert.NoError(t, l.SetGlobal("url", srv.URL))
+		val, err = l.Run(`http_get(url);`)
+		assert.NoError(t, err)
+		assert.Equal(t, "pong", val)
+
+		_, err = l.Run(`read_file(1);`)
+		assert.ErrorContains(t, err, "read_file expects a string, found 1")
+	})
+}
+
+func TestLox_Capabilities_Import(t *testing.T) {
+	dir, outside := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		filepath.Join(dir, "main.lx"):        `import "lib/util.lx" as util; print util.x;`,
+		filepath.Join(dir, "lib", "util.lx"): `var x = 1;`,
+		filepath.Join(dir, "notes.txt"):      `abc`,
+		filepath.Join(outside, "conf.lx"):    `var API_KEY = "secret";`,
+	}
+	for name, src := range files {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+		assert.NoError(t, os.WriteFile(name, []byte(src), 0o644))
+	}
+	conf := filepath.Join(outside, "conf.lx")
+
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		l := newLox()
+		l.Stdout, l.Stderr = &bytes.Buffer{}, &bytes.Buffer{}
+		_, err := l.RunFile(filepath.Join(dir, "main.lx"))
+		assert.NoError(t, err)
+
+		for _, src := range []string{
+			fmt.Sprintf(`import "%s" as c; print c.API_KEY;`, conf),
+			`import "notes.txt" as n;`,
+		} {
+			path := filepath.Join(dir, "denied.lx")
+			assert.NoError(t, os.WriteFile(path, []byte(src), 0o644))
+			l := newLox()
+			l.Stdout, l.Stderr = &bytes.Buffer{}, &bytes.Buffer{}
+			_, err := l.RunFile(path)
+			assert.ErrorContains(t, err, "needs the 'io.fs' capability")
+			assert.NotContains(t, err.Error(), "abc")
+		}
+
+		// The directories of l.Paths can be imported from.
+		l = newLox()
+		l.Stdout = &bytes.Buffer{}
+		l.Paths = []string{outside}
+		val, err := l.Run(`import "conf.lx" as c; c.API_KEY;`)
+		assert.NoError(t, err)
+		assert.Equal(t, "secret", val)
+
+		l = newLox()
+		l.Grant(runtime.CapFS)
+		val, err = l.Run(fmt.Sprintf(`import "%s" as c; c.API_KEY;`, conf))
+		assert.NoError(t, err)
+		assert.Equal(t, "secret", val)
+	})
+}
+
+func TestLox_DefineNative(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		l := newLox()
+		l.Stderr = &bytes.Buffer{}
+		l.DefineNative(runtime.Native{
+			Name:       "hostname",
+			Arity:      0,
+			F:          func(*runtime.TreeEvaluator, []any) (any, error) { return "box", nil },
+			Capability: runtime.CapNet,
+		})
+		_, err := l.Run("hostname();")
+		assert.ErrorContains(t, err, "'hostname' needs the 'net' capability")
+
+		l.Grant(runtime.CapNet)
+		val, err := l.Run("hostname();")
+		assert.NoError(t, err)
+		assert.Equal(t, "box", val)
+	})
+}
+
+func TestParseCapabilities(t *testing.T) {
+	caps, err := runtime.ParseCapabilities("time, io.fs")
+	assert.NoError(t, err)
+	assert.Equal(t, runtime.Capabilities{runtime.CapTime: true, runtime.CapFS: true}, caps)
+	assert.Equal(t, "io.fs,time", caps.String())
+
+	caps, err = runtime.ParseCapabilities("")
+	assert.NoError(t, err)
+	assert.Empty(t, caps)
+
+	caps, err = runtime.ParseCapabilities("all")
+	assert.NoError(t, err)
+	assert.Len(t, caps, len(runtime.AllCapabilities))
+
+	_, err = runtime.ParseCapabilities("time,disk")
+	assert.EqualError(t, err, "unknown capability 'disk'")
+}
@@ -4,15 +4,24 @@ import (
 	"fmt"
 )
 
+// Object is a runtime value with properties that
+// can be read with `object.name` syntax.
+type Object interface {
+	Get(name string) (any, bool)
+}
+
 type LoxClass struct {
-	Name    string
-	Methods map[string]*LoxFunction
+	Name       string
+	Superclass *LoxClass
+	Methods    map[string]*LoxFunction
 }
 
 func (cls *LoxClass) Call(lox *TreeEvaluator, args []any) (any, error) {
 	instance := NewLoxInstance(cls)
 	if init, ok := cls.FindMethod("init"); ok {
-		init.Bind(instance).Call(lox, args)
+		if _, err := init.Bind(instance).Call(lox, args); err != nil {
+			return nil, err
+		}
 	}
 	return instance, nil
 }
@@ -28,9 +37,15 @@ func (cls *LoxClass) String() string {
 	return fmt.Sprintf("<class '%s'>", cls.Name)
 }
 
+// FindMethod looks up a method on this class, falling back
+// to the superclass chain if the class doesn't define it.
 func (cls *LoxClass) FindMethod(name string) (*LoxFunction, bool) {
-	val, ok := cls.Methods[name]
-	return val, ok
+	for c := cls; c != nil; c = c.Superclass {
+		if val, ok := c.Methods[name]; ok {
+			return val, true
+		}
+	}
+	return nil, false
 }
 
 type LoxInstance struct {
@@ -0,0 +1,106 @@
+package runtime_test
+
+import (
+	"bytes"
+	"fmt"
+	"glox/errors"
+	"glox/runtime"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The conformance suite is the lox files under testdata/conformance.
+// Comments in them state what running the file does:
+//
+//	print 1 + 2; // expect: 3
+//	1 / 0;       // expect runtime error: divide by 0
+//	var = 1;     // expect error: expect a variable name.
+//
+// Expected output is matched line by line, in order. Errors, either
+// a runtime error or the syntax and resolution errors found before
+// the program runs, are matched with the line of their annotation.
+var (
+	expectOutput = regexp.MustCompile(`// expect: (.*)$`)
+	expectError  = regexp.MustCompile(`// expect (?:runtime )?error: (.*)$`)
+)
+
+// expectations are what a conformance file is annotated with.
+type expectations struct {
+	output []string
+	errors []string
+}
+
+func parseExpectations(source string) expectations {
+	var exp expectations
+	for i, line := range strings.Split(source, "\n") {
+		if m := expectOutput.FindStringSubmatch(line); m != nil {
+			exp.output = append(exp.output, m[1])
+		}
+		if m := expectError.FindStringSubmatch(line); m != nil {
+			exp.errors = append(exp.errors, fmt.Sprintf("[line %d] %s", i+1, m[1]))
+		}
+	}
+	return exp
+}
+
+// errorLines describes each error in err as "[line N] message".
+func errorLines(err error) []string {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case errors.ErrorList:
+		var lines []string
+		for _, err := range e {
+			lines = append(lines, errorLines(err)...)
+		}
+		return lines
+	case interface{ LoxError() *errors.LoxError }:
+		return errorLines(e.LoxError())
+	case *errors.LoxError:
+		return []string{fmt.Sprintf("[line %d] %s", e.LineNumber, e.Message)}
+	}
+	return []string{err.Error()}
+}
+
+func TestConformance(t *testing.T) {
+	var files []string
+	err := filepath.WalkDir(filepath.Join("testdata", "conformance"), func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && filepath.Ext(path) == ".lx" {
+			files = append(files, path)
+		}
+		return err
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, files)
+
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		for _, file := range files {
+			file := file
+			name, _ := filepath.Rel(filepath.Join("testdata", "conformance"), file)
+			t.Run(filepath.ToSlash(name), func(t *testing.T) {
+				source, err := os.ReadFile(file)
+				assert.NoError(t, err)
+				exp := parseExpectations(string(source))
+
+				l := newLox()
+				stdout := &bytes.Buffer{}
+				l.Stdout = stdout
+				l.Stderr = &bytes.Buffer{}
+				_, err = l.RunFile(file)
+
+				var output []string
+				if out := strings.TrimSuffix(stdout.String(), "\n"); out != "" {
+					output = strings.Split(out, "\n")
+				}
+				assert.Equal(t, exp.output, output, "output")
+				assert.Equal(t, exp.errors, errorLines(err), "errors")
+			})
+		}
+	})
+}
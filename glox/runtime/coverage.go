@@ -0,0 +1,183 @@
+package runtime
+
+import (
+	"encoding/json"
+	"glox/ast"
+	"glox/lexer"
+	"glox/parser"
+	"io"
+	"sort"
+)
+
+// Coverage records which statements of the files an interpreter runs
+// are executed, and which way their branches go. The same Coverage
+// can be shared by several interpreters, such as the ones running
+// tests, to add up what they execute.
+type Coverage struct {
+	files map[string]*FileCoverage
+	// The counts of the statements and branches
+	// parsed so far, for the tree walker to update.
+	stmts    map[ast.Stmt]*StatementCoverage
+	branches map[ast.Stmt]*BranchCoverage
+}
+
+// FileCoverage is the coverage of a lox file.
+type FileCoverage struct {
+	Path       string               `json:"path"`
+	Statements []*StatementCoverage `json:"statements"`
+	Branches   []*BranchCoverage    `json:"branches"`
+
+	// Statements and branches by position, as they're registered.
+	stmtAt   map[[2]int]*StatementCoverage
+	branchAt map[[2]int]*BranchCoverage
+}
+
+// StatementCoverage counts the times a statement was executed.
+type StatementCoverage struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Count  int `json:"count"`
+}
+
+// BranchCoverage counts the times each way of an if statement or
+// of a loop was taken: Counts are the times the condition was truthy,
+// running the then branch or the body of the loop, and the times it
+// was falsy, running the else branch or leaving the loop.
+type BranchCoverage struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	// Kind is "if", "while" or "for".
+	Kind   string `json:"kind"`
+	Counts [2]int `json:"counts"`
+}
+
+func NewCoverage() *Coverage {
+	return &Coverage{
+		files:    make(map[string]*FileCoverage),
+		stmts:    make(map[ast.Stmt]*StatementCoverage),
+		branches: make(map[ast.Stmt]*BranchCoverage),
+	}
+}
+
+// add registers the statements of a file as it's parsed. Files can be
+// parsed more than once, by different interpreters, so statements are
+// identified by their position.
+func (c *Coverage) add(path string, ranges map[ast.Stmt]parser.Range) {
+	file, ok := c.files[path]
+	if !ok {
+		file = &FileCoverage{
+			Path:     path,
+			stmtAt:   make(map[[2]int]*StatementCoverage),
+			branchAt: make(map[[2]int]*BranchCoverage),
+		}
+		c.files[path] = file
+	}
+	for stmt, r := range ranges {
+		if !executes(stmt, r) {
+			continue
+		}
+		kind := ""
+		switch s := stmt.(type) {
+		case *ast.Block:
+			if loop, ok := s.Statements[len(s.Statements)-1].(*ast.While); ok {
+				c.branches[loop] = file.branch(r.First, "for")
+			}
+		case *ast.If:
+			kind = "if"
+		case *ast.While:
+			kind = "while"
+		}
+		c.stmts[stmt] = file.statement(r.First)
+		if kind != "" {
+			c.branches[stmt] = file.branch(r.First, kind)
+		}
+	}
+}
+
+func (f *FileCoverage) statement(tok lexer.Token) *StatementCoverage {
+	pos := [2]int{tok.Line, tok.Column}
+	if s, ok := f.stmtAt[pos]; ok {
+		return s
+	}
+	s := &StatementCoverage{Line: tok.Line, Column: tok.Column}
+	f.stmtAt[pos] = s
+	f.Statements = append(f.Statements, s)
+	return s
+}
+
+func (f *FileCoverage) branch(tok lexer.Token, kind string) *BranchCoverage {
+	pos := [2]int{tok.Line, tok.Column}
+	if b, ok := f.branchAt[pos]; ok {
+		return b
+	}
+	b := &BranchCoverage{Line: tok.Line, Column: tok.Column, Kind: kind}
+	f.branchAt[pos] = b
+	f.Branches = append(f.Branches, b)
+	return b
+}
+
+// statement counts an execution of stmt.
+func (c *Coverage) statement(stmt ast.Stmt) {
+	if s, ok := c.stmts[stmt]; ok {
+		s.Count++
+	}
+}
+
+// branch counts the way a branch of stmt was taken.
+func (c *Coverage) branch(stmt ast.Stmt, truthy bool) {
+	if b, ok := c.branches[stmt]; ok {
+		if truthy {
+			b.Counts[0]++
+		} else {
+			b.Counts[1]++
+		}
+	}
+}
+
+// Files returns the coverage of each file run, by path, with
+// their statements and branches in the order of the source.
+func (c *Coverage) Files() []*FileCoverage {
+	files := make([]*FileCoverage, 0, len(c.files))
+	for _, f := range c.files {
+		sort.Slice(f.Statements, func(i, j int) bool {
+			return before(f.Statements[i].Line, f.Statements[i].Column, f.Statements[j].Line, f.Statements[j].Column)
+		})
+		sort.Slice(f.Branches, func(i, j int) bool {
+			return before(f.Branches[i].Line, f.Branches[i].Column, f.Branches[j].Line, f.Branches[j].Column)
+		})
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+func before(line1, col1, line2, col2 int) bool {
+	return line1 < line2 || line1 == line2 && col1 < col2
+}
+
+// WriteJSON writes the coverage of the files run to w, to be read
+// back with ReadCoverage.
+func (c *Coverage) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(c.Files())
+}
+
+// ReadCoverage reads coverage written by Coverage.WriteJSON.
+func ReadCoverage(r io.Reader) ([]*FileCoverage, error) {
+	var files []*FileCoverage
+	if err := json.NewDecoder(r).Decode(&files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// loops reports whether a loop whose condition was just evaluated
+// runs its body again, counting the branch taken.
+func (te *TreeEvaluator) loops(stmt *ast.While) bool {
+	truthy := Truthy(te.result)
+	if te.Coverage != nil {
+		te.Coverage.branch(stmt, truthy)
+	}
+	return truthy
+}
@@ -0,0 +1,91 @@
+package runtime_test
+
+import (
+	"bytes"
+	"glox/runtime"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLox_Coverage(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.lx": `import "lib.lx" as lib;
+var i = 0;
+while (i < 2) {
+	print lib.sign(i);
+	i = i + 1;
+}
+for (var j = 0; j < 0; j = j + 1) print j;
+`,
+		"lib.lx": `fun sign(n) {
+	if (n < 0) return -1;
+	if (n == 0) { return 0; } else { return 1; }
+}
+`,
+	}
+	for name, src := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644))
+	}
+
+	cov := runtime.NewCoverage()
+	// Interpreters sharing a Coverage add up what they execute.
+	for i := 0; i < 2; i++ {
+		l := runtime.NewLoxInterpreter()
+		l.Stdout = &bytes.Buffer{}
+		l.Coverage = cov
+		_, err := l.RunFile(filepath.Join(dir, "main.lx"))
+		require.NoError(t, err)
+	}
+
+	stmt := func(line, col, count int) *runtime.StatementCoverage {
+		return &runtime.StatementCoverage{Line: line, Column: col, Count: count}
+	}
+	branch := func(line, col int, kind string, truthy, falsy int) *runtime.BranchCoverage {
+		return &runtime.BranchCoverage{Line: line, Column: col, Kind: kind, Counts: [2]int{truthy, falsy}}
+	}
+	got := cov.Files()
+	require.Len(t, got, 2)
+	assert.Equal(t, filepath.Join(dir, "lib.lx"), got[0].Path)
+	assert.Equal(t, []*runtime.StatementCoverage{
+		stmt(1, 1, 2),
+		stmt(2, 2, 4),
+		stmt(2, 13, 0),
+		stmt(3, 2, 4),
+		stmt(3, 16, 2),
+		stmt(3, 35, 2),
+	}, got[0].Statements)
+	assert.Equal(t, []*runtime.BranchCoverage{
+		branch(2, 2, "if", 0, 4),
+		branch(3, 2, "if", 2, 2),
+	}, got[0].Branches)
+
+	assert.Equal(t, filepath.Join(dir, "main.lx"), got[1].Path)
+	assert.Equal(t, []*runtime.StatementCoverage{
+		stmt(1, 1, 2),
+		stmt(2, 1, 2),
+		stmt(3, 1, 2),
+		stmt(4, 2, 4),
+		stmt(5, 2, 4),
+		stmt(7, 1, 2),
+		stmt(7, 35, 0),
+	}, got[1].Statements)
+	assert.Equal(t, []*runtime.BranchCoverage{
+		branch(3, 1, "while", 4, 2),
+		branch(7, 1, "for", 0, 2),
+	}, got[1].Branches)
+
+	var buf bytes.Buffer
+	require.NoError(t, cov.WriteJSON(&buf))
+	read, err := runtime.ReadCoverage(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, len(got), len(read))
+	for i := range got {
+		assert.Equal(t, got[i].Statements, read[i].Statements)
+		assert.Equal(t, got[i].Branches, read[i].Branches)
+	}
+}
@@ -0,0 +1,127 @@
+package runtime
+
+import (
+	"glox/ast"
+	"glox/errors"
+	"sort"
+
+	"glox/runtime/variable_resolver"
+)
+
+// A Hook is called by the tree walker before it executes each
+// statement, which is how debuggers pause programs. line is the
+// line the statement starts on, 0 if it isn't known, which is the
+// case for the statements of imported modules and of the statements
+// the parser makes up, like the initializer of a for loop. Returning
+// an error stops the program with it.
+type Hook interface {
+	BeforeStatement(te *TreeEvaluator, stmt ast.Stmt, line int) error
+}
+
+// execute runs a statement, after calling the hook if there's one.
+func (te *TreeEvaluator) execute(stmt ast.Stmt) error {
+	if te.Coverage != nil {
+		te.Coverage.statement(stmt)
+	}
+	if te.Profiler != nil {
+		te.Profiler.statement(stmt)
+	}
+	if te.Tracer != nil {
+		te.Tracer.statement(te, stmt)
+	}
+	if te.Hook != nil {
+		if err := te.Hook.BeforeStatement(te, stmt, te.Lines[stmt]); err != nil {
+			return err
+		}
+	}
+	return stmt.Accept(te)
+}
+
+// evaluate evaluates an expression to te.result, tracing it if
+// there's a tracer.
+func (te *TreeEvaluator) evaluate(expr ast.Expr) error {
+	if err := expr.Accept(te); err != nil {
+		return err
+	}
+	if te.Tracer != nil {
+		te.Tracer.expression(te, expr)
+	}
+	return nil
+}
+
+// Depth returns the number of lox function calls in progress.
+func (te *TreeEvaluator) Depth() int {
+	return len(te.frames)
+}
+
+// Trace returns the lox function calls in progress, outermost first.
+func (te *TreeEvaluator) Trace() []errors.Frame {
+	return te.trace()
+}
+
+// Env returns the environment of the code being executed.
+func (te *TreeEvaluator) Env() *Environment {
+	return te.env
+}
+
+// Scopes returns the environments from the one of the code being
+// executed up to, but not including, the globals.
+func (te *TreeEvaluator) Scopes() []*Environment {
+	var scopes []*Environment
+	for env := te.env; env != nil && env != te.BaseEnv; env = env.parent {
+		scopes = append(scopes, env)
+	}
+	return scopes
+}
+
+// EvaluateInScope evaluates expr as if it was part of the statement
+// about to be executed, with the variables in scope there. The hook
+// isn't called for the statements of the functions expr calls, and
+// none of it is traced.
+func (te *TreeEvaluator) EvaluateInScope(expr ast.Expr) (any, error) {
+	scopes := te.Scopes()
+	names := make([][]string, len(scopes))
+	for i, env := range scopes {
+		names[len(scopes)-1-i] = env.Names()
+	}
+	locals, err := variable_resolver.ResolveExpression(expr, names)
+	if err != nil {
+		return nil, err
+	}
+	for e, dist := range locals {
+		te.Locals[e] = dist
+	}
+
+	hook, tracer, result := te.Hook, te.Tracer, te.result
+	te.Hook, te.Tracer = nil, nil
+	defer func() { te.Hook, te.Tracer, te.result = hook, tracer, result }()
+	if err := expr.Accept(te); err != nil {
+		return nil, err
+	}
+	return te.result, nil
+}
+
+// Names returns the names declared in e itself, in lexical order.
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.data))
+	for name := range e.data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parent returns the environment enclosing e, nil for globals.
+func (e *Environment) Parent() *Environment {
+	return e.parent
+}
+
+// Names returns the names of the fields of inst, in lexical order.
+func (inst *LoxInstance) Names() []string {
+	names := make([]string, 0, len(inst.fields))
+	for name := range inst.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
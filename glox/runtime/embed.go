@@ -0,0 +1,279 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// NotCallableError is returned by Lox.Call when the global
+// named doesn't exist, or isn't a function or class.
+type NotCallableError struct {
+	Name string
+	// The value of the global, if it's defined.
+	Value   any
+	Defined bool
+}
+
+func (e *NotCallableError) Error() string {
+	if !e.Defined {
+		return fmt.Sprintf("undefined global '%s'", e.Name)
+	}
+	return fmt.Sprintf("global '%s' isn't callable: %s", e.Name, repr(e.Value))
+}
+
+// ArityError is returned by Lox.Call when the number of
+// arguments doesn't match the parameters of the function.
+type ArityError struct {
+	Name string
+	Want int
+	Got  int
+}
+
+func (e *ArityError) Error() string {
+	return fmt.Sprintf("'%s' expects %d args, found %d", e.Name, e.Want, e.Got)
+}
+
+// Caller is implemented by backends that create functions
+// which aren't Callables, so that Lox.Call can run them.
+type Caller interface {
+	// Arity returns the number of arguments callee takes,
+	// or false if callee can't be called.
+	Arity(callee any) (int, bool)
+	Call(l *Lox, callee any, args []any) (any, error)
+}
+
+// GetGlobal returns the value of a global variable of the program.
+func (l *Lox) GetGlobal(name string) (any, bool) {
+	return l.Globals.Get(name)
+}
+
+// SetGlobal defines a global variable for programs run afterwards,
+// converting value with ToLox.
+func (l *Lox) SetGlobal(name string, value any) error {
+	val, err := ToLox(value)
+	if err != nil {
+		return err
+	}
+	l.Globals.Declare(name, val)
+	return nil
+}
+
+/*
+Call calls the function or class held by a global variable with
+the given arguments, converted with ToLox, and returns its result.
+
+	l.Run(`fun greet(name) { return "hi " + name; }`)
+	greeting, err := l.Call("greet", "bob")
+
+Errors are returned rather than reported on l.Stderr.
+*/
+func (l *Lox) Call(name string, args ...any) (any, error) {
+	return l.CallContext(context.Background(), name, args...)
+}
+
+// CallContext is Call with the call stopped when ctx
+// is done or it goes over l.Limits, like RunContext.
+func (l *Lox) CallContext(ctx context.Context, name string, args ...any) (any, error) {
+	callee, ok := l.Globals.Get(name)
+	if !ok {
+		return nil, &NotCallableError{Name: name}
+	}
+	loxArgs := make([]any, len(args))
+	for i, arg := range args {
+		val, err := ToLox(arg)
+		if err != nil {
+			return nil, err
+		}
+		loxArgs[i] = val
+	}
+
+	arity, ok := l.arity(callee)
+	if !ok {
+		return nil, &NotCallableError{Name: name, Value: callee, Defined: true}
+	}
+	if arity != len(loxArgs) {
+		return nil, &ArityError{Name: name, Want: arity, Got: len(loxArgs)}
+	}
+	l.meter = NewMeter(ctx, l.Limits)
+	return l.invoke(callee, loxArgs)
+}
+
+// CallValue calls a lox function or class, like one passed as an
+// argument to a native, within the program being run. Its arguments
+// are converted with ToLox.
+func (l *Lox) CallValue(callee any, args ...any) (any, error) {
+	arity, ok := l.arity(callee)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't callable", repr(callee))
+	}
+	if arity != len(args) {
+		return nil, &ArityError{Name: fmt.Sprint(callee), Want: arity, Got: len(args)}
+	}
+	loxArgs := make([]any, len(args))
+	for i, arg := range args {
+		val, err := ToLox(arg)
+		if err != nil {
+			return nil, err
+		}
+		loxArgs[i] = val
+	}
+	return l.invoke(callee, loxArgs)
+}
+
+// arity returns the number of arguments callee
+// takes, or false if callee can't be called.
+func (l *Lox) arity(callee any) (int, bool) {
+	if f, ok := callee.(Callable); ok {
+		return f.Arity(), true
+	}
+	if caller, ok := l.Backend.(Caller); ok {
+		return caller.Arity(callee)
+	}
+	return 0, false
+}
+
+func (l *Lox) invoke(callee any, args []any) (any, error) {
+	if caller, ok := l.Backend.(Caller); ok {
+		return caller.Call(l, callee, args)
+	}
+	return callee.(Callable).Call(l.Evaluator(), args)
+}
+
+// ToLox converts a Go value to the lox value it stands for. Numbers
+// become float64, slices become lists and maps become lox maps, with
+// their elements converted in turn. Pointers, including lox objects
+// like instances, are passed through as they are. Use Bind to expose
+// Go structs and functions to lox code.
+func ToLox(v any) (any, error) {
+	switch v := v.(type) {
+	case nil, bool, float64, string:
+		return v, nil
+	}
+	return toLox(reflect.ValueOf(v), false)
+}
+
+// toLox implements ToLox. When binding, pointers to structs and
+// struct values become proxies, and functions become natives.
+func toLox(rv reflect.Value, bind bool) (any, error) {
+	if !rv.IsValid() {
+		return nil, nil
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Interface:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return toLox(rv.Elem(), bind)
+	case reflect.Ptr:
+		if bind && rv.Type().Elem().Kind() == reflect.Struct && !isLoxValue(rv) {
+			if rv.IsNil() {
+				return nil, nil
+			}
+			return &Proxy{ptr: rv}, nil
+		}
+		return rv.Interface(), nil
+	case reflect.Struct:
+		if bind {
+			if !rv.CanAddr() {
+				ptr := reflect.New(rv.Type())
+				ptr.Elem().Set(rv)
+				rv = ptr.Elem()
+			}
+			return &Proxy{ptr: rv.Addr()}, nil
+		}
+	case reflect.Func:
+		if bind {
+			return newGoFunction("", rv)
+		}
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return NewLoxList(nil), nil
+		}
+		elements := make([]any, rv.Len())
+		for i := range elements {
+			val, err := toLox(rv.Index(i), bind)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = val
+		}
+		return NewLoxList(elements), nil
+	case reflect.Map:
+		type entry struct{ key, value any }
+		entries := make([]entry, 0, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			key, err := toLox(iter.Key(), bind)
+			if err != nil {
+				return nil, err
+			}
+			value, err := toLox(iter.Value(), bind)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry{key, value})
+		}
+		// Go maps are unordered, lox maps keep their keys in order.
+		sort.Slice(entries, func(i, j int) bool {
+			return fmt.Sprint(entries[i].key) < fmt.Sprint(entries[j].key)
+		})
+		m := NewLoxMap()
+		for _, e := range entries {
+			if err := m.SetIndex(e.key, e.value); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("can't convert %s to a lox value", rv.Type())
+}
+
+// FromLox converts a lox value to plain Go values. Lists become []any
+// and maps become map[any]any, with their elements converted in turn.
+// Other values, like numbers, strings and instances, are returned as
+// they are; objects can be inspected through the Object interface.
+// A list or map inside of itself converts to a slice or map inside
+// of itself.
+func FromLox(v any) any {
+	return fromLox(v, make(map[any]any))
+}
+
+// fromLox converts v, reusing the conversions of the
+// containers in converted.
+func fromLox(v any, converted map[any]any) any {
+	switch v := v.(type) {
+	case *LoxList:
+		if c, ok := converted[v]; ok {
+			return c
+		}
+		elements := make([]any, len(v.Elements))
+		converted[v] = elements
+		for i, e := range v.Elements {
+			elements[i] = fromLox(e, converted)
+		}
+		return elements
+	case *LoxMap:
+		if c, ok := converted[v]; ok {
+			return c
+		}
+		m := make(map[any]any, len(v.keys))
+		converted[v] = m
+		for _, k := range v.keys {
+			m[k] = fromLox(v.data[k], converted)
+		}
+		return m
+	}
+	return v
+}
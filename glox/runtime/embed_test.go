@@ -0,0 +1,98 @@
+package runtime_test
+
+import (
+	"glox/runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLox_Call(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		l := newLox()
+		_, err := l.Run(`
+		var calls = 0;
+		fun handler(req) {
+			calls = calls + 1;
+			return {"path": req["path"], "ids": req["ids"], "n": calls};
+		}
+		class Point {
+			init(x, y) { this.x = x; this.y = y; }
+			sum() { return this.x + this.y; }
+		}
+		var p = Point(1, 2);
+		`)
+		assert.NoError(t, err)
+
+		res, err := l.Call("handler", map[string]any{"path": "/", "ids": []int{1, 2}})
+		assert.NoError(t, err)
+		assert.Equal(t, map[any]any{"path": "/", "ids": []any{1., 2.}, "n": 1.}, runtime.FromLox(res))
+
+		calls, ok := l.GetGlobal("calls")
+		assert.True(t, ok)
+		assert.Equal(t, 1., calls)
+
+		pt, err := l.Call("Point", 3, int8(4))
+		assert.NoError(t, err)
+		assert.NoError(t, l.SetGlobal("q", pt))
+		val, err := l.Run("q.sum();")
+		assert.NoError(t, err)
+		assert.Equal(t, 7., val)
+
+		p, _ := l.GetGlobal("p")
+		sum, ok := p.(runtime.Object).Get("sum")
+		assert.True(t, ok)
+		assert.NoError(t, l.SetGlobal("psum", sum))
+		val, err = l.Call("psum")
+		assert.NoError(t, err)
+		assert.Equal(t, 3., val)
+
+		val, err = l.Call("to_string", true)
+		assert.NoError(t, err)
+		assert.Equal(t, "true", val)
+	})
+}
+
+func TestLox_Call_Errors(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		l := newLox()
+		_, err := l.Run(`var x = 1; fun f(a) { return a / 0; } fun g() { throw "no"; }`)
+		assert.NoError(t, err)
+
+		_, err = l.Call("missing")
+		assert.Equal(t, &runtime.NotCallableError{Name: "missing"}, err)
+
+		_, err = l.Call("x")
+		assert.Equal(t, &runtime.NotCallableError{Name: "x", Value: 1., Defined: true}, err)
+
+		_, err = l.Call("f", 1, 2)
+		assert.Equal(t, &runtime.ArityError{Name: "f", Want: 1, Got: 2}, err)
+
+		_, err = l.Call("f", struct{}{})
+		assert.ErrorContains(t, err, "can't convert struct {} to a lox value")
+
+		_, err = l.Call("f", 1)
+		assert.ErrorContains(t, err, "divide by 0")
+		_, err = l.Call("g")
+		assert.ErrorContains(t, err, `uncaught exception: "no"`)
+
+		// The interpreter is still usable after a failed call.
+		val, err := l.Run("x + 1;")
+		assert.NoError(t, err)
+		assert.Equal(t, 2., val)
+	})
+}
+
+func TestToLox(t *testing.T) {
+	val, err := runtime.ToLox(map[string][]uint{"b": {1}, "a": nil})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a": [], "b": [1]}`, val.(*runtime.LoxMap).String())
+
+	type name string
+	val, err = runtime.ToLox(name("n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "n", val)
+
+	_, err = runtime.ToLox(map[[2]int]int{{1, 2}: 3})
+	assert.Error(t, err)
+}
@@ -1,5 +1,10 @@
 package runtime
 
+import (
+	"glox/errors"
+	"glox/lexer"
+)
+
 // BreakError tells a TreeEvaluator to escape out of the
 // innermost loop of an execution.
 type BreakError struct {
@@ -23,3 +28,43 @@ type ReturnError struct {
 func (rv *ReturnError) Error() string {
 	return "return outside function declaration"
 }
+
+// ThrowError carries a value thrown by a `throw` statement
+// up to the nearest enclosing `try`.
+type ThrowError struct {
+	Value any
+	Token lexer.Token
+	// The calls in progress at the throw, outermost first.
+	Trace []errors.Frame
+}
+
+func (e *ThrowError) Error() string {
+	return e.LoxError().Error()
+}
+
+// LoxError describes the throw as the runtime error
+// it becomes when nothing catches it.
+func (e *ThrowError) LoxError() *errors.LoxError {
+	err := e.Token.MakeError("uncaught exception: " + repr(e.Value)).(*errors.LoxError)
+	err.Trace = e.Trace
+	return err
+}
+
+// AttachTrace records the calls in progress on a runtime error,
+// unless it already has them from a deeper call.
+func AttachTrace(err error, trace []errors.Frame) {
+	switch e := err.(type) {
+	case *errors.LoxError:
+		if e.Trace == nil {
+			e.Trace = trace
+		}
+	case *ThrowError:
+		if e.Trace == nil {
+			e.Trace = trace
+		}
+	case *LimitError:
+		if e.Trace == nil {
+			e.Trace = trace
+		}
+	}
+}
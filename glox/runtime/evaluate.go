@@ -3,7 +3,10 @@ package runtime
 import (
 	"fmt"
 	"glox/ast"
+	"glox/errors"
 	"glox/lexer"
+	"io"
+	"os"
 )
 
 type TreeEvaluator struct {
@@ -11,6 +14,32 @@ type TreeEvaluator struct {
 	env     *Environment
 	Locals  map[ast.Expr]int
 	result  any
+
+	// Where `print` statements and natives write to.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Loads the modules of import statements.
+	Importer Importer
+	// Limits the resources the program uses.
+	Meter *Meter
+	// The capabilities granted to the program.
+	Capabilities Capabilities
+	// Called before each statement, along with its line
+	// from Lines, if set. See Hook.
+	Hook  Hook
+	Lines map[ast.Stmt]int
+	// Records the statements executed and branches taken, if set.
+	Coverage *Coverage
+	// Records the time spent in each function and line, if set.
+	Profiler *Profiler
+	// Writes the statements and expressions evaluated, if set.
+	Tracer *Tracer
+
+	// The lox function calls in progress, and the token
+	// of the call expression being evaluated.
+	frames   []errors.Frame
+	callSite lexer.Token
 }
 
 func NewTreeEvaluator(env *Environment, locals map[ast.Expr]int) *TreeEvaluator {
@@ -18,11 +47,13 @@ func NewTreeEvaluator(env *Environment, locals map[ast.Expr]int) *TreeEvaluator
 		BaseEnv: env,
 		Locals:  locals,
 		env:     env,
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
 	}
 }
 
 func (te *TreeEvaluator) Evaluate(expr ast.Stmt) (any, error) {
-	err := expr.Accept(te)
+	err := te.execute(expr)
 	if err != nil {
 		return nil, err
 	}
@@ -62,26 +93,34 @@ func (te *TreeEvaluator) VisitThis(expr *ast.This) error {
 }
 
 func (te *TreeEvaluator) VisitSet(expr *ast.Set) error {
-	if err := expr.Object.Accept(te); err != nil {
+	if err := te.evaluate(expr.Object); err != nil {
 		return err
 	}
-	obj, ok := te.result.(*LoxInstance)
-	if !ok {
+	switch obj := te.result.(type) {
+	case *LoxInstance:
+		if err := te.evaluate(expr.Value); err != nil {
+			return err
+		}
+		obj.Set(expr.Name.Lexeme, te.result)
+	case *Proxy:
+		if err := te.evaluate(expr.Value); err != nil {
+			return err
+		}
+		if err := obj.Set(expr.Name.Lexeme, te.result); err != nil {
+			return expr.Name.MakeError(err.Error())
+		}
+	default:
 		return expr.Name.MakeError("can't assign field to non-instance")
 	}
-	if err := expr.Value.Accept(te); err != nil {
-		return err
-	}
-	obj.Set(expr.Name.Lexeme, te.result)
 	return nil
 }
 
 func (te *TreeEvaluator) VisitGet(expr *ast.Get) error {
-	if err := expr.Object.Accept(te); err != nil {
+	if err := te.evaluate(expr.Object); err != nil {
 		return err
 	}
-	if inst, ok := te.result.(*LoxInstance); ok {
-		val, ok := inst.Get(expr.Name.Lexeme)
+	if obj, ok := te.result.(Object); ok {
+		val, ok := obj.Get(expr.Name.Lexeme)
 		if !ok {
 			return expr.Name.MakeError("undefined field")
 		}
@@ -91,25 +130,147 @@ func (te *TreeEvaluator) VisitGet(expr *ast.Get) error {
 	return expr.Name.MakeError("only instances can have properties")
 }
 
+func (te *TreeEvaluator) VisitList(expr *ast.List) error {
+	elements := make([]any, len(expr.Elements))
+	for i, e := range expr.Elements {
+		if err := te.evaluate(e); err != nil {
+			return err
+		}
+		elements[i] = te.result
+	}
+	if err := te.Meter.Alloc(len(elements)*ValueSize, expr.Bracket); err != nil {
+		return err
+	}
+	te.result = NewLoxList(elements)
+	return nil
+}
+
+func (te *TreeEvaluator) VisitMap(expr *ast.Map) error {
+	if err := te.Meter.Alloc(len(expr.Keys)*2*ValueSize, expr.Brace); err != nil {
+		return err
+	}
+	m := NewLoxMap()
+	for i, k := range expr.Keys {
+		if err := te.evaluate(k); err != nil {
+			return err
+		}
+		key := te.result
+		if err := te.evaluate(expr.Values[i]); err != nil {
+			return err
+		}
+		if err := m.SetIndex(key, te.result); err != nil {
+			return expr.Brace.MakeError(err.Error())
+		}
+	}
+	te.result = m
+	return nil
+}
+
+func (te *TreeEvaluator) VisitIndex(expr *ast.Index) error {
+	if err := te.evaluate(expr.Object); err != nil {
+		return err
+	}
+	obj, ok := te.result.(Indexable)
+	if !ok {
+		return expr.Bracket.MakeError(fmt.Sprintf("type %T can't be indexed", te.result))
+	}
+	if err := te.evaluate(expr.Index); err != nil {
+		return err
+	}
+	val, err := obj.GetIndex(te.result)
+	if err != nil {
+		return expr.Bracket.MakeError(err.Error())
+	}
+	te.result = val
+	return nil
+}
+
+func (te *TreeEvaluator) VisitIndexSet(expr *ast.IndexSet) error {
+	if err := te.evaluate(expr.Object); err != nil {
+		return err
+	}
+	obj, ok := te.result.(Indexable)
+	if !ok {
+		return expr.Bracket.MakeError(fmt.Sprintf("type %T doesn't support index assignment", te.result))
+	}
+	if err := te.evaluate(expr.Index); err != nil {
+		return err
+	}
+	index := te.result
+	if err := te.evaluate(expr.Value); err != nil {
+		return err
+	}
+	if err := te.Meter.AllocEntry(obj, index, expr.Bracket); err != nil {
+		return err
+	}
+	if err := obj.SetIndex(index, te.result); err != nil {
+		return expr.Bracket.MakeError(err.Error())
+	}
+	return nil
+}
+
+func (te *TreeEvaluator) VisitSuper(expr *ast.Super) error {
+	dist, ok := te.Locals[expr]
+	if !ok {
+		return expr.Keyword.MakeError("'super' isn't bound")
+	}
+	val, _ := te.env.GetAt(dist, "super")
+	superclass, ok := val.(*LoxClass)
+	if !ok {
+		return expr.Keyword.MakeError("'super' isn't bound")
+	}
+	// "this" is always bound in the scope directly
+	// inside of the one holding "super".
+	val, _ = te.env.GetAt(dist-1, "this")
+	inst, ok := val.(*LoxInstance)
+	if !ok {
+		return expr.Keyword.MakeError("function isn't bound")
+	}
+	method, ok := superclass.FindMethod(expr.Method.Lexeme)
+	if !ok {
+		return expr.Method.MakeError("undefined superclass method")
+	}
+	te.result = method.Bind(inst)
+	return nil
+}
+
 func (te *TreeEvaluator) VisitClass(stmt *ast.Class) error {
 	name := stmt.Name.Lexeme
+	var superclass *LoxClass
+	if stmt.Superclass != nil {
+		if err := te.evaluate(stmt.Superclass); err != nil {
+			return err
+		}
+		cls, ok := te.result.(*LoxClass)
+		if !ok {
+			return stmt.Superclass.Name.MakeError("superclass must be a class")
+		}
+		superclass = cls
+	}
 	te.env.Declare(name, nil)
+
+	closure := te.env
+	if superclass != nil {
+		closure = closure.EnterScope()
+		closure.Declare("super", superclass)
+	}
 	methods := make(map[string]*LoxFunction)
 	for _, method := range stmt.Methods {
 		f := &LoxFunction{
 			Declaration: method,
-			Closure:     te.env,
+			Closure:     closure,
+			Globals:     te.BaseEnv,
 		}
 		methods[method.Name.Lexeme] = f
 	}
-	cls := &LoxClass{Name: name, Methods: methods}
+	cls := &LoxClass{Name: name, Superclass: superclass, Methods: methods}
 	te.env.Assign(name, cls)
 
 	return nil
 }
 
 func (te *TreeEvaluator) VisitAssignment(exp *ast.Assignment) error {
-	if err := exp.Value.Accept(te); err != nil {
+	if err := te.evaluate(exp.Value); err != nil {
 		return err
 	}
 	dist, ok := te.Locals[exp]
@@ -122,10 +283,10 @@ func (te *TreeEvaluator) VisitAssignment(exp *ast.Assignment) error {
 }
 
 func (te *TreeEvaluator) VisitLogical(exp *ast.Logical) error {
-	if err := exp.Left.Accept(te); err != nil {
+	if err := te.evaluate(exp.Left); err != nil {
 		return err
 	}
-	leftTruthy := truthy(te.result)
+	leftTruthy := Truthy(te.result)
 	switch exp.Operator.Type {
 	case lexer.OR:
 		if leftTruthy {
@@ -139,25 +300,25 @@ func (te *TreeEvaluator) VisitLogical(exp *ast.Logical) error {
 		}
 	}
 
-	return exp.Right.Accept(te)
+	return te.evaluate(exp.Right)
 }
 
 func (te *TreeEvaluator) VisitBinary(exp *ast.Binary) error {
-	if err := exp.Left.Accept(te); err != nil {
+	if err := te.evaluate(exp.Left); err != nil {
 		return err
 	}
 	left := te.result
-	if err := exp.Right.Accept(te); err != nil {
+	if err := te.evaluate(exp.Right); err != nil {
 		return err
 	}
 	right := te.result
 
 	switch exp.Operator.Type {
 	case lexer.DOUBLE_EQUAL:
-		te.result = equality(left, right)
+		te.result = Equality(left, right)
 		return nil
 	case lexer.BANG_EQUAL:
-		te.result = !equality(left, right)
+		te.result = !Equality(left, right)
 		return nil
 	case lexer.LT:
 		if !checkNumeric(left, right) {
@@ -216,6 +377,9 @@ func (te *TreeEvaluator) VisitBinary(exp *ast.Binary) error {
 		}
 		if l, ok := left.(string); ok {
 			if r, ok := right.(string); ok {
+				if err := te.Meter.Alloc(len(l)+len(r), exp.Operator); err != nil {
+					return err
+				}
 				te.result = l + r
 				return nil
 			}
@@ -226,13 +390,13 @@ func (te *TreeEvaluator) VisitBinary(exp *ast.Binary) error {
 	return nil
 }
 func (te *TreeEvaluator) VisitUnary(exp *ast.Unary) error {
-	err := exp.Right.Accept(te)
+	err := te.evaluate(exp.Right)
 	if err != nil {
 		return err
 	}
 	switch exp.Operator.Type {
 	case lexer.BANG:
-		te.result = !truthy(te.result)
+		te.result = !Truthy(te.result)
 	case lexer.MINUS:
 		if v, ok := te.result.(float64); ok {
 			te.result = -v
@@ -244,7 +408,7 @@ func (te *TreeEvaluator) VisitUnary(exp *ast.Unary) error {
 }
 
 func (te *TreeEvaluator) VisitGrouping(exp *ast.Grouping) error {
-	return exp.Expression.Accept(te)
+	return te.evaluate(exp.Expression)
 }
 func (te *TreeEvaluator) VisitLiteral(exp *ast.Literal) error {
 	te.result = exp.Value
@@ -260,22 +424,22 @@ func (te *TreeEvaluator) VisitVariable(exp *ast.Variable) error {
 }
 
 func (te *TreeEvaluator) VisitExpression(stmt *ast.Expression) error {
-	return stmt.Expression.Accept(te)
+	return te.evaluate(stmt.Expression)
 }
 
 func (te *TreeEvaluator) VisitPrint(stmt *ast.Print) error {
-	err := stmt.Expression.Accept(te)
+	err := te.evaluate(stmt.Expression)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("%v\n", te.result)
+	fmt.Fprintf(te.Stdout, "%v\n", te.result)
 	return nil
 }
 
 func (te *TreeEvaluator) VisitVar(stmt *ast.Var) error {
 	var value any
 	if stmt.Initializer != nil {
-		err := stmt.Initializer.Accept(te)
+		err := te.evaluate(stmt.Initializer)
 		if err != nil {
 			return err
 		}
@@ -285,11 +449,23 @@ func (te *TreeEvaluator) VisitVar(stmt *ast.Var) error {
 	return nil
 }
 
+func (te *TreeEvaluator) VisitImport(stmt *ast.Import) error {
+	if te.Importer == nil {
+		return stmt.Keyword.MakeError("imports aren't supported here")
+	}
+	m, err := te.Importer.Import(stmt.Path.Value.(string), stmt.Keyword)
+	if err != nil {
+		return err
+	}
+	te.env.Declare(stmt.Name.Lexeme, m)
+	return nil
+}
+
 func (te *TreeEvaluator) VisitBlock(stmt *ast.Block) error {
 	te.env = te.env.EnterScope()
 	defer func() { te.env = te.env.ExitScope() }()
 	for _, s := range stmt.Statements {
-		if err := s.Accept(te); err != nil {
+		if err := te.execute(s); err != nil {
 			return err
 		}
 	}
@@ -297,31 +473,44 @@ func (te *TreeEvaluator) VisitBlock(stmt *ast.Block) error {
 }
 
 func (te *TreeEvaluator) VisitIf(stmt *ast.If) error {
-	if err := stmt.Condition.Accept(te); err != nil {
+	if err := te.evaluate(stmt.Condition); err != nil {
 		return err
 	}
 
-	if truthy(te.result) {
-		return stmt.ThenBranch.Accept(te)
+	if te.Coverage != nil {
+		te.Coverage.branch(stmt, Truthy(te.result))
+	}
+	if Truthy(te.result) {
+		return te.execute(stmt.ThenBranch)
 	} else if stmt.ElseBranch != nil {
-		return stmt.ElseBranch.Accept(te)
+		return te.execute(stmt.ElseBranch)
 	}
 	return nil
 }
 
 func (te *TreeEvaluator) VisitWhile(stmt *ast.While) error {
-	if err := stmt.Condition.Accept(te); err != nil {
+	if err := te.evaluate(stmt.Condition); err != nil {
 		return err
 	}
-	for truthy(te.result) {
-		if err := stmt.Do.Accept(te); err != nil {
-			switch err.(type) {
-			case *BreakError:
+	for te.loops(stmt) {
+		if err := te.Meter.Step(stmt.Keyword); err != nil {
+			return err
+		}
+		if err := te.execute(stmt.Do); err != nil {
+			brk, ok := err.(*BreakError)
+			if !ok {
+				return err
+			}
+			if !brk.Continue {
 				return nil
 			}
-			return err
 		}
-		if err := stmt.Condition.Accept(te); err != nil {
+		if stmt.Increment != nil {
+			if err := te.evaluate(stmt.Increment); err != nil {
+				return err
+			}
+		}
+		if err := te.evaluate(stmt.Condition); err != nil {
 			return err
 		}
 	}
@@ -333,7 +522,7 @@ func (te *TreeEvaluator) VisitBreak(stmt *ast.Break) error {
 }
 
 func (te *TreeEvaluator) VisitCall(expr *ast.Call) error {
-	if err := expr.Callee.Accept(te); err != nil {
+	if err := te.evaluate(expr.Callee); err != nil {
 		return err
 	}
 	callee := te.result
@@ -346,30 +535,76 @@ func (te *TreeEvaluator) VisitCall(expr *ast.Call) error {
 	}
 	args := make([]any, len(expr.Args))
 	for i, a := range expr.Args {
-		if err := a.Accept(te); err != nil {
+		if err := te.evaluate(a); err != nil {
 			return err
 		}
 		args[i] = te.result
 	}
+	if err := te.Meter.Step(expr.ClosingParen); err != nil {
+		return err
+	}
 	var err error
+	te.callSite = expr.ClosingParen
 	te.result, err = f.Call(te, args)
+	if _, native := f.(*GoCallable); native && err != nil {
+		return NativeError(err, expr.ClosingParen)
+	}
 	return err
 }
 
+// trace returns a copy of the calls in progress.
+func (te *TreeEvaluator) trace() []errors.Frame {
+	return append([]errors.Frame{}, te.frames...)
+}
+
 func (te *TreeEvaluator) VisitFunction(stmt *ast.Function) error {
 	// Capture the current executing environment as a "closure".
 	// This means that variables defined locally to the calling
 	// block will be captured by this function object, allowing us
 	// to exit this function scope without losing access to the
 	// variables.
-	fn := &LoxFunction{Declaration: stmt, Closure: te.env}
+	fn := &LoxFunction{Declaration: stmt, Closure: te.env, Globals: te.BaseEnv}
 	te.env.Declare(stmt.Name.Lexeme, fn)
 	return nil
 }
 
+func (te *TreeEvaluator) VisitLambda(expr *ast.Lambda) error {
+	te.result = &LoxFunction{Declaration: expr.Function, Closure: te.env, Globals: te.BaseEnv}
+	return nil
+}
+
 func (te *TreeEvaluator) VisitReturn(stmt *ast.Return) error {
-	if err := stmt.Expression.Accept(te); err != nil {
+	if stmt.Expression == nil {
+		return &ReturnError{}
+	}
+	if err := te.evaluate(stmt.Expression); err != nil {
 		return err
 	}
 	return &ReturnError{Value: te.result}
 }
+
+func (te *TreeEvaluator) VisitThrow(stmt *ast.Throw) error {
+	if err := te.evaluate(stmt.Expression); err != nil {
+		return err
+	}
+	return Throw(te.result, stmt.Keyword)
+}
+
+func (te *TreeEvaluator) VisitTry(stmt *ast.Try) error {
+	err := te.execute(stmt.Body)
+	if err != nil && stmt.Catch != nil {
+		if val, ok := CaughtValue(err); ok {
+			env := te.env.EnterScope()
+			env.Declare(stmt.CatchName.Lexeme, val)
+			_, err = te.ExecuteStatementsWithEnv(stmt.Catch.Statements, env)
+		}
+	}
+	if stmt.Finally != nil {
+		// A return, break or throw from the finally
+		// block replaces whatever was unwinding.
+		if ferr := te.execute(stmt.Finally); ferr != nil {
+			return ferr
+		}
+	}
+	return err
+}
@@ -144,7 +144,7 @@ func TestTruthy(t *testing.T) {
 
 	for _, _case := range cases {
 		t.Run(fmt.Sprint(_case), func(t *testing.T) {
-			v := truthy(_case.Arg)
+			v := Truthy(_case.Arg)
 			assert.Equal(t, _case.Exp, v)
 		})
 	}
@@ -0,0 +1,51 @@
+package runtime
+
+import (
+	"fmt"
+	"glox/errors"
+	"glox/lexer"
+)
+
+// ErrorObject is the value a `catch` clause receives
+// when it catches a runtime error.
+type ErrorObject struct {
+	Err *errors.LoxError
+}
+
+func (eo *ErrorObject) String() string {
+	return fmt.Sprintf("<error '%s'>", eo.Err.Message)
+}
+
+func (eo *ErrorObject) Get(name string) (any, bool) {
+	switch name {
+	case "message":
+		return eo.Err.Message, true
+	case "line":
+		return float64(eo.Err.LineNumber), true
+	case "column":
+		return float64(eo.Err.Span.Column), true
+	}
+	return nil, false
+}
+
+// CaughtValue converts an error raised while running a program into
+// the value a `catch` clause binds. Control flow signals like
+// ReturnError and BreakError can't be caught.
+func CaughtValue(err error) (any, bool) {
+	switch e := err.(type) {
+	case *ThrowError:
+		return e.Value, true
+	case *errors.LoxError:
+		return &ErrorObject{Err: e}, true
+	}
+	return nil, false
+}
+
+// Throw produces the error that `throw value` unwinds with.
+// Rethrowing a caught runtime error raises the original error.
+func Throw(value any, tok lexer.Token) error {
+	if eo, ok := value.(*ErrorObject); ok {
+		return eo.Err
+	}
+	return &ThrowError{Value: value, Token: tok}
+}
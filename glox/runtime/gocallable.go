@@ -1,15 +1,26 @@
 package runtime
 
+import "fmt"
+
 type GoCallable struct {
 	F func(*TreeEvaluator, []any) (any, error)
 	A int
+	// Name is set for functions bound from Go with Bind.
+	Name string
 }
 
 func (gc *GoCallable) String() string {
+	if gc.Name != "" {
+		return fmt.Sprintf("<built-in fun %s>", gc.Name)
+	}
 	return "<built-in fun>"
 }
 
 func (gc *GoCallable) Call(l *TreeEvaluator, args []any) (any, error) {
+	if l.Profiler != nil {
+		l.Profiler.enterNative(gc)
+		defer l.Profiler.exit()
+	}
 	return gc.F(l, args)
 }
 
@@ -20,3 +31,8 @@ func (gc *GoCallable) Arity() int {
 func NewGoCallable(f func(*TreeEvaluator, []any) (any, error), arity int) Callable {
 	return &GoCallable{F: f, A: arity}
 }
+
+// newMethod returns a native bound to a value, named after the method.
+func newMethod(name string, f func(*TreeEvaluator, []any) (any, error), arity int) Callable {
+	return &GoCallable{F: f, A: arity, Name: name}
+}
@@ -1,6 +1,8 @@
 package runtime
 
-func truthy(val any) bool {
+import "fmt"
+
+func Truthy(val any) bool {
 	switch t := val.(type) {
 	case bool:
 		return t
@@ -15,7 +17,7 @@ func truthy(val any) bool {
 	}
 }
 
-func equality(l, r any) bool {
+func Equality(l, r any) bool {
 	return l == r
 }
 
@@ -27,3 +29,75 @@ func checkNumeric(vals ...any) bool {
 	}
 	return true
 }
+
+// repr formats a value the way it would appear inside of a
+// container, quoting strings so they stand out from other values.
+func repr(val any) string {
+	return reprIn(val, make(map[any]bool))
+}
+
+// reprIn formats a value inside of the containers being printed,
+// which are in printing. A container inside of itself prints as
+// [...] or {...}, rather than forever.
+func reprIn(val any, printing map[any]bool) string {
+	switch v := val.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case *LoxList:
+		return v.format(printing)
+	case *LoxMap:
+		return v.format(printing)
+	}
+	return fmt.Sprintf("%v", val)
+}
+
+// printedLen returns the length of val as it's printed, without
+// printing it. Once the length is found to be over max it stops
+// counting, and returns a length that's over max.
+func printedLen(val any, max int) int {
+	switch val.(type) {
+	case *LoxList, *LoxMap:
+		return reprLen(val, max, make(map[any]bool))
+	}
+	return len(fmt.Sprintf("%v", val))
+}
+
+// reprLen is the length of reprIn(val, printing), counted up to max.
+func reprLen(val any, max int, printing map[any]bool) int {
+	switch v := val.(type) {
+	case *LoxList:
+		if printing[v] {
+			return len("[...]")
+		}
+		printing[v] = true
+		defer delete(printing, v)
+		n := len("[]")
+		for i, e := range v.Elements {
+			if i > 0 {
+				n += len(", ")
+			}
+			if n += reprLen(e, max-n, printing); n > max {
+				break
+			}
+		}
+		return n
+	case *LoxMap:
+		if printing[v] {
+			return len("{...}")
+		}
+		printing[v] = true
+		defer delete(printing, v)
+		n := len("{}")
+		for i, k := range v.keys {
+			if i > 0 {
+				n += len(", ")
+			}
+			n += len(repr(k)) + len(": ")
+			if n += reprLen(v.data[k], max-n, printing); n > max {
+				break
+			}
+		}
+		return n
+	}
+	return len(reprIn(val, printing))
+}
@@ -0,0 +1,165 @@
+package runtime
+
+import (
+	"context"
+	stderrors "errors"
+	"glox/errors"
+	"glox/lexer"
+	"math"
+)
+
+// DefaultMaxDepth is the call depth allowed when Limits.MaxDepth is 0.
+const DefaultMaxDepth = 1024
+
+// ValueSize is the number of bytes Limits.MaxMemory counts
+// for each element of a list, and each key or value of a map.
+const ValueSize = 16
+
+// Limits bound the resources a program can use, so untrusted
+// scripts can be run safely. Zero fields don't limit anything,
+// except MaxDepth which then defaults to DefaultMaxDepth.
+type Limits struct {
+	// MaxSteps is the number of loop iterations
+	// and function calls a program can make.
+	MaxSteps int
+	// MaxDepth is how deeply function calls can nest before
+	// the program fails with a "stack overflow" error.
+	MaxDepth int
+	// MaxMemory is the number of bytes a program can allocate
+	// for the strings, lists and maps it builds.
+	MaxMemory int
+}
+
+var (
+	ErrStepLimit   = stderrors.New("step limit exceeded")
+	ErrMemoryLimit = stderrors.New("memory limit exceeded")
+)
+
+// LimitError stops a program that went over its Limits, or whose
+// context is done. Unlike runtime errors, it can't be caught.
+type LimitError struct {
+	// ErrStepLimit, ErrMemoryLimit or the error of the context.
+	Err   error
+	Token lexer.Token
+	Trace []errors.Frame
+}
+
+func (e *LimitError) Error() string {
+	return e.LoxError().Error()
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// LoxError describes the error as a diagnostic.
+func (e *LimitError) LoxError() *errors.LoxError {
+	err := e.Token.MakeError(e.Err.Error()).(*errors.LoxError)
+	err.Trace = e.Trace
+	return err
+}
+
+// Meter checks the resources used by a running program against
+// its Limits. Backends report steps and allocations to it as the
+// program runs. A nil Meter doesn't limit anything.
+type Meter struct {
+	ctx    context.Context
+	limits Limits
+	steps  int
+	memory int
+}
+
+// NewMeter returns a meter of a program run with ctx and limits.
+func NewMeter(ctx context.Context, limits Limits) *Meter {
+	return &Meter{ctx: ctx, limits: limits}
+}
+
+// Step counts a loop iteration or function call at tok, failing
+// once the program is out of steps or its context is done.
+func (m *Meter) Step(tok lexer.Token) error {
+	if m == nil {
+		return nil
+	}
+	m.steps++
+	if m.limits.MaxSteps > 0 && m.steps > m.limits.MaxSteps {
+		return &LimitError{Err: ErrStepLimit, Token: tok}
+	}
+	select {
+	case <-m.ctx.Done():
+		return &LimitError{Err: m.ctx.Err(), Token: tok}
+	default:
+	}
+	return nil
+}
+
+// Alloc counts n bytes allocated at tok.
+func (m *Meter) Alloc(n int, tok lexer.Token) error {
+	if m == nil {
+		return nil
+	}
+	m.memory += n
+	if m.limits.MaxMemory > 0 && m.memory > m.limits.MaxMemory {
+		return &LimitError{Err: ErrMemoryLimit, Token: tok}
+	}
+	return nil
+}
+
+// available returns the number of bytes that can still be allocated.
+func (m *Meter) available() int {
+	if m == nil || m.limits.MaxMemory == 0 {
+		return math.MaxInt
+	}
+	return m.limits.MaxMemory - m.memory
+}
+
+// AllocEntry counts the entry that assigning to obj[key]
+// adds when obj is a map that doesn't have the key yet.
+func (m *Meter) AllocEntry(obj any, key any, tok lexer.Token) error {
+	if lm, ok := obj.(*LoxMap); ok && m != nil {
+		if has, _ := lm.Has(key); !has {
+			return m.Alloc(2*ValueSize, tok)
+		}
+	}
+	return nil
+}
+
+// MaxDepth returns the call depth allowed.
+func (m *Meter) MaxDepth() int {
+	if m == nil || m.limits.MaxDepth == 0 {
+		return DefaultMaxDepth
+	}
+	return m.limits.MaxDepth
+}
+
+// NativeError attaches the call site tok to an error returned by
+// a native function, which doesn't know where it was called from.
+// Other errors than limits become runtime errors programs can catch,
+// which unwrap to the error of the native, like a PermissionError.
+func NativeError(err error, tok lexer.Token) error {
+	switch e := err.(type) {
+	case *errors.LoxError:
+		return e
+	case *LimitError:
+		if e.Token.Line == 0 {
+			e.Token = tok
+		}
+		return e
+	}
+	le := tok.MakeError(err.Error()).(*errors.LoxError)
+	le.Err = err
+	return le
+}
+
+// alloc counts n bytes allocated by a native function,
+// whose call site is filled in by NativeError.
+func (te *TreeEvaluator) alloc(n int) error {
+	return te.Meter.Alloc(n, lexer.Token{})
+}
+
+// context returns the context of the program being run.
+func (te *TreeEvaluator) context() context.Context {
+	if te.Meter == nil {
+		return context.Background()
+	}
+	return te.Meter.ctx
+}
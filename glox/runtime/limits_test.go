@@ -0,0 +1,116 @@
+package runtime_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"glox/runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLox_RunContext(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		l := newLox()
+		l.Stderr = &bytes.Buffer{}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := l.RunContext(ctx, "while (true) {}")
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+		assert.ErrorContains(t, err, "[line 1] at 'while': context deadline exceeded")
+
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		_, err = l.RunContext(ctx, `
+		fun spin() { while (true) {} }
+		try { spin(); } catch (e) { print "caught"; }`)
+		assert.True(t, errors.Is(err, context.Canceled), err)
+
+		// The interpreter is still usable afterwards.
+		val, err := l.Run("1 + 2;")
+		assert.NoError(t, err)
+		assert.Equal(t, 3., val)
+	})
+}
+
+func TestLox_Limits_Steps(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		l := newLox()
+		l.Stderr = &bytes.Buffer{}
+		l.Limits.MaxSteps = 100
+
+		val, err := l.Run("var n = 0; while (n < 99) n = n + 1; n;")
+		assert.NoError(t, err)
+		assert.Equal(t, 99., val)
+
+		_, err = l.Run("var n = 0; while (n < 101) n = n + 1;")
+		assert.True(t, errors.Is(err, runtime.ErrStepLimit))
+
+		_, err = l.Run(`
+		fun f() { return f(); }
+		try { f(); } catch (e) {}`)
+		assert.True(t, errors.Is(err, runtime.ErrStepLimit))
+		assert.ErrorContains(t, err, "at ')': step limit exceeded")
+
+		_, err = l.Run("var n = 0; while (n < 200) try { n = n + 1; } catch (e) {}")
+		var le *runtime.LimitError
+		assert.ErrorAs(t, err, &le)
+		assert.Equal(t, runtime.ErrStepLimit, le.Err)
+	})
+}
+
+func TestLox_Limits_Depth(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		l := newLox()
+		l.Stderr = &bytes.Buffer{}
+		_, err := l.Run(`fun down(n) { if (n == 0) return 0; return down(n - 1); }`)
+		assert.NoError(t, err)
+
+		_, err = l.Run("down(100000);")
+		assert.ErrorContains(t, err, "stack overflow")
+
+		val, err := l.Run(`
+		var msg;
+		try { down(100000); } catch (e) { msg = e.message; }
+		msg;`)
+		assert.NoError(t, err)
+		assert.Equal(t, "stack overflow", val)
+
+		l.Limits.MaxDepth = 10
+		_, err = l.Run("down(9);")
+		assert.NoError(t, err)
+		_, err = l.Run("down(10);")
+		assert.ErrorContains(t, err, "stack overflow")
+	})
+}
+
+func TestLox_Limits_Memory(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		l := newLox()
+		l.Stderr = &bytes.Buffer{}
+		l.Limits.MaxMemory = 1 << 16
+
+		val, err := l.Run(`var s = "ab"; for (var i = 0; i < 5; i = i + 1) s = s + s; s;`)
+		assert.NoError(t, err)
+		assert.Len(t, val, 64)
+
+		tests := map[string]string{
+			"string": `var s = "ab"; while (true) s = s + s;`,
+			"push":   `var l = []; while (true) l.push(l);`,
+			"map":    `var m = {}; var i = 0; while (true) { m[i] = i; i = i + 1; }`,
+			"slice":  `var l = [1, 2, 3, 4]; while (true) l.slice(0, 4);`,
+			// Each list prints as twice as long as the one before.
+			"to_string": `var l = [1]; while (true) { l = [l, l]; to_string(l); }`,
+		}
+		for name, src := range tests {
+			_, err = l.Run(src)
+			assert.True(t, errors.Is(err, runtime.ErrMemoryLimit), name)
+		}
+
+		_, err = l.Run(`var l = []; try { while (true) l.push(1); } catch (e) {}`)
+		assert.ErrorContains(t, err, "at ')': memory limit exceeded")
+	})
+}
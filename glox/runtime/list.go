@@ -0,0 +1,146 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Indexable is a runtime value whose elements can be read
+// and written with `value[index]` syntax.
+type Indexable interface {
+	GetIndex(index any) (any, error)
+	SetIndex(index any, value any) error
+}
+
+// LoxList is the runtime value produced by a list literal, `[1, 2, 3]`.
+type LoxList struct {
+	Elements []any
+}
+
+func NewLoxList(elements []any) *LoxList {
+	return &LoxList{Elements: elements}
+}
+
+func (l *LoxList) String() string {
+	return l.format(make(map[any]bool))
+}
+
+func (l *LoxList) format(printing map[any]bool) string {
+	if printing[l] {
+		return "[...]"
+	}
+	printing[l] = true
+	defer delete(printing, l)
+	parts := make([]string, len(l.Elements))
+	for i, e := range l.Elements {
+		parts[i] = reprIn(e, printing)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// Index converts a lox value into a position in the list,
+// failing if it isn't a whole number within [0, upper).
+func (l *LoxList) Index(v any, upper int) (int, error) {
+	f, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("list index must be a number, found %T", v)
+	}
+	i := int(f)
+	if float64(i) != f {
+		return 0, fmt.Errorf("list index must be a whole number, found %v", f)
+	}
+	if i < 0 || i >= upper {
+		return 0, fmt.Errorf("list index %d out of range for length %d", i, len(l.Elements))
+	}
+	return i, nil
+}
+
+func (l *LoxList) GetIndex(v any) (any, error) {
+	i, err := l.Index(v, len(l.Elements))
+	if err != nil {
+		return nil, err
+	}
+	return l.Elements[i], nil
+}
+
+func (l *LoxList) SetIndex(v any, value any) error {
+	i, err := l.Index(v, len(l.Elements))
+	if err != nil {
+		return err
+	}
+	l.Elements[i] = value
+	return nil
+}
+
+// Get returns the list method with the given name, bound to this list.
+func (l *LoxList) Get(name string) (any, bool) {
+	switch name {
+	case "len":
+		return newMethod("len", func(*TreeEvaluator, []any) (any, error) {
+			return float64(len(l.Elements)), nil
+		}, 0), true
+	case "push":
+		return newMethod("push", func(te *TreeEvaluator, args []any) (any, error) {
+			if err := te.alloc(ValueSize); err != nil {
+				return nil, err
+			}
+			l.Elements = append(l.Elements, args[0])
+			return nil, nil
+		}, 1), true
+	case "pop":
+		return newMethod("pop", func(*TreeEvaluator, []any) (any, error) {
+			if len(l.Elements) == 0 {
+				return nil, fmt.Errorf("pop from empty list")
+			}
+			last := l.Elements[len(l.Elements)-1]
+			l.Elements = l.Elements[:len(l.Elements)-1]
+			return last, nil
+		}, 0), true
+	case "insert":
+		return newMethod("insert", func(te *TreeEvaluator, args []any) (any, error) {
+			// Inserting at len(list) is the same as a push.
+			i, err := l.Index(args[0], len(l.Elements)+1)
+			if err != nil {
+				return nil, err
+			}
+			if err := te.alloc(ValueSize); err != nil {
+				return nil, err
+			}
+			l.Elements = append(l.Elements, nil)
+			copy(l.Elements[i+1:], l.Elements[i:])
+			l.Elements[i] = args[1]
+			return nil, nil
+		}, 2), true
+	case "remove":
+		return newMethod("remove", func(_ *TreeEvaluator, args []any) (any, error) {
+			i, err := l.Index(args[0], len(l.Elements))
+			if err != nil {
+				return nil, err
+			}
+			removed := l.Elements[i]
+			l.Elements = append(l.Elements[:i], l.Elements[i+1:]...)
+			return removed, nil
+		}, 1), true
+	case "slice":
+		return newMethod("slice", func(te *TreeEvaluator, args []any) (any, error) {
+			start, err := l.Index(args[0], len(l.Elements)+1)
+			if err != nil {
+				return nil, err
+			}
+			end, err := l.Index(args[1], len(l.Elements)+1)
+			if err != nil {
+				return nil, err
+			}
+			if end < start {
+				return nil, fmt.Errorf("slice end %d is before start %d", end, start)
+			}
+			if err := te.alloc((end - start) * ValueSize); err != nil {
+				return nil, err
+			}
+			elements := make([]any, end-start)
+			copy(elements, l.Elements[start:end])
+			return NewLoxList(elements), nil
+		}, 2), true
+	}
+	return nil, false
+}
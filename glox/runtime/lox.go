@@ -1,67 +1,211 @@
 package runtime
 
 import (
-	"fmt"
+	"context"
 	"glox/ast"
 	"glox/errors"
 	"glox/lexer"
 	"glox/parser"
+	"io"
+	"os"
+	"path/filepath"
 
 	"glox/runtime/variable_resolver"
 )
 
+// Backend executes resolved programs on behalf of a Lox interpreter.
+type Backend interface {
+	Execute(l *Lox, stmts []ast.Stmt) (any, error)
+}
+
 type Lox struct {
 	HadError bool
 	Globals  *Environment
 	Locals   map[ast.Expr]int
+
+	// Backend runs programs in place of a TreeEvaluator when set.
+	Backend Backend
+
+	// Stdout receives the output of the program, while Stderr
+	// receives the interpreter's error messages.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Color enables ANSI colors in the diagnostics written to Stderr.
+	Color bool
+
+	// Paths are the directories searched for imported modules
+	// that aren't found next to the importing file.
+	Paths []string
+
+	// Limits bound the resources of each program run.
+	Limits Limits
+	// Capabilities are the natives programs are allowed to use,
+	// none by default. See Grant.
+	Capabilities Capabilities
+	// Meter of the program being run.
+	meter *Meter
+	// Hook is called by the tree walker before each statement
+	// of the programs run, which are then parsed keeping the
+	// lines of their statements in lines.
+	Hook  Hook
+	lines map[ast.Stmt]int
+	// Coverage records the statements and branches the tree
+	// walker executes in the files run, and the modules they
+	// import, when set.
+	Coverage *Coverage
+	// Profiler records where the tree walker spends its time
+	// running lox code, when set.
+	Profiler *Profiler
+	// Tracer writes each statement and expression the
+	// tree walker evaluates, when set.
+	Tracer *Tracer
+
+	// The file being run, if any, and the
+	// modules it and its imports have loaded.
+	file    string
+	modules *modules
+	// Source of the program being run, shown in diagnostics.
+	source string
 }
 
 func NewLoxInterpreter() *Lox {
 	globals := NewEnvironment(nil)
-	DefineNativeFunctions(globals)
+	DefineNatives(globals)
 	return &Lox{
 		Globals:  globals,
 		Locals:   make(map[ast.Expr]int),
 		HadError: false,
+		Stdout:   os.Stdout,
+		Stderr:   os.Stderr,
 	}
 }
 
+// Report writes a diagnostic for err, showing where it occurred
+// in the source of the program being run.
 func (l *Lox) Report(err error) {
-	fmt.Println(err.Error())
+	errors.Render(l.Stderr, l.source, err, l.Color)
 	l.HadError = true
 }
 
+// Evaluator returns a TreeEvaluator over this interpreter's
+// globals that writes to the interpreter's output streams.
+func (l *Lox) Evaluator() *TreeEvaluator {
+	te := NewTreeEvaluator(l.Globals, l.Locals)
+	te.Stdout = l.Stdout
+	te.Stderr = l.Stderr
+	te.Importer = l
+	te.Meter = l.meter
+	te.Capabilities = l.Capabilities
+	te.Hook = l.Hook
+	te.Lines = l.lines
+	te.Coverage = l.Coverage
+	te.Profiler = l.Profiler
+	te.Tracer = l.Tracer
+	return te
+}
+
+// RunFile runs the lox file at path. Modules it imports
+// are looked up relative to the file's directory.
+func (l *Lox) RunFile(path string) (any, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		l.Report(err)
+		return nil, err
+	}
+	if l.file, err = filepath.Abs(path); err != nil {
+		l.Report(err)
+		return nil, err
+	}
+	mods := l.sharedModules()
+	mods.running = append(mods.running, l.file)
+	defer func() { mods.running = mods.running[:len(mods.running)-1] }()
+	return l.Run(string(source))
+}
+
 func (l *Lox) Run(line string) (any, error) {
-	tokens, err := lexer.ScanSource(line)
+	return l.RunContext(context.Background(), line)
+}
+
+// RunContext runs a program like Run, stopping it with a LimitError
+// when ctx is done, or when it goes over l.Limits.
+func (l *Lox) RunContext(ctx context.Context, line string) (any, error) {
+	l.source = line
+	l.meter = NewMeter(ctx, l.Limits)
+	last, err := l.run(line)
 	if err != nil {
-		switch err.(type) {
-		case *lexer.ScanError:
-			se := err.(*lexer.ScanError)
-			l.Report(se)
-			return nil, &errors.LoxError{LineNumber: se.Line, Message: se.Message}
-		default:
-			return nil, err
+		if se, ok := err.(*lexer.ScanError); ok {
+			err = se.LoxError()
 		}
+		l.Report(err)
+		return nil, err
 	}
+	return last, nil
+}
 
-	stmts, err := parser.Parse(tokens)
+// run is Run without reporting errors.
+func (l *Lox) run(line string) (any, error) {
+	tokens, err := lexer.ScanSource(line)
+	if err != nil {
+		return nil, err
+	}
+
+	stmts, err := l.parse(tokens)
 	if err != nil {
-		l.Report(err)
 		return nil, err
 	}
 	locals, err := variable_resolver.ResolveVariables(stmts)
 	if err != nil {
-		l.Report(err)
 		return nil, err
 	}
 	for k, v := range locals {
 		l.Locals[k] = v
 	}
-	te := NewTreeEvaluator(l.Globals, l.Locals)
-	last, err := te.ExecuteStatementsWithEnv(stmts, te.BaseEnv)
+	var last any
+	if l.Backend != nil {
+		last, err = l.Backend.Execute(l, stmts)
+	} else {
+		te := l.Evaluator()
+		last, err = te.ExecuteStatementsWithEnv(stmts, te.BaseEnv)
+	}
 	if err != nil {
-		l.Report(err)
 		return nil, err
 	}
 	return last, nil
 }
+
+// parse parses a program, recording the line of each of its statements
+// when they're wanted by l.Hook, l.Tracer, l.Coverage or l.Profiler.
+func (l *Lox) parse(tokens []lexer.Token) ([]ast.Stmt, error) {
+	if l.Hook == nil && l.Tracer == nil && l.Coverage == nil && l.Profiler == nil {
+		return parser.Parse(tokens)
+	}
+	stmts, ranges, err := parser.ParseRanges(tokens)
+	if err != nil {
+		return stmts, err
+	}
+	if l.Coverage != nil && l.file != "" {
+		l.Coverage.add(l.file, ranges)
+	}
+	if l.Profiler != nil {
+		l.Profiler.add(l.file, ranges)
+	}
+	if l.Hook == nil && l.Tracer == nil {
+		return stmts, nil
+	}
+	if l.lines == nil {
+		l.lines = make(map[ast.Stmt]int)
+	}
+	for stmt, r := range ranges {
+		if executes(stmt, r) {
+			l.lines[stmt] = r.First.Line
+		}
+	}
+	return stmts, nil
+}
+
+// executes reports whether a statement does anything of its own. Blocks
+// don't, unless they're the block a for loop is parsed into.
+func executes(stmt ast.Stmt, r parser.Range) bool {
+	_, block := stmt.(*ast.Block)
+	return !block || r.First.Type == lexer.FOR
+}
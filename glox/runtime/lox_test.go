@@ -1,36 +1,631 @@
-package runtime
+package runtime_test
 
 import (
+	"bytes"
+	"fmt"
+	"glox/errors"
+	"glox/runtime"
+	"glox/vm"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// backends are the ways a Lox interpreter can run a program,
+// every test in this file runs once on each of them.
+var backends = map[string]func() *runtime.Lox{
+	"tree": runtime.NewLoxInterpreter,
+	"vm": func() *runtime.Lox {
+		l := runtime.NewLoxInterpreter()
+		l.Backend = vm.New()
+		return l
+	},
+}
+
+func eachBackend(t *testing.T, test func(t *testing.T, newLox func() *runtime.Lox)) {
+	for name, newLox := range backends {
+		t.Run(name, func(t *testing.T) { test(t, newLox) })
+	}
+}
+
 func TestLox_Locals(t *testing.T) {
-	l := NewLoxInterpreter()
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		l := newLox()
 
-	_, err := l.Run("fun thing(x) { return x + 2 ; }")
-	assert.NoError(t, err)
+		_, err := l.Run("fun thing(x) { return x + 2 ; }")
+		assert.NoError(t, err)
 
-	val, err := l.Run("print thing(3);")
-	assert.NoError(t, err)
-	assert.Equal(t, 5., val)
+		val, err := l.Run("print thing(3);")
+		assert.NoError(t, err)
+		assert.Equal(t, 5., val)
+	})
 }
 
 func TestLox_Resolution(t *testing.T) {
-	prgm := `
-	var a = 3;
-	{
-		var t = 0;
-		fun f(x) { return x + 3; }
-		t = t + f(3);
-		var a = 5;
-		t = t + f(3);
-		print t;
-		t;
-	}
-	`
-	val, err := NewLoxInterpreter().Run(prgm)
-	assert.NoError(t, err)
-	assert.Equal(t, 12., val)
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		prgm := `
+		var a = 3;
+		{
+			var t = 0;
+			fun f(x) { return x + 3; }
+			t = t + f(3);
+			var a = 5;
+			t = t + f(3);
+			print t;
+			t;
+		}
+		`
+		val, err := newLox().Run(prgm)
+		assert.NoError(t, err)
+		assert.Equal(t, 12., val)
+	})
+}
+
+func TestLox_Inheritance(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		prgm := `
+		class A {
+			init(x) { this.x = x; }
+			name() { return "A"; }
+			value() { return this.x; }
+		}
+		class B < A {
+			name() { return "B" + super.name(); }
+		}
+		class C < B {
+			name() { return "C" + super.name(); }
+		}
+		var c = C(4);
+		c.name() + to_string(c.value());
+		`
+		val, err := newLox().Run(prgm)
+		assert.NoError(t, err)
+		assert.Equal(t, "CBA4", val)
+	})
+}
+
+func TestLox_Inheritance_Errors(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		tests := map[string]struct{ prgm, err string }{
+			"inherit from self":     {"class A < A {}", "a class can't inherit from itself"},
+			"super outside class":   {"super.foo();", "use of 'super' outside a class definition"},
+			"super with no parent":  {"class A { f() { return super.f(); } }", "use of 'super' in a class with no superclass"},
+			"superclass not class":  {"var A = 3; class B < A {}", "superclass must be a class"},
+			"undefined super field": {"class A {} class B < A { f() { return super.f(); } } B().f();", "undefined superclass method"},
+		}
+		for name, tt := range tests {
+			t.Run(name, func(t *testing.T) {
+				_, err := newLox().Run(tt.prgm)
+				assert.ErrorContains(t, err, tt.err)
+			})
+		}
+	})
+}
+
+func TestLox_List(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		prgm := `
+		var xs = [1, 2, 3];
+		xs.push(4);
+		xs[0] = xs.pop() + xs[1];
+		xs.insert(1, "a");
+		xs.remove(3);
+		xs.slice(0, xs.len() - 1);
+		`
+		val, err := newLox().Run(prgm)
+		assert.NoError(t, err)
+		assert.Equal(t, `[6, "a"]`, fmt.Sprint(val))
+	})
+}
+
+func TestLox_List_Cycle(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		val, err := newLox().Run(`var xs = [1]; xs.push(xs); [xs, xs];`)
+		assert.NoError(t, err)
+		assert.Equal(t, `[[1, [...]], [1, [...]]]`, fmt.Sprint(val))
+
+		got := runtime.FromLox(val).([]any)[0].([]any)
+		assert.Len(t, got, 2)
+		inner := got[1].([]any)
+		assert.Same(t, &got[0], &inner[0])
+	})
+}
+
+func TestLox_List_Errors(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		tests := map[string]struct{ prgm, err string }{
+			"index out of range":  {"[1, 2][2];", "list index 2 out of range for length 2"},
+			"negative index":      {"[1, 2][-1];", "list index -1 out of range for length 2"},
+			"fractional index":    {"[1, 2][0.5];", "list index must be a whole number, found 0.5"},
+			"set out of range":    {"var xs = []; xs[0] = 1;", "list index 0 out of range for length 0"},
+			"pop from empty list": {"[].pop();", "pop from empty list"},
+			"index non list":      {"3[0];", "type float64 can't be indexed"},
+		}
+		for name, tt := range tests {
+			t.Run(name, func(t *testing.T) {
+				_, err := newLox().Run(tt.prgm)
+				assert.ErrorContains(t, err, tt.err)
+			})
+		}
+	})
+}
+
+func TestLox_List_ErrorLine(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		_, err := newLox().Run("var xs = [1];\nxs[\n3];")
+		assert.Error(t, err)
+		assert.Equal(t, 2, err.(*errors.LoxError).LineNumber)
+	})
+}
+
+func TestLox_Map(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		prgm := `
+		var m = {"a": 1, 2: "two", true: nil};
+		m["b"] = m["a"] + 1;
+		m[nil] = m.has(2) and !m.has("z");
+		m.delete("a");
+		[m.len(), m.keys(), m.values()];
+		`
+		val, err := newLox().Run(prgm)
+		assert.NoError(t, err)
+		assert.Equal(t, `[4, [2, true, "b", <nil>], ["two", <nil>, 2, true]]`, fmt.Sprint(val))
+	})
+}
+
+func TestLox_Map_Cycle(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		val, err := newLox().Run(`var m = {"a": 1}; m["self"] = m; m;`)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"a": 1, "self": {...}}`, fmt.Sprint(val))
+		got := runtime.FromLox(val).(map[any]any)
+		assert.Equal(t, 1., got["self"].(map[any]any)["a"])
+
+		// A list inside of a map inside of the list.
+		val, err = newLox().Run(`var xs = []; xs.push({"xs": xs}); xs;`)
+		assert.NoError(t, err)
+		assert.Equal(t, `[{"xs": [...]}]`, fmt.Sprint(val))
+
+		// A map inside of a list inside of the map.
+		val, err = newLox().Run(`var m = {}; m["ms"] = [m, 1]; m;`)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"ms": [{...}, 1]}`, fmt.Sprint(val))
+		ms := runtime.FromLox(val).(map[any]any)["ms"].([]any)
+		assert.Equal(t, 1., ms[1])
+		assert.Contains(t, ms[0].(map[any]any), "ms")
+	})
+}
+
+func TestLox_Map_Statement(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		val, err := newLox().Run(`{"a": 1}.len();`)
+		assert.NoError(t, err)
+		assert.Equal(t, 1., val)
+	})
+}
+
+func TestLox_Map_Errors(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		tests := map[string]struct{ prgm, err string }{
+			"undefined key":    {`{"a": 1}["b"];`, "undefined key \"b\""},
+			"instance key":     {`class A {} var m = {}; m[A()] = 1;`, "can't be used as a map key"},
+			"function key":     {`fun f() {} var m = {f: 1};`, "can't be used as a map key"},
+			"list key":         {`var m = {}; m.has([]);`, "type *runtime.LoxList can't be used as a map key"},
+			"number vs string": {`{1: 1}["1"];`, "undefined key \"1\""},
+		}
+		for name, tt := range tests {
+			t.Run(name, func(t *testing.T) {
+				_, err := newLox().Run(tt.prgm)
+				assert.ErrorContains(t, err, tt.err)
+			})
+		}
+	})
+}
+
+func TestLox_Continue(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		prgm := `
+		var total = 0;
+		for (var i = 0; i < 10; i = i + 1) {
+			if (i == 3) continue;
+			if (i == 7) break;
+			total = total + i;
+		}
+		var j = 0;
+		while (j < 5) {
+			j = j + 1;
+			if (j == 2) { continue; }
+			total = total + 100;
+		}
+		total;
+		`
+		val, err := newLox().Run(prgm)
+		assert.NoError(t, err)
+		assert.Equal(t, 418., val)
+	})
+}
+
+func TestLox_Break_OutsideLoop(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		tests := map[string]struct{ prgm, err string }{
+			"break":              {"break;", "break outside of a loop"},
+			"continue":           {"{ continue; }", "continue outside of a loop"},
+			"break in function":  {"while (true) { fun f() { break; } }", "break outside of a loop"},
+			"continue in method": {"for (;;) { class A { f() { continue; } } }", "continue outside of a loop"},
+		}
+		for name, tt := range tests {
+			t.Run(name, func(t *testing.T) {
+				_, err := newLox().Run(tt.prgm)
+				assert.ErrorContains(t, err, tt.err)
+			})
+		}
+	})
+}
+
+func TestLox_Closures(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		prgm := `
+		fun counter() {
+			var i = 0;
+			fun inc() {
+				i = i + 1;
+				return i;
+			}
+			return inc;
+		}
+		var a = counter();
+		var b = counter();
+		a();
+		a();
+		var fns = [];
+		for (var j = 0; j < 3; j = j + 1) {
+			var k = j;
+			fun get() { return k; }
+			fns.push(get);
+		}
+		[a(), b(), fns[0](), fns[2]()];
+		`
+		val, err := newLox().Run(prgm)
+		assert.NoError(t, err)
+		assert.Equal(t, "[3, 1, 0, 2]", fmt.Sprint(val))
+	})
+}
+
+func TestLox_ImplicitReturn(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		prgm := `
+		fun f() { 1 + 2; }
+		fun g() { if (true) { "a"; } }
+		fun h() { return; }
+		[f(), g(), h(), (() => 4)()];
+		`
+		val, err := newLox().Run(prgm)
+		assert.NoError(t, err)
+		assert.Equal(t, "[<nil>, <nil>, <nil>, 4]", fmt.Sprint(val))
+	})
+}
+
+func TestLox_Writers(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		var stdout, stderr bytes.Buffer
+		l := newLox()
+		l.Stdout = &stdout
+		l.Stderr = &stderr
+
+		_, err := l.Run(`print "hello"; print [1, "a"]; print 1 + 2;`)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello\n[1, \"a\"]\n3\n", stdout.String())
+		assert.Empty(t, stderr.String())
+
+		stdout.Reset()
+		_, err = l.Run(`print "before"; print 1 / 0;`)
+		assert.Error(t, err)
+		assert.Equal(t, "before\n", stdout.String())
+		assert.Contains(t, stderr.String(), "divide by 0")
+	})
+}
+
+func TestLox_TryCatch(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		prgm := `
+		var caught;
+		try {
+			var x = 1;
+			x / 0;
+		} catch (e) {
+			caught = e;
+		}
+		fun f(n) {
+			if (n == 0) { throw [1, 2]; }
+			return f(n - 1);
+		}
+		var thrown;
+		try { f(5); } catch (e) { thrown = e; }
+		[caught.message, caught.line, thrown[1]];
+		`
+		val, err := newLox().Run(prgm)
+		assert.NoError(t, err)
+		assert.Equal(t, `["divide by 0", 5, 2]`, fmt.Sprint(val))
+	})
+}
+
+func TestLox_TryFinally(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		prgm := `
+		var log = [];
+		fun f(x) {
+			try {
+				try {
+					if (x) { throw "inner"; }
+					log.push("body");
+				} finally {
+					log.push("finally 1");
+				}
+			} catch (e) {
+				log.push(e);
+				return "caught";
+			} finally {
+				log.push("finally 2");
+			}
+			return "done";
+		}
+		log.push(f(true));
+		log.push(f(false));
+		fun g() {
+			try { return 1; } finally { return 2; }
+		}
+		log.push(g());
+		for (var i = 0; i < 3; i = i + 1) {
+			try {
+				if (i == 0) { continue; }
+				if (i == 2) { break; }
+			} finally {
+				log.push(i);
+			}
+		}
+		log;
+		`
+		val, err := newLox().Run(prgm)
+		assert.NoError(t, err)
+		assert.Equal(t,
+			`["finally 1", "inner", "finally 2", "caught", "body", "finally 1", "finally 2", "done", 2, 0, 1, 2]`,
+			fmt.Sprint(val))
+	})
+}
+
+func TestLox_Throw_Rethrow(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		prgm := `
+		var log = [];
+		try {
+			try {
+				nil + 1;
+			} catch (e) {
+				log.push("inner");
+				throw e;
+			} finally {
+				log.push("finally");
+			}
+		} catch (e) {
+			log.push(e.message);
+		}
+		log;
+		`
+		val, err := newLox().Run(prgm)
+		assert.NoError(t, err)
+		assert.Equal(t, `["inner", "finally", "type <nil> doesn't support addition"]`, fmt.Sprint(val))
+	})
+}
+
+func TestLox_Throw_Uncaught(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		l := newLox()
+		_, err := l.Run("fun f() {\n throw \"oops\";\n}\nf();")
+		assert.ErrorContains(t, err, `uncaught exception: "oops"`)
+		assert.ErrorContains(t, err, "2")
+
+		_, err = l.Run("try { 1 / 0; } finally { print 1; }")
+		assert.ErrorContains(t, err, "divide by 0")
+
+		// The interpreter still works after an uncaught throw.
+		val, err := l.Run("try { throw 1; } catch (e) { e + 1; }")
+		assert.NoError(t, err)
+		assert.Equal(t, 2., val)
+	})
+}
+
+func TestLox_Import(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		dir, lib := t.TempDir(), t.TempDir()
+		files := map[string]string{
+			filepath.Join(dir, "main.lx"): `
+			import "shapes/square.lx" as square;
+			import "counter.lx" as c1;
+			import "counter.lx" as c2;
+			c1.count.n = c1.count.n + 1;
+			[square.area(3), c2.count.n, c1.loaded];
+			`,
+			filepath.Join(dir, "shapes", "square.lx"): `
+			import "../mul.lx" as mul;
+			fun area(x) { return mul.mul(x, x); }
+			`,
+			filepath.Join(dir, "mul.lx"): "fun mul(a, b) { return a * b; }",
+			filepath.Join(lib, "counter.lx"): `
+			class Count {}
+			var count = Count();
+			count.n = 0;
+			var loaded = "once";
+			print loaded;
+			`,
+		}
+		for name, src := range files {
+			assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+			assert.NoError(t, os.WriteFile(name, []byte(src), 0o644))
+		}
+
+		var stdout bytes.Buffer
+		l := newLox()
+		l.Stdout = &stdout
+		l.Paths = []string{lib}
+		val, err := l.RunFile(filepath.Join(dir, "main.lx"))
+		assert.NoError(t, err)
+		// Both imports of counter.lx share one module, which only ran once.
+		assert.Equal(t, `[9, 1, "once"]`, fmt.Sprint(val))
+		assert.Equal(t, "once\n", stdout.String())
+
+		l.Stderr = &bytes.Buffer{}
+		_, err = l.Run(`import "counter.lx" as c; c.loaded = "twice";`)
+		assert.ErrorContains(t, err, "can't assign field to non-instance")
+	})
+}
+
+func TestLox_Import_Errors(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		dir := t.TempDir()
+		files := map[string]string{
+			"a.lx":   `import "b.lx" as b;`,
+			"b.lx":   `import "a.lx" as a;`,
+			"bad.lx": "var x = 1;\nx.y;",
+		}
+		for name, src := range files {
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644))
+		}
+		l := newLox()
+		l.Stderr = &bytes.Buffer{}
+
+		_, err := l.RunFile(filepath.Join(dir, "a.lx"))
+		assert.ErrorContains(t, err, "circular import: "+
+			filepath.Join(dir, "a.lx")+" -> "+filepath.Join(dir, "b.lx")+" -> "+filepath.Join(dir, "a.lx"))
+
+		l.Paths = []string{dir}
+		_, err = l.Run(`import "missing.lx" as m;`)
+		assert.ErrorContains(t, err, "can't find module 'missing.lx'")
+
+		_, err = l.Run(`import "bad.lx" as bad;`)
+		assert.ErrorContains(t, err, "error in module 'bad.lx'")
+		assert.ErrorContains(t, err, "line 2")
+
+		val, err := l.Run(`var m; try { import "bad.lx" as bad; } catch (e) { m = e.message; } m;`)
+		assert.NoError(t, err)
+		assert.Contains(t, val, "only instances can have properties")
+	})
+}
+
+func TestLox_Lambda(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		prgm := `
+		fun apply(f, x) { return f(x); }
+		fun adder(n) { return (x) => x + n; }
+		var add = fun (a, b) {
+			return a + b;
+		};
+		var xs = [];
+		fun (x) { xs.push(x); }(1);
+		var none = () => "none";
+		[apply((x) => x * 2, 4), adder(10)(5), add(1, 2), apply(fun (x) { return -x; }, 3), none(), xs[0], (1)];
+		`
+		val, err := newLox().Run(prgm)
+		assert.NoError(t, err)
+		assert.Equal(t, `[8, 15, 3, -3, "none", 1, 1]`, fmt.Sprint(val))
+	})
+}
+
+func TestLox_Lambda_Name(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		var stdout bytes.Buffer
+		l := newLox()
+		l.Stdout = &stdout
+		_, err := l.Run("print fun () {};\n\nprint (a, b) => a;")
+		assert.NoError(t, err)
+		assert.Equal(t, "<fun anonymous@line 1>\n<fun anonymous@line 3>\n", stdout.String())
+
+		l.Stderr = &bytes.Buffer{}
+		_, err = l.Run("var f = (a, a) => a;")
+		assert.Error(t, err)
+	})
+}
+
+func TestLox_Diagnostics(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		var stderr bytes.Buffer
+		l := newLox()
+		l.Stderr = &stderr
+
+		_, err := l.Run("var a = 1;\nvar b = a + 2 + \"c\" + a;")
+		assert.Error(t, err)
+		assert.Equal(t, 15, err.(*errors.LoxError).Span.Column)
+		assert.Contains(t, stderr.String(), ""+
+			" 2 | var b = a + 2 + \"c\" + a;\n"+
+			"   |               ^\n")
+
+		stderr.Reset()
+		_, err = l.Run("var s = 1 @ 2;")
+		assert.Error(t, err)
+		assert.Contains(t, stderr.String(), ""+
+			" 1 | var s = 1 @ 2;\n"+
+			"   |           ^\n")
+
+		val, err := l.Run(`var c; try { nil.x; } catch (e) { c = e.column; } c;`)
+		assert.NoError(t, err)
+		assert.Equal(t, 18., val)
+	})
+}
+
+func TestLox_SyntaxErrors(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		var stdout, stderr bytes.Buffer
+		l := newLox()
+		l.Stdout = &stdout
+		l.Stderr = &stderr
+
+		_, err := l.Run("print 1;\nvar = 2;\nprint (3;\nprint 4;")
+		assert.Len(t, err, 2)
+		assert.Contains(t, stderr.String(), "[line 2] at '=': expect a variable name.")
+		assert.Contains(t, stderr.String(), "[line 3] at ';'")
+		// Nothing runs when the program has syntax errors.
+		assert.Empty(t, stdout.String())
+	})
+}
+
+func TestLox_Trace(t *testing.T) {
+	eachBackend(t, func(t *testing.T, newLox func() *runtime.Lox) {
+		var stderr bytes.Buffer
+		l := newLox()
+		l.Stderr = &stderr
+		prgm := `fun inner(x) {
+			return 1 / x;
+		}
+		class A {
+			init(x) { this.y = inner(x); }
+		}
+		fun outer() {
+			return A(0);
+		}
+		outer();`
+		_, err := l.Run(prgm)
+		assert.Error(t, err)
+		assert.Equal(t, []errors.Frame{
+			{Function: "outer", Line: 10, DeclLine: 7},
+			{Function: "init", Line: 8, DeclLine: 5},
+			{Function: "inner", Line: 5, DeclLine: 1},
+		}, err.(*errors.LoxError).Trace)
+		assert.Contains(t, stderr.String(), ""+
+			"Traceback (most recent call last):\n"+
+			"  line 10, in <script>\n"+
+			"  line 8, in outer (declared on line 7)\n"+
+			"  line 5, in init (declared on line 5)\n"+
+			"  line 2, in inner (declared on line 1)\n"+
+			"[line 2] at '/': divide by 0\n")
+
+		stderr.Reset()
+		_, err = l.Run("fun f() {\n throw \"up\";\n}\nf();")
+		assert.Error(t, err)
+		assert.Contains(t, stderr.String(), "  line 4, in <script>\n  line 2, in f (declared on line 1)\n")
+
+		// Errors outside of functions have no trace.
+		_, err = l.Run("1 / 0;")
+		assert.Empty(t, err.(*errors.LoxError).Trace)
+	})
 }
@@ -3,33 +3,55 @@ package runtime
 import (
 	"fmt"
 	"glox/ast"
+	"glox/errors"
 )
 
 // Functions defined in lox code with "fun" syntax.
 type LoxFunction struct {
 	Declaration *ast.Function
 	Closure     *Environment
+	// Globals of the module the function was declared in.
+	Globals *Environment
 }
 
 func (lf *LoxFunction) Call(te *TreeEvaluator, args []any) (any, error) {
 	// The closure is the environment that the function declaration
 	// was defined in. We set this as the parent scope for this invocation
 	// so we can access variables defined within this closure.
+	if len(te.frames) >= te.Meter.MaxDepth() {
+		return nil, te.callSite.MakeError("stack overflow")
+	}
+	if te.Profiler != nil {
+		te.Profiler.enterFunction(lf)
+		defer te.Profiler.exit()
+	}
 	v := lf.Closure.EnterScope()
+	te.frames = append(te.frames, errors.Frame{
+		Function: lf.Declaration.Name.Lexeme,
+		Line:     te.callSite.Line,
+		DeclLine: lf.Declaration.Name.Line,
+	})
+	defer func() { te.frames = te.frames[:len(te.frames)-1] }()
+	if lf.Globals != nil {
+		prev := te.BaseEnv
+		te.BaseEnv = lf.Globals
+		defer func() { te.BaseEnv = prev }()
+	}
 
 	for i := 0; i < lf.Arity(); i++ {
 		v.Declare(lf.Declaration.Params[i].Lexeme, args[i])
 	}
 
-	if val, err := te.ExecuteStatementsWithEnv(lf.Declaration.Body, v); err != nil {
+	if _, err := te.ExecuteStatementsWithEnv(lf.Declaration.Body, v); err != nil {
 		// return statements produce this error to indicate that a function should stop execution.
 		if r, ok := err.(*ReturnError); ok {
 			return r.Value, nil
 		}
+		AttachTrace(err, te.trace())
 		return nil, err
-	} else {
-		return val, nil
 	}
+	// A function that runs off its end returns nil, whatever its last statement was.
+	return nil, nil
 }
 
 func (lf *LoxFunction) Arity() int {
@@ -42,6 +64,7 @@ func (lf *LoxFunction) Bind(inst *LoxInstance) *LoxFunction {
 	return &LoxFunction{
 		Declaration: lf.Declaration,
 		Closure:     env,
+		Globals:     lf.Globals,
 	}
 }
 
@@ -0,0 +1,130 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LoxMap is the runtime value produced by a map literal, `{"a": 1}`.
+// Keys are kept in insertion order so that printing and iterating
+// over a map is deterministic.
+type LoxMap struct {
+	keys []any
+	data map[any]any
+}
+
+func NewLoxMap() *LoxMap {
+	return &LoxMap{data: make(map[any]any)}
+}
+
+func (m *LoxMap) String() string {
+	return m.format(make(map[any]bool))
+}
+
+func (m *LoxMap) format(printing map[any]bool) string {
+	if printing[m] {
+		return "{...}"
+	}
+	printing[m] = true
+	defer delete(printing, m)
+	parts := make([]string, len(m.keys))
+	for i, k := range m.keys {
+		parts[i] = fmt.Sprintf("%s: %s", repr(k), reprIn(m.data[k], printing))
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// checkKey reports an error for values that can't be used as map keys.
+// Only values compared by `==` the same way the equality helper does
+// are allowed, so that lookups agree with lox equality.
+func checkKey(key any) error {
+	switch key.(type) {
+	case nil, bool, float64, string:
+		return nil
+	}
+	return fmt.Errorf("type %T can't be used as a map key", key)
+}
+
+func (m *LoxMap) GetIndex(key any) (any, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	val, ok := m.data[key]
+	if !ok {
+		return nil, fmt.Errorf("undefined key %s", repr(key))
+	}
+	return val, nil
+}
+
+func (m *LoxMap) SetIndex(key any, value any) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if _, ok := m.data[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.data[key] = value
+	return nil
+}
+
+func (m *LoxMap) Has(key any) (bool, error) {
+	if err := checkKey(key); err != nil {
+		return false, err
+	}
+	_, ok := m.data[key]
+	return ok, nil
+}
+
+// Delete removes a key from the map, reporting whether it was present.
+func (m *LoxMap) Delete(key any) (bool, error) {
+	if ok, err := m.Has(key); !ok || err != nil {
+		return false, err
+	}
+	delete(m.data, key)
+	for i, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			break
+		}
+	}
+	return true, nil
+}
+
+// Get returns the map method with the given name, bound to this map.
+func (m *LoxMap) Get(name string) (any, bool) {
+	switch name {
+	case "len":
+		return newMethod("len", func(*TreeEvaluator, []any) (any, error) {
+			return float64(len(m.keys)), nil
+		}, 0), true
+	case "has":
+		return newMethod("has", func(_ *TreeEvaluator, args []any) (any, error) {
+			return m.Has(args[0])
+		}, 1), true
+	case "delete":
+		return newMethod("delete", func(_ *TreeEvaluator, args []any) (any, error) {
+			return m.Delete(args[0])
+		}, 1), true
+	case "keys":
+		return newMethod("keys", func(te *TreeEvaluator, _ []any) (any, error) {
+			if err := te.alloc(len(m.keys) * ValueSize); err != nil {
+				return nil, err
+			}
+			keys := make([]any, len(m.keys))
+			copy(keys, m.keys)
+			return NewLoxList(keys), nil
+		}, 0), true
+	case "values":
+		return newMethod("values", func(te *TreeEvaluator, _ []any) (any, error) {
+			if err := te.alloc(len(m.keys) * ValueSize); err != nil {
+				return nil, err
+			}
+			values := make([]any, len(m.keys))
+			for i, k := range m.keys {
+				values[i] = m.data[k]
+			}
+			return NewLoxList(values), nil
+		}, 0), true
+	}
+	return nil, false
+}
@@ -0,0 +1,159 @@
+package runtime
+
+import (
+	"fmt"
+	"glox/lexer"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Module is the value an import statement binds. Its
+// properties are the globals declared by the imported file.
+type Module struct {
+	Name    string
+	Path    string
+	Globals *Environment
+}
+
+func (m *Module) String() string {
+	return fmt.Sprintf("<module '%s'>", m.Name)
+}
+
+// Get implements Object. Natives are visible to the code in the
+// module, but aren't properties of the module themselves.
+func (m *Module) Get(name string) (any, bool) {
+	val, ok := m.Globals.data[name]
+	return val, ok
+}
+
+// Importer loads the modules named by import statements.
+type Importer interface {
+	Import(path string, tok lexer.Token) (*Module, error)
+}
+
+// modules are shared by an interpreter and the
+// interpreters it creates to run imported files.
+type modules struct {
+	// Modules by absolute path.
+	loaded map[string]*Module
+	// Absolute paths of the files being run, outermost first.
+	running []string
+}
+
+func (l *Lox) sharedModules() *modules {
+	if l.modules == nil {
+		l.modules = &modules{loaded: make(map[string]*Module)}
+	}
+	return l.modules
+}
+
+// Import runs the lox file at path and returns a module of its globals.
+// Each file only runs once per interpreter, importing it again returns
+// the same module. Relative paths are looked up next to the importing
+// file first, then in each of l.Paths. Unless it's granted CapFS, a
+// program can only import lox files under its own directory and l.Paths.
+func (l *Lox) Import(path string, tok lexer.Token) (*Module, error) {
+	file, err := l.findModule(path)
+	if err != nil {
+		return nil, tok.MakeError(err.Error())
+	}
+	mods := l.sharedModules()
+	if !l.Capabilities[CapFS] && !l.importable(file) {
+		return nil, tok.MakeError(fmt.Sprintf("permission denied: importing '%s' needs the '%s' capability", path, CapFS))
+	}
+	if m, ok := mods.loaded[file]; ok {
+		return m, nil
+	}
+	for i, running := range mods.running {
+		if running == file {
+			chain := append(append([]string{}, mods.running[i:]...), file)
+			return nil, tok.MakeError("circular import: " + strings.Join(chain, " -> "))
+		}
+	}
+	source, err := os.ReadFile(file)
+	if err != nil {
+		return nil, tok.MakeError(err.Error())
+	}
+
+	sub := NewLoxInterpreter()
+	sub.Globals = sub.Globals.EnterScope()
+	// Functions from the module run on the importer's
+	// evaluator, so they need its variable resolutions.
+	sub.Locals = l.Locals
+	sub.Backend = l.Backend
+	sub.Stdout = l.Stdout
+	sub.Stderr = l.Stderr
+	sub.Paths = l.Paths
+	sub.file = file
+	sub.modules = mods
+	sub.meter = l.meter
+	sub.Capabilities = l.Capabilities
+	sub.Coverage = l.Coverage
+	sub.Profiler = l.Profiler
+	sub.Tracer = l.Tracer
+
+	mods.running = append(mods.running, file)
+	_, err = sub.run(string(source))
+	mods.running = mods.running[:len(mods.running)-1]
+	if le, ok := err.(*LimitError); ok {
+		return nil, le
+	}
+	if err != nil {
+		return nil, tok.MakeError(fmt.Sprintf("error in module '%s': %s", path, strings.TrimSpace(err.Error())))
+	}
+	m := &Module{Name: path, Path: file, Globals: sub.Globals}
+	mods.loaded[file] = m
+	return m, nil
+}
+
+// importable reports whether file is a lox file under the directory of
+// the program being run, the working directory if it isn't a file, or
+// under one of l.Paths. Symbolic links are followed, so they can't
+// point out of them.
+func (l *Lox) importable(file string) bool {
+	if filepath.Ext(file) != ".lx" {
+		return false
+	}
+	file, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return false
+	}
+	dirs := []string{"."}
+	if mods := l.sharedModules(); len(mods.running) > 0 {
+		dirs[0] = filepath.Dir(mods.running[0])
+	}
+	for _, dir := range append(dirs, l.Paths...) {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		if dir, err = filepath.EvalSymlinks(dir); err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// findModule returns the absolute path of the file an import of path refers to.
+func (l *Lox) findModule(path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return path, nil
+	}
+	dirs := []string{"."}
+	if l.file != "" {
+		dirs[0] = filepath.Dir(l.file)
+	}
+	dirs = append(dirs, l.Paths...)
+	for _, dir := range dirs {
+		candidate := filepath.Join(dir, path)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return filepath.Abs(candidate)
+		}
+	}
+	return "", fmt.Errorf("can't find module '%s'", path)
+}
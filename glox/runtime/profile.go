@@ -0,0 +1,319 @@
+package runtime
+
+import (
+	"glox/ast"
+	"glox/parser"
+	"sort"
+	"time"
+)
+
+// Profiler records where the tree walker spends its time running lox
+// code: how many times each function is called and for how long, and
+// the time spent on each line. The time between two events, a statement
+// starting or a call entering or returning, is charged to what was
+// running when the first one happened, so nothing is left out.
+type Profiler struct {
+	clock       func() time.Time
+	start, last time.Time
+	funcs       map[any]*FuncProfile
+	lines       map[lineKey]*LineProfile
+	// Where the statements parsed so far are.
+	stmts map[ast.Stmt]lineKey
+
+	// The calls in progress form a tree of nodes, one for each
+	// function and line of a different call stack. cur is the
+	// node of what's running, and calls the nodes of its callers
+	// along with the lines they're on.
+	root  *node
+	cur   *node
+	line  *LineProfile
+	calls []caller
+}
+
+// FuncProfile is the profile of a lox function or a native.
+type FuncProfile struct {
+	Name string
+	// Native is set for functions written in Go, which
+	// aren't declared in a file.
+	Native bool
+	// The file and line the function is declared on. The file is that
+	// of its statements, or for functions that run none of their own,
+	// like arrow functions, that of the line first calling them.
+	File string
+	Line int
+
+	Calls int
+	// Inclusive is the time spent in the function and the functions
+	// it calls, while Exclusive leaves out the functions it calls.
+	Inclusive, Exclusive time.Duration
+
+	// The calls of the function in progress, and when the
+	// outermost of them started.
+	active int
+	since  time.Time
+}
+
+// LineProfile is the profile of a line of lox code.
+type LineProfile struct {
+	File string
+	Line int
+	// Time spent running the statements of the line, leaving out the
+	// lox functions they call, but not natives.
+	Time time.Duration
+	// Statements is the number of statements of the line executed.
+	Statements int
+}
+
+// ProfileSample is the time spent in a call stack.
+type ProfileSample struct {
+	// Stack is the function and line of each call in
+	// progress, innermost first. Lines are 0 for natives.
+	Stack []ProfileFrame
+	Time  time.Duration
+	// Statements is the number of statements executed
+	// on the line of the innermost call.
+	Statements int
+}
+
+// ProfileFrame is a call in a ProfileSample.
+type ProfileFrame struct {
+	Func *FuncProfile
+	Line int
+}
+
+type lineKey struct {
+	file string
+	line int
+}
+
+type node struct {
+	ProfileFrame
+	parent   *node
+	children map[ProfileFrame]*node
+	time     time.Duration
+	stmts    int
+}
+
+type caller struct {
+	node *node
+	line *LineProfile
+}
+
+// NewProfiler returns a profiler that starts timing the top level code
+// of the programs it's given to, until Stop is called. It reads the time
+// from clock, or from time.Now if clock is nil.
+func NewProfiler(clock func() time.Time) *Profiler {
+	if clock == nil {
+		clock = time.Now
+	}
+	p := &Profiler{
+		clock: clock,
+		funcs: make(map[any]*FuncProfile),
+		lines: make(map[lineKey]*LineProfile),
+		stmts: make(map[ast.Stmt]lineKey),
+		root:  &node{},
+	}
+	p.cur = p.root
+	p.enter("<script>", &FuncProfile{Name: "<script>"})
+	p.start = p.last
+	return p
+}
+
+// Stop stops timing, which ends the top level code.
+func (p *Profiler) Stop() {
+	if len(p.calls) > 0 {
+		p.exit()
+	}
+}
+
+// Duration returns the time profiled.
+func (p *Profiler) Duration() time.Duration {
+	return p.last.Sub(p.start)
+}
+
+// add registers the lines of the statements of a file as it's parsed.
+func (p *Profiler) add(path string, ranges map[ast.Stmt]parser.Range) {
+	for stmt, r := range ranges {
+		if executes(stmt, r) {
+			p.stmts[stmt] = lineKey{path, r.First.Line}
+		}
+	}
+}
+
+// tick charges the time since the last event to what's running.
+func (p *Profiler) tick() {
+	now := p.clock()
+	d := now.Sub(p.last)
+	p.last = now
+	if p.cur == p.root {
+		return
+	}
+	p.cur.time += d
+	p.cur.Func.Exclusive += d
+	if p.line != nil {
+		p.line.Time += d
+	}
+}
+
+// statement is called before a statement runs.
+func (p *Profiler) statement(stmt ast.Stmt) {
+	p.tick()
+	key, ok := p.stmts[stmt]
+	if !ok {
+		return
+	}
+	fn := p.cur.Func
+	if !fn.Native {
+		fn.File = key.file
+	}
+	p.cur = p.cur.parent.child(ProfileFrame{Func: fn, Line: key.line})
+	p.cur.stmts++
+	p.line, ok = p.lines[key]
+	if !ok {
+		p.line = &LineProfile{File: key.file, Line: key.line}
+		p.lines[key] = p.line
+	}
+	p.line.Statements++
+}
+
+// enterFunction is called when a lox function is called.
+func (p *Profiler) enterFunction(lf *LoxFunction) {
+	decl := lf.Declaration
+	p.enter(decl, &FuncProfile{Name: decl.Name.Lexeme, Line: decl.Name.Line})
+}
+
+// enterNative is called when a native is called.
+func (p *Profiler) enterNative(gc *GoCallable) {
+	name := gc.Name
+	if name == "" {
+		name = "<built-in fun>"
+	}
+	p.enter(name, &FuncProfile{Name: name, Native: true})
+}
+
+// enter starts a call of the function with key, using fn
+// as its profile if it's the first call.
+func (p *Profiler) enter(key any, fn *FuncProfile) {
+	p.tick()
+	if prev, ok := p.funcs[key]; ok {
+		fn = prev
+	} else {
+		p.funcs[key] = fn
+	}
+	if fn.File == "" && !fn.Native && p.line != nil {
+		fn.File = p.line.File
+	}
+	fn.Calls++
+	if fn.active == 0 {
+		fn.since = p.last
+	}
+	fn.active++
+	p.calls = append(p.calls, caller{p.cur, p.line})
+	// Until its first statement, a call is on the line it's declared on.
+	p.cur = p.cur.child(ProfileFrame{Func: fn, Line: fn.Line})
+	if !fn.Native {
+		// Natives run on the line of their caller.
+		p.line = nil
+	}
+}
+
+// exit ends the innermost call in progress.
+func (p *Profiler) exit() {
+	p.tick()
+	fn := p.cur.Func
+	fn.active--
+	if fn.active == 0 {
+		fn.Inclusive += p.last.Sub(fn.since)
+	}
+	c := p.calls[len(p.calls)-1]
+	p.calls = p.calls[:len(p.calls)-1]
+	p.cur, p.line = c.node, c.line
+}
+
+func (n *node) child(f ProfileFrame) *node {
+	if c, ok := n.children[f]; ok {
+		return c
+	}
+	if n.children == nil {
+		n.children = make(map[ProfileFrame]*node)
+	}
+	c := &node{ProfileFrame: f, parent: n}
+	n.children[f] = c
+	return c
+}
+
+// Functions returns the profiles of the functions called,
+// and of the top level code, the longest running first.
+func (p *Profiler) Functions() []*FuncProfile {
+	funcs := make([]*FuncProfile, 0, len(p.funcs))
+	for _, fn := range p.funcs {
+		funcs = append(funcs, fn)
+	}
+	sort.Slice(funcs, func(i, j int) bool {
+		a, b := funcs[i], funcs[j]
+		if a.Exclusive != b.Exclusive {
+			return a.Exclusive > b.Exclusive
+		}
+		return a.Name < b.Name
+	})
+	return funcs
+}
+
+// Lines returns the profiles of the lines run, the longest running first.
+func (p *Profiler) Lines() []*LineProfile {
+	lines := make([]*LineProfile, 0, len(p.lines))
+	for _, l := range p.lines {
+		lines = append(lines, l)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		a, b := lines[i], lines[j]
+		if a.Time != b.Time {
+			return a.Time > b.Time
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return lines
+}
+
+// Samples returns the time spent in each call stack.
+func (p *Profiler) Samples() []ProfileSample {
+	var samples []ProfileSample
+	var walk func(n *node)
+	walk = func(n *node) {
+		if n.time > 0 || n.stmts > 0 {
+			s := ProfileSample{Time: n.time, Statements: n.stmts}
+			for f := n; f != p.root; f = f.parent {
+				s.Stack = append(s.Stack, f.ProfileFrame)
+			}
+			samples = append(samples, s)
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(p.root)
+	sort.Slice(samples, func(i, j int) bool {
+		return stackBefore(samples[i].Stack, samples[j].Stack)
+	})
+	return samples
+}
+
+// stackBefore orders stacks by their frames, outermost first.
+func stackBefore(a, b []ProfileFrame) bool {
+	for i, j := len(a)-1, len(b)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		fa, fb := a[i], b[j]
+		if fa.Func.Name != fb.Func.Name {
+			return fa.Func.Name < fb.Func.Name
+		}
+		if fa.Func.Line != fb.Func.Line {
+			return fa.Func.Line < fb.Func.Line
+		}
+		if fa.Line != fb.Line {
+			return fa.Line < fb.Line
+		}
+	}
+	return len(a) < len(b)
+}
@@ -0,0 +1,119 @@
+package runtime_test
+
+import (
+	"bytes"
+	"fmt"
+	"glox/runtime"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLox_Profiler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.lx")
+	src := `fun twice(n) {
+	return n * 2;
+}
+var xs = [];
+for (var i = 0; i < 2; i = i + 1) xs.push(twice(i));
+`
+	require.NoError(t, os.WriteFile(path, []byte(src), 0o644))
+
+	// Every reading of the clock is a millisecond later.
+	now := time.Unix(0, 0)
+	prof := runtime.NewProfiler(func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now
+	})
+	l := runtime.NewLoxInterpreter()
+	l.Stdout = &bytes.Buffer{}
+	l.Profiler = prof
+	_, err := l.RunFile(path)
+	require.NoError(t, err)
+	prof.Stop()
+	assert.Equal(t, 18*time.Millisecond, prof.Duration())
+
+	type fn struct {
+		name                 string
+		line, calls          int
+		inclusive, exclusive time.Duration
+	}
+	var funcs []fn
+	for _, f := range prof.Functions() {
+		funcs = append(funcs, fn{f.Name, f.Line, f.Calls, f.Inclusive / time.Millisecond, f.Exclusive / time.Millisecond})
+	}
+	assert.Equal(t, []fn{
+		{"<script>", 0, 1, 18, 12},
+		{"twice", 1, 2, 4, 4},
+		{"push", 0, 2, 2, 2},
+	}, funcs)
+
+	// The time of the natives called on a line is part of it.
+	line := func(n int, d time.Duration, stmts int) *runtime.LineProfile {
+		return &runtime.LineProfile{File: path, Line: n, Time: d * time.Millisecond, Statements: stmts}
+	}
+	assert.Equal(t, []*runtime.LineProfile{
+		line(5, 11, 3),
+		line(2, 2, 2),
+		line(1, 1, 1),
+		line(4, 1, 1),
+	}, prof.Lines())
+
+	var stacks []string
+	for _, s := range prof.Samples() {
+		stack := ""
+		for _, f := range s.Stack {
+			stack += fmt.Sprintf("%s:%d ", f.Func.Name, f.Line)
+		}
+		stacks = append(stacks, fmt.Sprintf("%s%v %d", stack, s.Time, s.Statements))
+	}
+	assert.Equal(t, []string{
+		"<script>:0 1ms 0",
+		"<script>:1 1ms 1",
+		"<script>:4 1ms 1",
+		"<script>:5 9ms 3",
+		"push:0 <script>:5 2ms 0",
+		"twice:1 <script>:5 2ms 0",
+		"twice:2 <script>:5 2ms 2",
+	}, stacks)
+}
+
+func TestLox_Profiler_ArrowFunctions(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.lx": `import "lib.lx" as lib;
+var half = (n) => n / 2;
+print half(lib.twice(1));
+`,
+		"lib.lx": `fun twice(n) {
+	return n * 2;
+}
+`,
+	}
+	for name, src := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644))
+	}
+	prof := runtime.NewProfiler(nil)
+	l := runtime.NewLoxInterpreter()
+	l.Stdout = &bytes.Buffer{}
+	l.Profiler = prof
+	_, err := l.RunFile(filepath.Join(dir, "main.lx"))
+	require.NoError(t, err)
+	prof.Stop()
+
+	declared := make(map[string]string)
+	for _, f := range prof.Functions() {
+		declared[f.Name] = fmt.Sprintf("%s:%d", filepath.Base(f.File), f.Line)
+	}
+	// Arrow functions run no statements of their own, and are
+	// taken to be in the file of their caller.
+	assert.Equal(t, map[string]string{
+		"<script>":         "main.lx:0",
+		"anonymous@line 2": "main.lx:2",
+		"twice":            "lib.lx:1",
+	}, declared)
+}
@@ -0,0 +1,134 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"glox/ast"
+	"glox/lexer"
+	"io"
+	"math"
+	"strings"
+)
+
+// Tracer writes a line of JSON to a writer for each statement the tree
+// walker executes, before it does, and for each expression it evaluates,
+// once it has its value, so that runs can be diffed and read by tools.
+// An expression is written after the ones it's made of.
+type Tracer struct {
+	enc *json.Encoder
+	err error
+}
+
+// TraceEntry is a line of a trace.
+type TraceEntry struct {
+	// Kind is "stmt" or "expr".
+	Kind string `json:"kind"`
+	// Node is the type of the node, like "If" or "Binary".
+	Node string `json:"node"`
+	// Line is the line of the node, 0 if it isn't known, which is the
+	// case for literals and groupings, and can be for the statements
+	// of imported modules, as for hooks.
+	Line int `json:"line,omitempty"`
+	// Calls is the number of lox function calls in progress.
+	Calls int `json:"calls"`
+	// Name is the variable, property or method an expression refers to.
+	Name string `json:"name,omitempty"`
+	// Scope is the number of scopes up from the one of the expression
+	// a variable, this or super was resolved to, as found in Locals.
+	// It's left out for globals, which are looked up by name.
+	Scope *int `json:"scope,omitempty"`
+	// Value is the value of an expression. Values other than nil,
+	// booleans, numbers and strings are written as they're printed.
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// NewTracer returns a tracer writing to w.
+func NewTracer(w io.Writer) *Tracer {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &Tracer{enc: enc}
+}
+
+// Err returns the first error writing the trace, after
+// which the tracer stops writing.
+func (t *Tracer) Err() error {
+	return t.err
+}
+
+func (t *Tracer) write(e *TraceEntry) {
+	if t.err == nil {
+		t.err = t.enc.Encode(e)
+	}
+}
+
+// statement is called before a statement runs.
+func (t *Tracer) statement(te *TreeEvaluator, stmt ast.Stmt) {
+	t.write(&TraceEntry{Kind: "stmt", Node: nodeName(stmt), Line: te.Lines[stmt], Calls: te.Depth()})
+}
+
+// expression is called once an expression is evaluated to te.result.
+func (t *Tracer) expression(te *TreeEvaluator, expr ast.Expr) {
+	e := &TraceEntry{Kind: "expr", Node: nodeName(expr), Calls: te.Depth(), Value: traceValue(te.result)}
+	var tok lexer.Token
+	switch expr := expr.(type) {
+	case *ast.Variable:
+		tok, e.Name = expr.Name, expr.Name.Lexeme
+	case *ast.Assignment:
+		tok, e.Name = expr.Name, expr.Name.Lexeme
+	case *ast.Get:
+		tok, e.Name = expr.Name, expr.Name.Lexeme
+	case *ast.Set:
+		tok, e.Name = expr.Name, expr.Name.Lexeme
+	case *ast.This:
+		tok, e.Name = expr.Keyword, expr.Keyword.Lexeme
+	case *ast.Super:
+		tok, e.Name = expr.Method, expr.Method.Lexeme
+	case *ast.Binary:
+		tok = expr.Operator
+	case *ast.Logical:
+		tok = expr.Operator
+	case *ast.Unary:
+		tok = expr.Operator
+	case *ast.Call:
+		tok = expr.ClosingParen
+	case *ast.Index:
+		tok = expr.Bracket
+	case *ast.IndexSet:
+		tok = expr.Bracket
+	case *ast.List:
+		tok = expr.Bracket
+	case *ast.Map:
+		tok = expr.Brace
+	case *ast.Lambda:
+		tok = expr.Function.Name
+	}
+	e.Line = tok.Line
+	if dist, ok := te.Locals[expr]; ok {
+		e.Scope = &dist
+	}
+	t.write(e)
+}
+
+func nodeName(node any) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+}
+
+func traceValue(v any) json.RawMessage {
+	switch f := v.(type) {
+	case float64:
+		// JSON has no infinities or NaN.
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			v = fmt.Sprint(v)
+		}
+	case nil, bool, string:
+	default:
+		v = fmt.Sprint(v)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// Functions print as <fun name>.
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
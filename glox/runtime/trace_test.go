@@ -0,0 +1,57 @@
+package runtime_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"glox/runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLox_Tracer(t *testing.T) {
+	var trace bytes.Buffer
+	l := runtime.NewLoxInterpreter()
+	l.Stdout = &bytes.Buffer{}
+	l.Tracer = runtime.NewTracer(&trace)
+	_, err := l.Run(`var x = 1;
+fun add(n) {
+	return x + n;
+}
+{
+	var y = add(2);
+	print "<" + to_string(y);
+}
+`)
+	require.NoError(t, err)
+	require.NoError(t, l.Tracer.Err())
+
+	var lines []string
+	scanner := bufio.NewScanner(&trace)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		assert.True(t, json.Valid(scanner.Bytes()), scanner.Text())
+	}
+	assert.Equal(t, []string{
+		`{"kind":"stmt","node":"Var","line":1,"calls":0}`,
+		`{"kind":"expr","node":"Literal","calls":0,"value":1}`,
+		`{"kind":"stmt","node":"Function","line":2,"calls":0}`,
+		`{"kind":"stmt","node":"Block","calls":0}`,
+		`{"kind":"stmt","node":"Var","line":6,"calls":0}`,
+		`{"kind":"expr","node":"Variable","line":6,"calls":0,"name":"add","value":"<fun add>"}`,
+		`{"kind":"expr","node":"Literal","calls":0,"value":2}`,
+		`{"kind":"stmt","node":"Return","line":3,"calls":1}`,
+		`{"kind":"expr","node":"Variable","line":3,"calls":1,"name":"x","value":1}`,
+		`{"kind":"expr","node":"Variable","line":3,"calls":1,"name":"n","scope":0,"value":2}`,
+		`{"kind":"expr","node":"Binary","line":3,"calls":1,"value":3}`,
+		`{"kind":"expr","node":"Call","line":6,"calls":0,"value":3}`,
+		`{"kind":"stmt","node":"Print","line":7,"calls":0}`,
+		`{"kind":"expr","node":"Literal","calls":0,"value":"<"}`,
+		`{"kind":"expr","node":"Variable","line":7,"calls":0,"name":"to_string","value":"<built-in fun to_string>"}`,
+		`{"kind":"expr","node":"Variable","line":7,"calls":0,"name":"y","scope":0,"value":3}`,
+		`{"kind":"expr","node":"Call","line":7,"calls":0,"value":"3"}`,
+		`{"kind":"expr","node":"Binary","line":7,"calls":0,"value":"<3"}`,
+	}, lines)
+}
@@ -17,6 +17,7 @@ type ClassType int
 const (
 	CLASSTYPE_NONE = iota
 	CLASSTYPE_CLASS
+	CLASSTYPE_SUBCLASS
 )
 
 // ---------------- Visitor Implementation ----------------
@@ -29,6 +30,17 @@ func (r *resolver) VisitThis(expr *ast.This) error {
 	return nil
 }
 
+func (r *resolver) VisitSuper(expr *ast.Super) error {
+	switch r.currentClass {
+	case CLASSTYPE_NONE:
+		return expr.Keyword.MakeError("use of 'super' outside a class definition")
+	case CLASSTYPE_CLASS:
+		return expr.Keyword.MakeError("use of 'super' in a class with no superclass")
+	}
+	r.ResolveLocal(expr, expr.Keyword)
+	return nil
+}
+
 func (r *resolver) VisitSet(expr *ast.Set) error {
 	if err := expr.Value.Accept(r); err != nil {
 		return err
@@ -43,13 +55,66 @@ func (r *resolver) VisitGet(expr *ast.Get) error {
 	return expr.Object.Accept(r)
 }
 
+func (r *resolver) VisitIndex(expr *ast.Index) error {
+	if err := expr.Object.Accept(r); err != nil {
+		return err
+	}
+	return expr.Index.Accept(r)
+}
+
+func (r *resolver) VisitIndexSet(expr *ast.IndexSet) error {
+	if err := expr.Value.Accept(r); err != nil {
+		return err
+	}
+	if err := expr.Object.Accept(r); err != nil {
+		return err
+	}
+	return expr.Index.Accept(r)
+}
+
+func (r *resolver) VisitMap(expr *ast.Map) error {
+	for i, k := range expr.Keys {
+		if err := k.Accept(r); err != nil {
+			return err
+		}
+		if err := expr.Values[i].Accept(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *resolver) VisitList(expr *ast.List) error {
+	for _, e := range expr.Elements {
+		if err := e.Accept(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *resolver) VisitClass(stmt *ast.Class) error {
 	prevClass := r.currentClass
 	r.currentClass = CLASSTYPE_CLASS
 	defer func() { r.currentClass = prevClass }()
-	r.Declare(stmt.Name.Lexeme)
+	r.Declare(stmt.Name)
 	r.Define(stmt.Name.Lexeme)
 
+	// Methods of a subclass are closed over an extra scope
+	// holding "super", just outside of the one holding "this".
+	if stmt.Superclass != nil {
+		if stmt.Superclass.Name.Lexeme == stmt.Name.Lexeme {
+			return stmt.Superclass.Name.MakeError("a class can't inherit from itself")
+		}
+		r.currentClass = CLASSTYPE_SUBCLASS
+		if err := stmt.Superclass.Accept(r); err != nil {
+			return err
+		}
+		r.BeginScope()
+		defer r.EndScope()
+		r.CurrentScope()["super"] = true
+	}
+
 	r.BeginScope()
 	defer r.EndScope()
 	r.CurrentScope()["this"] = true
@@ -73,7 +138,7 @@ func (r *resolver) VisitBlock(stmt *ast.Block) error {
 }
 
 func (r *resolver) VisitVar(stmt *ast.Var) error {
-	if err := r.Declare(stmt.Name.Lexeme); err != nil {
+	if err := r.Declare(stmt.Name); err != nil {
 		return stmt.Name.MakeError(err.Error())
 	}
 	if stmt.Initializer != nil {
@@ -85,6 +150,14 @@ func (r *resolver) VisitVar(stmt *ast.Var) error {
 	return nil
 }
 
+func (r *resolver) VisitImport(stmt *ast.Import) error {
+	if err := r.Declare(stmt.Name); err != nil {
+		return stmt.Name.MakeError(err.Error())
+	}
+	r.Define(stmt.Name.Lexeme)
+	return nil
+}
+
 func (r *resolver) VisitVariable(e *ast.Variable) error {
 	cs := r.CurrentScope()
 	if cs != nil {
@@ -106,17 +179,27 @@ func (r *resolver) VisitAssignment(e *ast.Assignment) error {
 }
 
 func (r *resolver) VisitFunction(s *ast.Function) error {
-	r.Declare(s.Name.Lexeme)
+	r.Declare(s.Name)
 	r.Define(s.Name.Lexeme)
 	return r.ResolveFunction(s, FUNCTIONTYPE_FUNCTION)
 }
+
+func (r *resolver) VisitLambda(e *ast.Lambda) error {
+	return r.ResolveFunction(e.Function, FUNCTIONTYPE_FUNCTION)
+}
+
 func (r *resolver) ResolveFunction(s *ast.Function, typ FunctionType) error {
 	enclosingFunction := r.currentFunction
 	r.currentFunction = typ
+	// Loops don't extend into function bodies, a `break`
+	// can't jump out of the function it's declared in.
+	enclosingLoopDepth := r.loopDepth
+	r.loopDepth = 0
+	defer func() { r.loopDepth = enclosingLoopDepth }()
 	r.BeginScope()
 	defer r.EndScope()
 	for _, param := range s.Params {
-		if err := r.Declare(param.Lexeme); err != nil {
+		if err := r.Declare(param); err != nil {
 			return param.MakeError("parameter defined twice")
 		}
 		r.Define(param.Lexeme)
@@ -134,7 +217,15 @@ func (r *resolver) VisitWhile(s *ast.While) error {
 	if err := s.Condition.Accept(r); err != nil {
 		return err
 	}
-	return s.Do.Accept(r)
+	r.loopDepth++
+	defer func() { r.loopDepth-- }()
+	if err := s.Do.Accept(r); err != nil {
+		return err
+	}
+	if s.Increment != nil {
+		return s.Increment.Accept(r)
+	}
+	return nil
 }
 
 func (r *resolver) VisitIf(s *ast.If) error {
@@ -151,6 +242,46 @@ func (r *resolver) VisitIf(s *ast.If) error {
 }
 
 func (r *resolver) VisitBreak(s *ast.Break) error {
+	if r.loopDepth == 0 {
+		if s.Continue {
+			return s.Keyword.MakeError("continue outside of a loop")
+		}
+		return s.Keyword.MakeError("break outside of a loop")
+	}
+	return nil
+}
+
+func (r *resolver) VisitThrow(s *ast.Throw) error {
+	return s.Expression.Accept(r)
+}
+
+func (r *resolver) VisitTry(s *ast.Try) error {
+	if err := s.Body.Accept(r); err != nil {
+		return err
+	}
+	if s.Catch != nil {
+		if err := r.resolveCatch(s); err != nil {
+			return err
+		}
+	}
+	if s.Finally != nil {
+		return s.Finally.Accept(r)
+	}
+	return nil
+}
+
+// The exception variable lives in a scope wrapping
+// the catch block's statements.
+func (r *resolver) resolveCatch(s *ast.Try) error {
+	r.BeginScope()
+	defer r.EndScope()
+	r.Declare(s.CatchName)
+	r.Define(s.CatchName.Lexeme)
+	for _, stmt := range s.Catch.Statements {
+		if err := stmt.Accept(r); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,64 @@
+package variable_resolver
+
+import (
+	"glox/ast"
+	"glox/errors"
+	"glox/lexer"
+)
+
+/*
+ResolveBindings resolves the variables of a program like ResolveVariables,
+but returns the declaration each reference is bound to instead of its
+distance. References are the ast.Variable and ast.Assignment expressions,
+and declarations are represented by their name token: the name of a var,
+function, class, parameter, import or caught exception.
+
+Globals can be referenced before they're declared, so references to them
+are bound to their first declaration, wherever it is. References to
+natives and to globals that aren't declared are left out.
+
+Tools like the language server resolve programs that are being edited,
+so unlike ResolveVariables an error doesn't stop the resolution: the
+next top level statement is resolved in turn, and the errors are returned
+together as an errors.ErrorList, along with the bindings found.
+*/
+func ResolveBindings(stmts []ast.Stmt) (map[ast.Expr]lexer.Token, error) {
+	r := newresolver()
+	var errs errors.ErrorList
+	for _, stmt := range stmts {
+		if err := stmt.Accept(r); err != nil {
+			errs = append(errs, err)
+			// The statement may have stopped partway through a function.
+			r.currentFunction = FUNCTIONTYPE_NONE
+		}
+	}
+	for e, name := range r.unbound {
+		if decl, ok := r.globals[name]; ok {
+			r.bindings[e] = decl
+		}
+	}
+	return r.bindings, errs.Err()
+}
+
+// ResolveExpression resolves the variables of an expression evaluated
+// in the middle of a program, the way a debugger does. scopes are the
+// names declared in each local scope at that point, outermost first.
+func ResolveExpression(expr ast.Expr, scopes [][]string) (map[ast.Expr]int, error) {
+	r := newresolver()
+	for _, names := range scopes {
+		r.BeginScope()
+		for _, name := range names {
+			r.CurrentScope()[name] = true
+			switch {
+			case name == "super":
+				r.currentClass = CLASSTYPE_SUBCLASS
+			case name == "this" && r.currentClass == CLASSTYPE_NONE:
+				r.currentClass = CLASSTYPE_CLASS
+			}
+		}
+	}
+	if err := expr.Accept(r); err != nil {
+		return nil, err
+	}
+	return r.localsMap, nil
+}
@@ -46,8 +46,17 @@ func ResolveVariables(stmts []ast.Stmt) (map[ast.Expr]int, error) {
 type resolver struct {
 	currentFunction FunctionType
 	currentClass    ClassType
+	loopDepth       int
 	scopes          []map[string]bool
 	localsMap       map[ast.Expr]int
+
+	// The name token of each declaration in scopes, and of the
+	// first declaration of each global, for ResolveBindings.
+	decls    []map[string]lexer.Token
+	globals  map[string]lexer.Token
+	bindings map[ast.Expr]lexer.Token
+	// References that aren't to locals, by the name they refer to.
+	unbound map[ast.Expr]string
 }
 
 func newresolver() *resolver {
@@ -56,16 +65,21 @@ func newresolver() *resolver {
 		currentClass:    CLASSTYPE_NONE,
 		scopes:          make([]map[string]bool, 0),
 		localsMap:       make(map[ast.Expr]int),
+		globals:         make(map[string]lexer.Token),
+		bindings:        make(map[ast.Expr]lexer.Token),
+		unbound:         make(map[ast.Expr]string),
 	}
 }
 
 // ---------------- Utils ----------------
 func (r *resolver) BeginScope() {
 	r.scopes = append(r.scopes, make(map[string]bool))
+	r.decls = append(r.decls, make(map[string]lexer.Token))
 }
 
 func (r *resolver) EndScope() {
 	r.scopes = r.scopes[:len(r.scopes)-1]
+	r.decls = r.decls[:len(r.decls)-1]
 }
 
 func (r *resolver) CurrentScope() map[string]bool {
@@ -75,15 +89,19 @@ func (r *resolver) CurrentScope() map[string]bool {
 	return r.scopes[len(r.scopes)-1]
 }
 
-func (r *resolver) Declare(name string) error {
+func (r *resolver) Declare(name lexer.Token) error {
 	cs := r.CurrentScope()
 	if cs == nil {
+		if _, ok := r.globals[name.Lexeme]; !ok {
+			r.globals[name.Lexeme] = name
+		}
 		return nil
 	}
-	if _, ok := cs[name]; ok {
+	if _, ok := cs[name.Lexeme]; ok {
 		return errors.New("variable already declared")
 	}
-	cs[name] = false
+	cs[name.Lexeme] = false
+	r.decls[len(r.decls)-1][name.Lexeme] = name
 	return nil
 }
 func (r *resolver) Define(name string) {
@@ -98,6 +116,11 @@ func (r *resolver) ResolveLocal(e ast.Expr, t lexer.Token) {
 	for i := len(r.scopes) - 1; i >= 0; i -= 1 {
 		if _, ok := r.scopes[i][t.Lexeme]; ok {
 			r.localsMap[e] = len(r.scopes) - 1 - i
+			if decl, ok := r.decls[i][t.Lexeme]; ok {
+				r.bindings[e] = decl
+			}
+			return
 		}
 	}
+	r.unbound[e] = t.Lexeme
 }
@@ -0,0 +1,277 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"glox/lexer"
+	"io"
+)
+
+type OpCode byte
+
+const (
+	// Push a constant onto the stack.
+	// operands: u16 constant index
+	OP_CONSTANT OpCode = iota
+	// Push a literal onto the stack.
+	OP_NIL
+	OP_TRUE
+	OP_FALSE
+	// Drop the top of the stack.
+	OP_POP
+	// Pop the top of the stack into the VM's result register,
+	// which holds the value a script evaluates to.
+	OP_RESULT
+
+	// Manage locals, addressed by their slot in the current frame.
+	// operands: u8 slot
+	OP_GET_LOCAL
+	OP_SET_LOCAL
+	// Manage globals, addressed by a constant holding their name.
+	// operands: u16 constant index
+	OP_DEFINE_GLOBAL
+	OP_GET_GLOBAL
+	OP_SET_GLOBAL
+	// Manage variables captured by a closure.
+	// operands: u8 upvalue index
+	OP_GET_UPVALUE
+	OP_SET_UPVALUE
+	// Move the local on top of the stack to the heap,
+	// so closures that captured it outlive the frame.
+	OP_CLOSE_UPVALUE
+
+	// Property access on the object at the top of the stack.
+	// operands: u16 constant index of the property name
+	OP_GET_PROPERTY
+	OP_SET_PROPERTY
+	// Pop a superclass and an instance, push the superclass method
+	// bound to the instance.
+	// operands: u16 constant index of the method name
+	OP_GET_SUPER
+	// Index into the object below the top of the stack.
+	OP_GET_INDEX
+	OP_SET_INDEX
+
+	// Pop 2, compare, push the result.
+	OP_EQUAL
+	OP_NOT_EQUAL
+	OP_GREATER
+	OP_GREATER_EQUAL
+	OP_LESS
+	OP_LESS_EQUAL
+	// Pop 2, combine, push the result.
+	OP_ADD
+	OP_SUBTRACT
+	OP_MULTIPLY
+	OP_DIVIDE
+	// Pop 1, push the result.
+	OP_NOT
+	OP_NEGATE
+
+	// Pop the top of the stack and print it.
+	OP_PRINT
+
+	// Jumps relative to the end of the instruction.
+	// operands: u16 offset
+	OP_JUMP
+	OP_JUMP_IF_FALSE
+	OP_LOOP
+
+	// Call the value below the arguments on the stack.
+	// operands: u8 argument count
+	OP_CALL
+	// Wrap a function constant in a closure. The constant is
+	// followed by a (u8 isLocal, u8 index) pair for each upvalue.
+	// operands: u16 constant index
+	OP_CLOSURE
+	// Return from the current frame with the top of the stack.
+	OP_RETURN
+
+	// Push a new class.
+	// operands: u16 constant index of the class name
+	OP_CLASS
+	// Pop a subclass, and copy the methods of the superclass
+	// below it into it.
+	OP_INHERIT
+	// Pop a closure and add it as a method of the class below it.
+	// operands: u16 constant index of the method name
+	OP_METHOD
+
+	// Pop the top of the stack and throw it.
+	OP_THROW
+	// Install an exception handler, which on error unwinds the stack
+	// back to its current height, pushes the caught value and jumps.
+	// operands: u16 forward offset of the handler code
+	OP_TRY
+	// Remove the innermost exception handler.
+	OP_END_TRY
+
+	// Push the module of an imported file.
+	// operands: u16 constant index of the file's path
+	OP_IMPORT
+
+	// Pop n elements and push a list of them.
+	// operands: u16 element count
+	OP_LIST
+	// Pop n key/value pairs and push a map of them.
+	// operands: u16 entry count
+	OP_MAP
+)
+
+var opNames = map[OpCode]string{
+	OP_CONSTANT:      "OP_CONSTANT",
+	OP_NIL:           "OP_NIL",
+	OP_TRUE:          "OP_TRUE",
+	OP_FALSE:         "OP_FALSE",
+	OP_POP:           "OP_POP",
+	OP_RESULT:        "OP_RESULT",
+	OP_GET_LOCAL:     "OP_GET_LOCAL",
+	OP_SET_LOCAL:     "OP_SET_LOCAL",
+	OP_DEFINE_GLOBAL: "OP_DEFINE_GLOBAL",
+	OP_GET_GLOBAL:    "OP_GET_GLOBAL",
+	OP_SET_GLOBAL:    "OP_SET_GLOBAL",
+	OP_GET_UPVALUE:   "OP_GET_UPVALUE",
+	OP_SET_UPVALUE:   "OP_SET_UPVALUE",
+	OP_CLOSE_UPVALUE: "OP_CLOSE_UPVALUE",
+	OP_GET_PROPERTY:  "OP_GET_PROPERTY",
+	OP_SET_PROPERTY:  "OP_SET_PROPERTY",
+	OP_GET_SUPER:     "OP_GET_SUPER",
+	OP_GET_INDEX:     "OP_GET_INDEX",
+	OP_SET_INDEX:     "OP_SET_INDEX",
+	OP_EQUAL:         "OP_EQUAL",
+	OP_NOT_EQUAL:     "OP_NOT_EQUAL",
+	OP_GREATER:       "OP_GREATER",
+	OP_GREATER_EQUAL: "OP_GREATER_EQUAL",
+	OP_LESS:          "OP_LESS",
+	OP_LESS_EQUAL:    "OP_LESS_EQUAL",
+	OP_ADD:           "OP_ADD",
+	OP_SUBTRACT:      "OP_SUBTRACT",
+	OP_MULTIPLY:      "OP_MULTIPLY",
+	OP_DIVIDE:        "OP_DIVIDE",
+	OP_NOT:           "OP_NOT",
+	OP_NEGATE:        "OP_NEGATE",
+	OP_PRINT:         "OP_PRINT",
+	OP_JUMP:          "OP_JUMP",
+	OP_JUMP_IF_FALSE: "OP_JUMP_IF_FALSE",
+	OP_LOOP:          "OP_LOOP",
+	OP_CALL:          "OP_CALL",
+	OP_CLOSURE:       "OP_CLOSURE",
+	OP_RETURN:        "OP_RETURN",
+	OP_CLASS:         "OP_CLASS",
+	OP_INHERIT:       "OP_INHERIT",
+	OP_METHOD:        "OP_METHOD",
+	OP_THROW:         "OP_THROW",
+	OP_TRY:           "OP_TRY",
+	OP_END_TRY:       "OP_END_TRY",
+	OP_IMPORT:        "OP_IMPORT",
+	OP_LIST:          "OP_LIST",
+	OP_MAP:           "OP_MAP",
+}
+
+func (op OpCode) String() string {
+	if name, ok := opNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("OpCode(%d)", byte(op))
+}
+
+// A Chunk holds compiled lox code, along with the
+// information needed to map it back to the source.
+type Chunk struct {
+	Code      []byte
+	Constants []any
+
+	// Tokens holds, for every byte in Code, the token
+	// it was compiled from. Used for error reporting.
+	Tokens []lexer.Token
+}
+
+// Write puts an arbitrary byte in the code block.
+func (c *Chunk) Write(b byte, tok lexer.Token) {
+	c.Code = append(c.Code, b)
+	c.Tokens = append(c.Tokens, tok)
+}
+
+func (c *Chunk) WriteOp(op OpCode, tok lexer.Token) {
+	c.Write(byte(op), tok)
+}
+
+func (c *Chunk) WriteU16(v uint16, tok lexer.Token) {
+	c.Write(byte(v), tok)
+	c.Write(byte(v>>8), tok)
+}
+
+func (c *Chunk) ReadU16(offset int) uint16 {
+	return binary.LittleEndian.Uint16(c.Code[offset:])
+}
+
+// AddConstant appends a constant to this chunk, returning
+// its index for use as an instruction operand.
+func (c *Chunk) AddConstant(val any) int {
+	for i, k := range c.Constants {
+		// Reuse constants for names and literals that show up
+		// more than once, functions are always distinct.
+		if _, ok := k.(*Function); !ok && k == val {
+			return i
+		}
+	}
+	c.Constants = append(c.Constants, val)
+	return len(c.Constants) - 1
+}
+
+// Disassemble writes a human readable listing of the chunk to w.
+func (c *Chunk) Disassemble(w io.Writer, name string) {
+	fmt.Fprintf(w, "== %s ==\n", name)
+	for offset := 0; offset < len(c.Code); {
+		offset = c.DisassembleInstruction(w, offset)
+	}
+}
+
+// DisassembleInstruction writes the instruction at offset,
+// returning the offset of the next instruction.
+func (c *Chunk) DisassembleInstruction(w io.Writer, offset int) int {
+	fmt.Fprintf(w, "%04d ", offset)
+	if offset > 0 && c.Tokens[offset].Line == c.Tokens[offset-1].Line {
+		fmt.Fprint(w, "   | ")
+	} else {
+		fmt.Fprintf(w, "%4d ", c.Tokens[offset].Line)
+	}
+	op := OpCode(c.Code[offset])
+	switch op {
+	case OP_CONSTANT, OP_DEFINE_GLOBAL, OP_GET_GLOBAL, OP_SET_GLOBAL,
+		OP_GET_PROPERTY, OP_SET_PROPERTY, OP_GET_SUPER, OP_CLASS, OP_METHOD, OP_IMPORT:
+		idx := c.ReadU16(offset + 1)
+		fmt.Fprintf(w, "%-16s %4d '%v'\n", op, idx, c.Constants[idx])
+		return offset + 3
+	case OP_GET_LOCAL, OP_SET_LOCAL, OP_GET_UPVALUE, OP_SET_UPVALUE, OP_CALL:
+		fmt.Fprintf(w, "%-16s %4d\n", op, c.Code[offset+1])
+		return offset + 2
+	case OP_LIST, OP_MAP:
+		fmt.Fprintf(w, "%-16s %4d\n", op, c.ReadU16(offset+1))
+		return offset + 3
+	case OP_JUMP, OP_JUMP_IF_FALSE, OP_TRY:
+		jump := int(c.ReadU16(offset + 1))
+		fmt.Fprintf(w, "%-16s %4d -> %d\n", op, offset, offset+3+jump)
+		return offset + 3
+	case OP_LOOP:
+		jump := int(c.ReadU16(offset + 1))
+		fmt.Fprintf(w, "%-16s %4d -> %d\n", op, offset, offset+3-jump)
+		return offset + 3
+	case OP_CLOSURE:
+		idx := c.ReadU16(offset + 1)
+		fn := c.Constants[idx].(*Function)
+		fmt.Fprintf(w, "%-16s %4d %v\n", op, idx, fn)
+		offset += 3
+		for i := 0; i < fn.UpvalueCount; i++ {
+			kind := "upvalue"
+			if c.Code[offset] == 1 {
+				kind = "local"
+			}
+			fmt.Fprintf(w, "%04d    |                     %s %d\n", offset, kind, c.Code[offset+1])
+			offset += 2
+		}
+		return offset
+	}
+	fmt.Fprintf(w, "%s\n", op)
+	return offset + 1
+}
@@ -0,0 +1,989 @@
+package vm
+
+import (
+	"glox/ast"
+	"glox/lexer"
+	"math"
+)
+
+type FunctionType int
+
+const (
+	FUNCTIONTYPE_SCRIPT FunctionType = iota
+	FUNCTIONTYPE_FUNCTION
+	FUNCTIONTYPE_METHOD
+)
+
+// A local variable. The position of a local in the compiler's
+// locals slice is the slot it occupies in its frame at run time.
+type local struct {
+	name string
+	// Scope depth the local was declared at, or -1
+	// while its initializer is being compiled.
+	depth int
+	// Whether a closure captured this local, in which case it
+	// must be moved off the stack when it goes out of scope.
+	captured bool
+}
+
+type upvalueRef struct {
+	index   byte
+	isLocal bool
+}
+
+// loop tracks the forward jumps out of a loop body, which
+// can only be patched once the whole loop is compiled.
+type loop struct {
+	// Scope depth enclosing the loop body. Locals deeper
+	// than this are discarded by `break` and `continue`.
+	depth int
+	// Number of try statements enclosing the loop.
+	tries     int
+	breaks    []int
+	continues []int
+}
+
+// tryContext tracks a try statement being compiled, so that code
+// jumping out of it early can run its finally block first.
+type tryContext struct {
+	finally *ast.Block
+	// Whether an exception handler for the statement is
+	// installed at the point being compiled.
+	handler bool
+}
+
+// compiler turns the body of a single function into bytecode.
+// Nested function declarations get their own compiler, linked
+// back to this one to resolve captured variables.
+type compiler struct {
+	enclosing  *compiler
+	fn         *Function
+	kind       FunctionType
+	locals     []local
+	upvalues   []upvalueRef
+	scopeDepth int
+	loops      []*loop
+	tries      []*tryContext
+
+	// The most recent token seen, attached to emitted code.
+	token lexer.Token
+}
+
+/*
+Compile translates a resolved program into the function that runs
+it. Unlike the tree walker, variables aren't looked up by name in an
+Environment: locals live in numbered slots on the VM stack, and
+closures reach variables of enclosing functions through upvalues.
+
+	fun counter() {
+		var i = 0;           // slot 1 of counter's frame
+		fun inc() {
+			i = i + 1;   // upvalue 0 of inc, pointing at that slot
+			return i;
+		}
+		return inc;
+	}
+
+Only globals are still looked up by name.
+*/
+func Compile(stmts []ast.Stmt) (*Function, error) {
+	c := newCompiler(nil, FUNCTIONTYPE_SCRIPT, "")
+	for _, s := range stmts {
+		if err := s.Accept(c); err != nil {
+			return nil, err
+		}
+	}
+	c.emitReturn()
+	return c.fn, nil
+}
+
+func newCompiler(enclosing *compiler, kind FunctionType, name string) *compiler {
+	c := &compiler{
+		enclosing: enclosing,
+		fn:        &Function{Name: name},
+		kind:      kind,
+	}
+	if enclosing != nil {
+		c.token = enclosing.token
+	}
+	// Slot 0 holds the function being called, or for
+	// methods, the instance it was bound to.
+	slotZero := ""
+	if kind == FUNCTIONTYPE_METHOD {
+		slotZero = "this"
+	}
+	c.locals = append(c.locals, local{name: slotZero, depth: 0})
+	return c
+}
+
+// ---------------- Emitting code ----------------
+
+func (c *compiler) chunk() *Chunk {
+	return &c.fn.Chunk
+}
+
+func (c *compiler) emit(op OpCode) {
+	c.chunk().WriteOp(op, c.token)
+}
+
+func (c *compiler) emitByte(op OpCode, b byte) {
+	c.emit(op)
+	c.chunk().Write(b, c.token)
+}
+
+func (c *compiler) emitU16(op OpCode, v uint16) {
+	c.emit(op)
+	c.chunk().WriteU16(v, c.token)
+}
+
+func (c *compiler) makeConstant(val any) (uint16, error) {
+	idx := c.chunk().AddConstant(val)
+	if idx > math.MaxUint16 {
+		return 0, c.token.MakeError("too many constants in one function")
+	}
+	return uint16(idx), nil
+}
+
+func (c *compiler) emitConstant(op OpCode, val any) error {
+	idx, err := c.makeConstant(val)
+	if err != nil {
+		return err
+	}
+	c.emitU16(op, idx)
+	return nil
+}
+
+// emitJump writes a jump with a placeholder offset,
+// returning the position of the offset to patch later.
+func (c *compiler) emitJump(op OpCode) int {
+	c.emitU16(op, math.MaxUint16)
+	return len(c.chunk().Code) - 2
+}
+
+// patchJump points the jump at offset to the end of the code.
+func (c *compiler) patchJump(offset int) error {
+	jump := len(c.chunk().Code) - offset - 2
+	if jump > math.MaxUint16 {
+		return c.token.MakeError("too much code to jump over")
+	}
+	c.chunk().Code[offset] = byte(jump)
+	c.chunk().Code[offset+1] = byte(jump >> 8)
+	return nil
+}
+
+func (c *compiler) emitLoop(start int) error {
+	jump := len(c.chunk().Code) - start + 3
+	if jump > math.MaxUint16 {
+		return c.token.MakeError("loop body too large")
+	}
+	c.emitU16(OP_LOOP, uint16(jump))
+	return nil
+}
+
+func (c *compiler) emitReturn() {
+	c.emit(OP_NIL)
+	c.emit(OP_RETURN)
+}
+
+// ---------------- Scopes & variables ----------------
+
+func (c *compiler) beginScope() {
+	c.scopeDepth++
+}
+
+func (c *compiler) endScope() {
+	c.scopeDepth--
+	for len(c.locals) > 0 && c.locals[len(c.locals)-1].depth > c.scopeDepth {
+		c.discardLocal(c.locals[len(c.locals)-1])
+		c.locals = c.locals[:len(c.locals)-1]
+	}
+}
+
+// discardLocal emits the code that removes a local from the stack.
+func (c *compiler) discardLocal(l local) {
+	if l.captured {
+		c.emit(OP_CLOSE_UPVALUE)
+	} else {
+		c.emit(OP_POP)
+	}
+}
+
+func (c *compiler) addLocal(name lexer.Token) error {
+	if len(c.locals) > math.MaxUint8 {
+		return name.MakeError("too many local variables in function")
+	}
+	c.locals = append(c.locals, local{name: name.Lexeme, depth: -1})
+	return nil
+}
+
+// addHiddenLocal reserves a slot for a value the compiler
+// keeps on the stack, which no identifier can refer to.
+func (c *compiler) addHiddenLocal() (byte, error) {
+	if len(c.locals) > math.MaxUint8 {
+		return 0, c.token.MakeError("too many local variables in function")
+	}
+	c.locals = append(c.locals, local{name: "", depth: c.scopeDepth})
+	return byte(len(c.locals) - 1), nil
+}
+
+// dropScope ends a scope without emitting code, for
+// scopes whose code always jumps out before the end.
+func (c *compiler) dropScope() {
+	c.scopeDepth--
+	for len(c.locals) > 0 && c.locals[len(c.locals)-1].depth > c.scopeDepth {
+		c.locals = c.locals[:len(c.locals)-1]
+	}
+}
+
+// declareVariable adds a local for name, unless we're in
+// the global scope, where variables are late bound by name.
+func (c *compiler) declareVariable(name lexer.Token) error {
+	if c.scopeDepth == 0 {
+		return nil
+	}
+	return c.addLocal(name)
+}
+
+// defineVariable makes the value on top of the stack the value
+// of the most recently declared variable.
+func (c *compiler) defineVariable(name lexer.Token) error {
+	if c.scopeDepth > 0 {
+		c.markInitialized()
+		return nil
+	}
+	return c.emitConstant(OP_DEFINE_GLOBAL, name.Lexeme)
+}
+
+func (c *compiler) markInitialized() {
+	if c.scopeDepth == 0 {
+		return
+	}
+	c.locals[len(c.locals)-1].depth = c.scopeDepth
+}
+
+// resolveLocal returns the slot of the innermost local named
+// name in this function, or -1 if there isn't one.
+func (c *compiler) resolveLocal(name lexer.Token) (int, error) {
+	for i := len(c.locals) - 1; i >= 0; i-- {
+		if c.locals[i].name == name.Lexeme {
+			if c.locals[i].depth == -1 {
+				return 0, name.MakeError("can't read local variable in its own initializer")
+			}
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// resolveUpvalue returns the upvalue index for a local named name
+// in one of the enclosing functions, or -1 if there isn't one.
+func (c *compiler) resolveUpvalue(name lexer.Token) (int, error) {
+	if c.enclosing == nil {
+		return -1, nil
+	}
+	slot, err := c.enclosing.resolveLocal(name)
+	if err != nil {
+		return 0, err
+	}
+	if slot != -1 {
+		c.enclosing.locals[slot].captured = true
+		return c.addUpvalue(name, byte(slot), true)
+	}
+	idx, err := c.enclosing.resolveUpvalue(name)
+	if err != nil || idx == -1 {
+		return idx, err
+	}
+	return c.addUpvalue(name, byte(idx), false)
+}
+
+func (c *compiler) addUpvalue(name lexer.Token, index byte, isLocal bool) (int, error) {
+	ref := upvalueRef{index: index, isLocal: isLocal}
+	for i, u := range c.upvalues {
+		if u == ref {
+			return i, nil
+		}
+	}
+	if len(c.upvalues) > math.MaxUint8 {
+		return 0, name.MakeError("too many closure variables in function")
+	}
+	c.upvalues = append(c.upvalues, ref)
+	c.fn.UpvalueCount = len(c.upvalues)
+	return len(c.upvalues) - 1, nil
+}
+
+// namedVariable emits a read of the variable name, or, if set is
+// true, a write of the value on top of the stack to it.
+func (c *compiler) namedVariable(name lexer.Token, set bool) error {
+	c.token = name
+	getOp, setOp := OP_GET_LOCAL, OP_SET_LOCAL
+	idx, err := c.resolveLocal(name)
+	if err != nil {
+		return err
+	}
+	if idx == -1 {
+		getOp, setOp = OP_GET_UPVALUE, OP_SET_UPVALUE
+		if idx, err = c.resolveUpvalue(name); err != nil {
+			return err
+		}
+	}
+	if idx == -1 {
+		if set {
+			return c.emitConstant(OP_SET_GLOBAL, name.Lexeme)
+		}
+		return c.emitConstant(OP_GET_GLOBAL, name.Lexeme)
+	}
+	if set {
+		c.emitByte(setOp, byte(idx))
+	} else {
+		c.emitByte(getOp, byte(idx))
+	}
+	return nil
+}
+
+// function compiles a function declaration into a closure on top of the stack.
+func (c *compiler) function(decl *ast.Function, kind FunctionType) error {
+	sub := newCompiler(c, kind, decl.Name.Lexeme)
+	sub.token = decl.Name
+	sub.fn.Arity = len(decl.Params)
+	sub.fn.Line = decl.Name.Line
+	sub.beginScope()
+	for _, param := range decl.Params {
+		if err := sub.addLocal(param); err != nil {
+			return err
+		}
+		sub.markInitialized()
+	}
+	for _, s := range decl.Body {
+		if err := s.Accept(sub); err != nil {
+			return err
+		}
+	}
+	sub.emitReturn()
+
+	c.token = decl.Name
+	if err := c.emitConstant(OP_CLOSURE, sub.fn); err != nil {
+		return err
+	}
+	for _, u := range sub.upvalues {
+		isLocal := byte(0)
+		if u.isLocal {
+			isLocal = 1
+		}
+		c.chunk().Write(isLocal, c.token)
+		c.chunk().Write(u.index, c.token)
+	}
+	return nil
+}
+
+// ---------------- Statements ----------------
+
+func (c *compiler) VisitExpression(stmt *ast.Expression) error {
+	if err := stmt.Expression.Accept(c); err != nil {
+		return err
+	}
+	if c.kind == FUNCTIONTYPE_SCRIPT {
+		c.emit(OP_RESULT)
+	} else {
+		c.emit(OP_POP)
+	}
+	return nil
+}
+
+func (c *compiler) VisitPrint(stmt *ast.Print) error {
+	if err := stmt.Expression.Accept(c); err != nil {
+		return err
+	}
+	c.emit(OP_PRINT)
+	return nil
+}
+
+func (c *compiler) VisitVar(stmt *ast.Var) error {
+	if err := c.declareVariable(stmt.Name); err != nil {
+		return err
+	}
+	if stmt.Initializer != nil {
+		if err := stmt.Initializer.Accept(c); err != nil {
+			return err
+		}
+	} else {
+		c.emit(OP_NIL)
+	}
+	c.token = stmt.Name
+	return c.defineVariable(stmt.Name)
+}
+
+func (c *compiler) VisitImport(stmt *ast.Import) error {
+	if err := c.declareVariable(stmt.Name); err != nil {
+		return err
+	}
+	c.token = stmt.Keyword
+	if err := c.emitConstant(OP_IMPORT, stmt.Path.Value); err != nil {
+		return err
+	}
+	c.token = stmt.Name
+	return c.defineVariable(stmt.Name)
+}
+
+func (c *compiler) VisitBlock(stmt *ast.Block) error {
+	c.beginScope()
+	for _, s := range stmt.Statements {
+		if err := s.Accept(c); err != nil {
+			return err
+		}
+	}
+	c.endScope()
+	return nil
+}
+
+func (c *compiler) VisitIf(stmt *ast.If) error {
+	if err := stmt.Condition.Accept(c); err != nil {
+		return err
+	}
+	thenJump := c.emitJump(OP_JUMP_IF_FALSE)
+	c.emit(OP_POP)
+	if err := stmt.ThenBranch.Accept(c); err != nil {
+		return err
+	}
+	elseJump := c.emitJump(OP_JUMP)
+	if err := c.patchJump(thenJump); err != nil {
+		return err
+	}
+	c.emit(OP_POP)
+	if stmt.ElseBranch != nil {
+		if err := stmt.ElseBranch.Accept(c); err != nil {
+			return err
+		}
+	}
+	return c.patchJump(elseJump)
+}
+
+func (c *compiler) VisitWhile(stmt *ast.While) error {
+	start := len(c.chunk().Code)
+	if err := stmt.Condition.Accept(c); err != nil {
+		return err
+	}
+	exitJump := c.emitJump(OP_JUMP_IF_FALSE)
+	c.emit(OP_POP)
+
+	lp := &loop{depth: c.scopeDepth, tries: len(c.tries)}
+	c.loops = append(c.loops, lp)
+	if err := stmt.Do.Accept(c); err != nil {
+		return err
+	}
+	c.loops = c.loops[:len(c.loops)-1]
+
+	for _, j := range lp.continues {
+		if err := c.patchJump(j); err != nil {
+			return err
+		}
+	}
+	if stmt.Increment != nil {
+		if err := stmt.Increment.Accept(c); err != nil {
+			return err
+		}
+		c.emit(OP_POP)
+	}
+	c.token = stmt.Keyword
+	if err := c.emitLoop(start); err != nil {
+		return err
+	}
+	if err := c.patchJump(exitJump); err != nil {
+		return err
+	}
+	c.emit(OP_POP)
+	// `break` jumps past the pop, the condition
+	// was already discarded when the body started.
+	for _, j := range lp.breaks {
+		if err := c.patchJump(j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *compiler) VisitBreak(stmt *ast.Break) error {
+	c.token = stmt.Keyword
+	if len(c.loops) == 0 {
+		return stmt.Keyword.MakeError("break outside of a loop")
+	}
+	lp := c.loops[len(c.loops)-1]
+	if err := c.exitTries(lp.tries); err != nil {
+		return err
+	}
+	// Discard the locals of the loop body without
+	// forgetting them, the code after this still uses them.
+	for i := len(c.locals) - 1; i >= 0 && c.locals[i].depth > lp.depth; i-- {
+		c.discardLocal(c.locals[i])
+	}
+	jump := c.emitJump(OP_JUMP)
+	if stmt.Continue {
+		lp.continues = append(lp.continues, jump)
+	} else {
+		lp.breaks = append(lp.breaks, jump)
+	}
+	return nil
+}
+
+func (c *compiler) VisitFunction(stmt *ast.Function) error {
+	if err := c.declareVariable(stmt.Name); err != nil {
+		return err
+	}
+	// Functions may refer to themselves, so the
+	// name is usable before the body is compiled.
+	c.markInitialized()
+	if err := c.function(stmt, FUNCTIONTYPE_FUNCTION); err != nil {
+		return err
+	}
+	return c.defineVariable(stmt.Name)
+}
+
+func (c *compiler) VisitLambda(expr *ast.Lambda) error {
+	return c.function(expr.Function, FUNCTIONTYPE_FUNCTION)
+}
+
+func (c *compiler) VisitReturn(stmt *ast.Return) error {
+	if c.kind == FUNCTIONTYPE_SCRIPT {
+		return stmt.Token.MakeError("return outside a function or method")
+	}
+	if stmt.Expression != nil {
+		if err := stmt.Expression.Accept(c); err != nil {
+			return err
+		}
+	} else {
+		c.emit(OP_NIL)
+	}
+	c.token = stmt.Token
+	if len(c.tries) == 0 {
+		c.emit(OP_RETURN)
+		return nil
+	}
+	// Keep the return value in a slot of its own
+	// while the pending finally blocks run.
+	c.beginScope()
+	defer c.dropScope()
+	slot, err := c.addHiddenLocal()
+	if err != nil {
+		return err
+	}
+	if err := c.exitTries(0); err != nil {
+		return err
+	}
+	c.token = stmt.Token
+	c.emitByte(OP_GET_LOCAL, slot)
+	c.emit(OP_RETURN)
+	return nil
+}
+
+// exitTries emits the code for jumping out of the try statements
+// from the innermost one down to c.tries[from], uninstalling their
+// handlers and running their finally blocks on the way.
+func (c *compiler) exitTries(from int) error {
+	tries := c.tries
+	defer func() { c.tries = tries }()
+	for i := len(tries) - 1; i >= from; i-- {
+		c.tries = tries[:i]
+		if tries[i].handler {
+			c.emit(OP_END_TRY)
+		}
+		if tries[i].finally != nil {
+			if err := tries[i].finally.Accept(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *compiler) VisitThrow(stmt *ast.Throw) error {
+	if err := stmt.Expression.Accept(c); err != nil {
+		return err
+	}
+	c.token = stmt.Keyword
+	c.emit(OP_THROW)
+	return nil
+}
+
+/*
+VisitTry lays a try statement out as below. Each path out of the
+statement runs the finally block, which is compiled once per path.
+
+	    OP_TRY catch
+	    <body>
+	    OP_END_TRY
+	    <finally>
+	    OP_JUMP end
+	catch:                  ; the caught value is in a new local
+	    OP_TRY rethrow      ; only with a finally block
+	    <catch body>
+	    OP_END_TRY
+	    <finally>
+	    OP_JUMP end
+	rethrow:
+	    <finally>
+	    OP_THROW            ; whatever the catch body threw
+	end:
+
+Without a catch clause, the code at "catch" is the rethrow path.
+*/
+func (c *compiler) VisitTry(stmt *ast.Try) error {
+	tc := &tryContext{finally: stmt.Finally, handler: true}
+	c.token = stmt.Keyword
+	c.tries = append(c.tries, tc)
+	handlerJump := c.emitJump(OP_TRY)
+	if err := stmt.Body.Accept(c); err != nil {
+		return err
+	}
+	c.token = stmt.Keyword
+	c.emit(OP_END_TRY)
+	c.tries = c.tries[:len(c.tries)-1]
+	if err := c.finally(stmt); err != nil {
+		return err
+	}
+	endJump := c.emitJump(OP_JUMP)
+	if err := c.patchJump(handlerJump); err != nil {
+		return err
+	}
+
+	if stmt.Catch == nil {
+		if err := c.rethrowAfterFinally(stmt, 0); err != nil {
+			return err
+		}
+		return c.patchJump(endJump)
+	}
+
+	c.beginScope()
+	if err := c.addLocal(stmt.CatchName); err != nil {
+		return err
+	}
+	c.markInitialized()
+	var rethrowJump int
+	if stmt.Finally != nil {
+		c.tries = append(c.tries, tc)
+		rethrowJump = c.emitJump(OP_TRY)
+	}
+	for _, s := range stmt.Catch.Statements {
+		if err := s.Accept(c); err != nil {
+			return err
+		}
+	}
+	c.token = stmt.Keyword
+	if stmt.Finally != nil {
+		c.emit(OP_END_TRY)
+		c.tries = c.tries[:len(c.tries)-1]
+	}
+	c.endScope()
+	if stmt.Finally == nil {
+		return c.patchJump(endJump)
+	}
+
+	if err := c.finally(stmt); err != nil {
+		return err
+	}
+	catchEndJump := c.emitJump(OP_JUMP)
+	if err := c.patchJump(rethrowJump); err != nil {
+		return err
+	}
+	// The catch variable is still on the stack below the new exception.
+	if err := c.rethrowAfterFinally(stmt, 1); err != nil {
+		return err
+	}
+	if err := c.patchJump(catchEndJump); err != nil {
+		return err
+	}
+	return c.patchJump(endJump)
+}
+
+func (c *compiler) finally(stmt *ast.Try) error {
+	if stmt.Finally == nil {
+		return nil
+	}
+	return stmt.Finally.Accept(c)
+}
+
+// rethrowAfterFinally compiles the handler that runs the finally
+// block and throws the caught value on top of the stack again.
+// Below it on the stack are `below` values left by the catch clause.
+func (c *compiler) rethrowAfterFinally(stmt *ast.Try, below int) error {
+	c.beginScope()
+	defer c.dropScope()
+	var slot byte
+	for i := 0; i <= below; i++ {
+		var err error
+		if slot, err = c.addHiddenLocal(); err != nil {
+			return err
+		}
+	}
+	if err := c.finally(stmt); err != nil {
+		return err
+	}
+	c.token = stmt.Keyword
+	c.emitByte(OP_GET_LOCAL, slot)
+	c.emit(OP_THROW)
+	return nil
+}
+
+func (c *compiler) VisitClass(stmt *ast.Class) error {
+	c.token = stmt.Name
+	if err := c.declareVariable(stmt.Name); err != nil {
+		return err
+	}
+	if err := c.emitConstant(OP_CLASS, stmt.Name.Lexeme); err != nil {
+		return err
+	}
+	if err := c.defineVariable(stmt.Name); err != nil {
+		return err
+	}
+
+	// Like the tree walker, methods of a subclass are closed
+	// over a scope holding "super", here as a local slot.
+	if stmt.Superclass != nil {
+		if err := c.namedVariable(stmt.Superclass.Name, false); err != nil {
+			return err
+		}
+		c.beginScope()
+		if err := c.addLocal(lexer.Token{Type: lexer.SUPER, Lexeme: "super", Line: stmt.Name.Line}); err != nil {
+			return err
+		}
+		c.markInitialized()
+		if err := c.namedVariable(stmt.Name, false); err != nil {
+			return err
+		}
+		c.token = stmt.Superclass.Name
+		c.emit(OP_INHERIT)
+	}
+
+	if err := c.namedVariable(stmt.Name, false); err != nil {
+		return err
+	}
+	for _, method := range stmt.Methods {
+		if err := c.function(method, FUNCTIONTYPE_METHOD); err != nil {
+			return err
+		}
+		if err := c.emitConstant(OP_METHOD, method.Name.Lexeme); err != nil {
+			return err
+		}
+	}
+	c.emit(OP_POP)
+
+	if stmt.Superclass != nil {
+		c.endScope()
+	}
+	return nil
+}
+
+// ---------------- Expressions ----------------
+
+func (c *compiler) VisitLiteral(expr *ast.Literal) error {
+	switch expr.Value {
+	case nil:
+		c.emit(OP_NIL)
+	case true:
+		c.emit(OP_TRUE)
+	case false:
+		c.emit(OP_FALSE)
+	default:
+		return c.emitConstant(OP_CONSTANT, expr.Value)
+	}
+	return nil
+}
+
+func (c *compiler) VisitGrouping(expr *ast.Grouping) error {
+	return expr.Expression.Accept(c)
+}
+
+func (c *compiler) VisitVariable(expr *ast.Variable) error {
+	return c.namedVariable(expr.Name, false)
+}
+
+func (c *compiler) VisitAssignment(expr *ast.Assignment) error {
+	if err := expr.Value.Accept(c); err != nil {
+		return err
+	}
+	return c.namedVariable(expr.Name, true)
+}
+
+func (c *compiler) VisitThis(expr *ast.This) error {
+	return c.namedVariable(expr.Keyword, false)
+}
+
+func (c *compiler) VisitSuper(expr *ast.Super) error {
+	if err := c.namedVariable(lexer.Token{Type: lexer.THIS, Lexeme: "this", Line: expr.Keyword.Line}, false); err != nil {
+		return err
+	}
+	if err := c.namedVariable(expr.Keyword, false); err != nil {
+		return err
+	}
+	c.token = expr.Method
+	return c.emitConstant(OP_GET_SUPER, expr.Method.Lexeme)
+}
+
+func (c *compiler) VisitUnary(expr *ast.Unary) error {
+	if err := expr.Right.Accept(c); err != nil {
+		return err
+	}
+	c.token = expr.Operator
+	switch expr.Operator.Type {
+	case lexer.BANG:
+		c.emit(OP_NOT)
+	case lexer.MINUS:
+		c.emit(OP_NEGATE)
+	}
+	return nil
+}
+
+var binaryOps = map[lexer.TokenType]OpCode{
+	lexer.DOUBLE_EQUAL: OP_EQUAL,
+	lexer.BANG_EQUAL:   OP_NOT_EQUAL,
+	lexer.GT:           OP_GREATER,
+	lexer.GTE:          OP_GREATER_EQUAL,
+	lexer.LT:           OP_LESS,
+	lexer.LTE:          OP_LESS_EQUAL,
+	lexer.PLUS:         OP_ADD,
+	lexer.MINUS:        OP_SUBTRACT,
+	lexer.STAR:         OP_MULTIPLY,
+	lexer.SLASH:        OP_DIVIDE,
+}
+
+func (c *compiler) VisitBinary(expr *ast.Binary) error {
+	if err := expr.Left.Accept(c); err != nil {
+		return err
+	}
+	if err := expr.Right.Accept(c); err != nil {
+		return err
+	}
+	c.token = expr.Operator
+	op, ok := binaryOps[expr.Operator.Type]
+	if !ok {
+		return expr.Operator.MakeError("unknown binary operator")
+	}
+	c.emit(op)
+	return nil
+}
+
+// VisitLogical short circuits the same way the tree walker does,
+// producing `true` or `false` when the right operand is skipped.
+func (c *compiler) VisitLogical(expr *ast.Logical) error {
+	if err := expr.Left.Accept(c); err != nil {
+		return err
+	}
+	c.token = expr.Operator
+	rightJump := c.emitJump(OP_JUMP_IF_FALSE)
+	if expr.Operator.Type == lexer.OR {
+		c.emit(OP_POP)
+		c.emit(OP_TRUE)
+		endJump := c.emitJump(OP_JUMP)
+		if err := c.patchJump(rightJump); err != nil {
+			return err
+		}
+		c.emit(OP_POP)
+		if err := expr.Right.Accept(c); err != nil {
+			return err
+		}
+		return c.patchJump(endJump)
+	}
+	c.emit(OP_POP)
+	if err := expr.Right.Accept(c); err != nil {
+		return err
+	}
+	endJump := c.emitJump(OP_JUMP)
+	if err := c.patchJump(rightJump); err != nil {
+		return err
+	}
+	c.emit(OP_POP)
+	c.emit(OP_FALSE)
+	return c.patchJump(endJump)
+}
+
+func (c *compiler) VisitCall(expr *ast.Call) error {
+	if err := expr.Callee.Accept(c); err != nil {
+		return err
+	}
+	if len(expr.Args) > math.MaxUint8 {
+		return expr.ClosingParen.MakeError("can't have more than 255 args")
+	}
+	for _, a := range expr.Args {
+		if err := a.Accept(c); err != nil {
+			return err
+		}
+	}
+	c.token = expr.ClosingParen
+	c.emitByte(OP_CALL, byte(len(expr.Args)))
+	return nil
+}
+
+func (c *compiler) VisitGet(expr *ast.Get) error {
+	if err := expr.Object.Accept(c); err != nil {
+		return err
+	}
+	c.token = expr.Name
+	return c.emitConstant(OP_GET_PROPERTY, expr.Name.Lexeme)
+}
+
+func (c *compiler) VisitSet(expr *ast.Set) error {
+	if err := expr.Object.Accept(c); err != nil {
+		return err
+	}
+	if err := expr.Value.Accept(c); err != nil {
+		return err
+	}
+	c.token = expr.Name
+	return c.emitConstant(OP_SET_PROPERTY, expr.Name.Lexeme)
+}
+
+func (c *compiler) VisitIndex(expr *ast.Index) error {
+	if err := expr.Object.Accept(c); err != nil {
+		return err
+	}
+	if err := expr.Index.Accept(c); err != nil {
+		return err
+	}
+	c.token = expr.Bracket
+	c.emit(OP_GET_INDEX)
+	return nil
+}
+
+func (c *compiler) VisitIndexSet(expr *ast.IndexSet) error {
+	if err := expr.Object.Accept(c); err != nil {
+		return err
+	}
+	if err := expr.Index.Accept(c); err != nil {
+		return err
+	}
+	if err := expr.Value.Accept(c); err != nil {
+		return err
+	}
+	c.token = expr.Bracket
+	c.emit(OP_SET_INDEX)
+	return nil
+}
+
+func (c *compiler) VisitList(expr *ast.List) error {
+	if len(expr.Elements) > math.MaxUint16 {
+		return expr.Bracket.MakeError("too many elements in list literal")
+	}
+	for _, e := range expr.Elements {
+		if err := e.Accept(c); err != nil {
+			return err
+		}
+	}
+	c.token = expr.Bracket
+	c.emitU16(OP_LIST, uint16(len(expr.Elements)))
+	return nil
+}
+
+func (c *compiler) VisitMap(expr *ast.Map) error {
+	if len(expr.Keys) > math.MaxUint16 {
+		return expr.Brace.MakeError("too many entries in map literal")
+	}
+	for i, k := range expr.Keys {
+		if err := k.Accept(c); err != nil {
+			return err
+		}
+		if err := expr.Values[i].Accept(c); err != nil {
+			return err
+		}
+	}
+	c.token = expr.Brace
+	c.emitU16(OP_MAP, uint16(len(expr.Keys)))
+	return nil
+}
@@ -0,0 +1,77 @@
+package vm
+
+import (
+	"glox/lexer"
+	"glox/parser"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustCompile(t *testing.T, src string) *Function {
+	toks, err := lexer.ScanSource(src)
+	assert.NoError(t, err)
+	stmts, err := parser.Parse(toks)
+	assert.NoError(t, err)
+	fn, err := Compile(stmts)
+	assert.NoError(t, err)
+	return fn
+}
+
+func disassembly(fn *Function) string {
+	var sb strings.Builder
+	fn.Chunk.Disassemble(&sb, fn.String())
+	return sb.String()
+}
+
+func TestCompile_Globals(t *testing.T) {
+	dis := disassembly(mustCompile(t, "var a = 1; print a;"))
+	assert.Contains(t, dis, "OP_DEFINE_GLOBAL")
+	assert.Contains(t, dis, "OP_GET_GLOBAL")
+	assert.NotContains(t, dis, "OP_GET_LOCAL")
+}
+
+func TestCompile_LocalsUseSlots(t *testing.T) {
+	dis := disassembly(mustCompile(t, "{ var a = 1; var b = 2; print b; }"))
+	assert.Contains(t, dis, "OP_GET_LOCAL        2")
+	assert.NotContains(t, dis, "GLOBAL")
+}
+
+func TestCompile_Upvalues(t *testing.T) {
+	fn := mustCompile(t, `
+	fun outer() {
+		var x = 1;
+		fun middle() {
+			fun inner() { return x; }
+			return inner;
+		}
+		return middle;
+	}`)
+	outer := fn.Chunk.Constants[0].(*Function)
+	assert.Equal(t, "outer", outer.Name)
+	assert.Equal(t, 0, outer.UpvalueCount)
+
+	var middle, inner *Function
+	for _, k := range outer.Chunk.Constants {
+		if f, ok := k.(*Function); ok {
+			middle = f
+		}
+	}
+	for _, k := range middle.Chunk.Constants {
+		if f, ok := k.(*Function); ok {
+			inner = f
+		}
+	}
+	// inner reaches x through middle, which captures it from outer.
+	assert.Equal(t, 1, middle.UpvalueCount)
+	assert.Equal(t, 1, inner.UpvalueCount)
+	assert.Contains(t, disassembly(outer), "local 1")
+}
+
+func TestCompile_ReturnAtTopLevel(t *testing.T) {
+	toks, _ := lexer.ScanSource("return 3;")
+	stmts, _ := parser.Parse(toks)
+	_, err := Compile(stmts)
+	assert.Error(t, err)
+}
@@ -0,0 +1,115 @@
+package vm
+
+import (
+	"fmt"
+	"glox/runtime"
+)
+
+func init() {
+	runtime.RegisterValues(Function{})
+}
+
+// Function is a compiled lox function. Functions are created by
+// the compiler and only become callable once wrapped in a Closure.
+type Function struct {
+	Name string
+	// Line the function was declared on.
+	Line         int
+	Arity        int
+	UpvalueCount int
+	Chunk        Chunk
+}
+
+func (fn *Function) String() string {
+	if fn.Name == "" {
+		return "<script>"
+	}
+	return fmt.Sprintf("<fun %s>", fn.Name)
+}
+
+// Upvalue is a variable captured by a closure. While the variable's
+// frame is live the upvalue is "open", and refers to its slot on the
+// VM stack; once the frame exits the value is moved into Closed.
+type Upvalue struct {
+	Closed any
+	// The VM stack and slot of the captured variable,
+	// stack is nil once the upvalue is closed.
+	stack *[]any
+	slot  int
+	next  *Upvalue
+}
+
+func (uv *Upvalue) Get() any {
+	if uv.stack != nil {
+		return (*uv.stack)[uv.slot]
+	}
+	return uv.Closed
+}
+
+func (uv *Upvalue) Set(val any) {
+	if uv.stack != nil {
+		(*uv.stack)[uv.slot] = val
+	} else {
+		uv.Closed = val
+	}
+}
+
+type Closure struct {
+	Fn       *Function
+	Upvalues []*Upvalue
+	// Globals of the module the closure was created in.
+	Globals *runtime.Environment
+}
+
+func (c *Closure) String() string {
+	return c.Fn.String()
+}
+
+type Class struct {
+	Name    string
+	Methods map[string]*Closure
+}
+
+func (cls *Class) String() string {
+	return fmt.Sprintf("<class '%s'>", cls.Name)
+}
+
+type Instance struct {
+	Cls    *Class
+	fields map[string]any
+}
+
+func NewInstance(cls *Class) *Instance {
+	return &Instance{Cls: cls, fields: make(map[string]any)}
+}
+
+func (inst *Instance) String() string {
+	return fmt.Sprintf("<instance '%s'>", inst.Cls.Name)
+}
+
+// Get implements runtime.Object, so instances support
+// the same property lookup as the tree walker's.
+func (inst *Instance) Get(name string) (any, bool) {
+	if val, ok := inst.fields[name]; ok {
+		return val, true
+	}
+	if method, ok := inst.Cls.Methods[name]; ok {
+		return &BoundMethod{Receiver: inst, Method: method}, true
+	}
+	return nil, false
+}
+
+func (inst *Instance) Set(name string, value any) {
+	inst.fields[name] = value
+}
+
+// BoundMethod is a method closure paired with the
+// instance it was accessed from.
+type BoundMethod struct {
+	Receiver any
+	Method   *Closure
+}
+
+func (bm *BoundMethod) String() string {
+	return bm.Method.String()
+}
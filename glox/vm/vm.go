@@ -0,0 +1,627 @@
+package vm
+
+import (
+	"fmt"
+	"glox/ast"
+	"glox/errors"
+	"glox/lexer"
+	"glox/runtime"
+)
+
+type CallFrame struct {
+	closure *Closure
+	ip      int
+	// Index of the frame's slot 0 on the VM stack.
+	base int
+	// Set for frames running a class's init method, which
+	// produce the new instance instead of their return value.
+	initializer bool
+}
+
+// handler is where execution resumes when a value is thrown.
+type handler struct {
+	// Number of frames live, and height of the stack,
+	// when the handler was installed.
+	frames int
+	sp     int
+	// Position of the handler code in the installing frame.
+	ip int
+}
+
+// VM executes compiled lox code. Globals live in the interpreter's
+// Environment like they do for the tree walker, so natives declared
+// by runtime are available, and values persist between programs.
+type VM struct {
+	lox *runtime.Lox
+
+	frames []CallFrame
+	stack  []any
+	sp     int
+	// Linked list of open upvalues, sorted
+	// by stack slot, highest slot first.
+	openUpvalues *Upvalue
+	// Exception handlers installed by OP_TRY, innermost last.
+	handlers []handler
+
+	// Natives are written against the tree walker,
+	// this is the evaluator handed to them.
+	te     *runtime.TreeEvaluator
+	result any
+}
+
+func New() *VM {
+	return &VM{
+		frames: make([]CallFrame, 0, runtime.DefaultMaxDepth),
+		stack:  make([]any, 0, 256),
+	}
+}
+
+// Execute compiles and runs a resolved program, returning the value
+// of the last top level expression statement. Execute implements
+// runtime.Backend, so a VM can stand in for the tree walker.
+func (vm *VM) Execute(l *runtime.Lox, stmts []ast.Stmt) (any, error) {
+	if len(vm.frames) > 0 {
+		// Already running a program, which is importing a module.
+		return New().Execute(l, stmts)
+	}
+	fn, err := Compile(stmts)
+	if err != nil {
+		return nil, err
+	}
+	vm.lox = l
+	vm.te = l.Evaluator()
+	vm.result = nil
+	script := &Closure{Fn: fn, Globals: l.Globals}
+	vm.push(script)
+	if err := vm.call(script, 0, lexer.Token{}); err != nil {
+		vm.reset()
+		return nil, err
+	}
+	if err := vm.run(); err != nil {
+		vm.reset()
+		return nil, err
+	}
+	vm.pop()
+	return vm.result, nil
+}
+
+// Arity implements runtime.Caller.
+func (vm *VM) Arity(callee any) (int, bool) {
+	switch f := callee.(type) {
+	case *Closure:
+		return f.Fn.Arity, true
+	case *BoundMethod:
+		return f.Method.Fn.Arity, true
+	case *Class:
+		if init, ok := f.Methods["init"]; ok {
+			return init.Fn.Arity, true
+		}
+		return 0, true
+	case runtime.Callable:
+		return f.Arity(), true
+	}
+	return 0, false
+}
+
+// Call implements runtime.Caller, so Go code can call
+// functions created by programs run on the VM.
+func (vm *VM) Call(l *runtime.Lox, callee any, args []any) (any, error) {
+	if len(vm.frames) > 0 {
+		return New().Call(l, callee, args)
+	}
+	vm.lox = l
+	vm.te = l.Evaluator()
+	vm.push(callee)
+	for _, arg := range args {
+		vm.push(arg)
+	}
+	if err := vm.callValue(callee, len(args), lexer.Token{}); err != nil {
+		vm.reset()
+		return nil, err
+	}
+	// Natives have already run, other callees have a frame to run.
+	if len(vm.frames) > 0 {
+		if err := vm.run(); err != nil {
+			vm.reset()
+			return nil, err
+		}
+	}
+	return vm.pop(), nil
+}
+
+func (vm *VM) reset() {
+	vm.frames = vm.frames[:0]
+	for vm.sp > 0 {
+		vm.pop()
+	}
+	vm.openUpvalues = nil
+	vm.handlers = vm.handlers[:0]
+}
+
+// ---------------- Stack ----------------
+
+func (vm *VM) push(val any) {
+	if vm.sp == len(vm.stack) {
+		vm.stack = append(vm.stack, val)
+	} else {
+		vm.stack[vm.sp] = val
+	}
+	vm.sp++
+}
+
+func (vm *VM) pop() any {
+	vm.sp--
+	val := vm.stack[vm.sp]
+	vm.stack[vm.sp] = nil
+	return val
+}
+
+func (vm *VM) peek(distance int) any {
+	return vm.stack[vm.sp-1-distance]
+}
+
+// ---------------- Calls ----------------
+
+// call pushes a frame for closure, whose arguments are the
+// top argc values of the stack.
+func (vm *VM) call(closure *Closure, argc int, tok lexer.Token) error {
+	if argc != closure.Fn.Arity {
+		return tok.MakeError(fmt.Sprintf("Expect %d args, found %d", closure.Fn.Arity, argc))
+	}
+	// The frame of the script doesn't count towards the depth.
+	if len(vm.frames)-1 >= vm.te.Meter.MaxDepth() {
+		return tok.MakeError("stack overflow")
+	}
+	vm.frames = append(vm.frames, CallFrame{
+		closure: closure,
+		base:    vm.sp - argc - 1,
+	})
+	return nil
+}
+
+func (vm *VM) callValue(callee any, argc int, tok lexer.Token) error {
+	switch f := callee.(type) {
+	case *Closure:
+		return vm.call(f, argc, tok)
+	case *BoundMethod:
+		vm.stack[vm.sp-argc-1] = f.Receiver
+		return vm.call(f.Method, argc, tok)
+	case *Class:
+		inst := NewInstance(f)
+		vm.stack[vm.sp-argc-1] = inst
+		init, ok := f.Methods["init"]
+		if !ok {
+			if argc != 0 {
+				return tok.MakeError(fmt.Sprintf("Expect 0 args, found %d", argc))
+			}
+			return nil
+		}
+		if err := vm.call(init, argc, tok); err != nil {
+			return err
+		}
+		vm.frames[len(vm.frames)-1].initializer = true
+		return nil
+	case runtime.Callable:
+		if argc != f.Arity() {
+			return tok.MakeError(fmt.Sprintf("Expect %d args, found %d", f.Arity(), argc))
+		}
+		args := make([]any, argc)
+		copy(args, vm.stack[vm.sp-argc:vm.sp])
+		result, err := f.Call(vm.te, args)
+		if err != nil {
+			return runtime.NativeError(err, tok)
+		}
+		for i := 0; i <= argc; i++ {
+			vm.pop()
+		}
+		vm.push(result)
+		return nil
+	}
+	return tok.MakeError("can only call functions and classes")
+}
+
+// ---------------- Upvalues ----------------
+
+func (vm *VM) captureUpvalue(slot int) *Upvalue {
+	var prev *Upvalue
+	uv := vm.openUpvalues
+	for uv != nil && uv.slot > slot {
+		prev = uv
+		uv = uv.next
+	}
+	if uv != nil && uv.slot == slot {
+		return uv
+	}
+	created := &Upvalue{stack: &vm.stack, slot: slot, next: uv}
+	if prev == nil {
+		vm.openUpvalues = created
+	} else {
+		prev.next = created
+	}
+	return created
+}
+
+// closeUpvalues moves every captured variable at or
+// above the given stack slot off of the stack.
+func (vm *VM) closeUpvalues(last int) {
+	for vm.openUpvalues != nil && vm.openUpvalues.slot >= last {
+		uv := vm.openUpvalues
+		uv.Closed = uv.Get()
+		uv.stack = nil
+		vm.openUpvalues = uv.next
+	}
+}
+
+// ---------------- Execution ----------------
+
+// run executes code until the script returns. Errors that can be
+// caught by lox code unwind to the innermost installed handler.
+func (vm *VM) run() error {
+	for {
+		err := vm.execute()
+		if err == nil {
+			return nil
+		}
+		runtime.AttachTrace(err, vm.trace())
+		if len(vm.handlers) == 0 {
+			return err
+		}
+		val, ok := runtime.CaughtValue(err)
+		if !ok {
+			return err
+		}
+		h := vm.handlers[len(vm.handlers)-1]
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		vm.frames = vm.frames[:h.frames]
+		vm.closeUpvalues(h.sp)
+		for vm.sp > h.sp {
+			vm.pop()
+		}
+		vm.push(val)
+		vm.frames[h.frames-1].ip = h.ip
+	}
+}
+
+// trace returns the function calls in progress, leaving
+// out the frame of the script that made the first call.
+func (vm *VM) trace() []errors.Frame {
+	var trace []errors.Frame
+	for i, frame := range vm.frames {
+		fn := frame.closure.Fn
+		if fn.Name == "" {
+			continue
+		}
+		// Functions called from Go have no call site.
+		line := 0
+		if i > 0 {
+			caller := vm.frames[i-1]
+			line = caller.closure.Fn.Chunk.Tokens[caller.ip-1].Line
+		}
+		trace = append(trace, errors.Frame{Function: fn.Name, Line: line, DeclLine: fn.Line})
+	}
+	return trace
+}
+
+func (vm *VM) execute() error {
+	frame := &vm.frames[len(vm.frames)-1]
+	chunk := &frame.closure.Fn.Chunk
+
+	readByte := func() byte {
+		b := chunk.Code[frame.ip]
+		frame.ip++
+		return b
+	}
+	readU16 := func() uint16 {
+		v := chunk.ReadU16(frame.ip)
+		frame.ip += 2
+		return v
+	}
+	readString := func() string {
+		return chunk.Constants[readU16()].(string)
+	}
+
+	for {
+		// The token of the instruction being executed, for errors.
+		tok := chunk.Tokens[frame.ip]
+		switch op := OpCode(readByte()); op {
+		case OP_CONSTANT:
+			vm.push(chunk.Constants[readU16()])
+		case OP_NIL:
+			vm.push(nil)
+		case OP_TRUE:
+			vm.push(true)
+		case OP_FALSE:
+			vm.push(false)
+		case OP_POP:
+			vm.pop()
+		case OP_RESULT:
+			vm.result = vm.pop()
+
+		case OP_GET_LOCAL:
+			vm.push(vm.stack[frame.base+int(readByte())])
+		case OP_SET_LOCAL:
+			vm.stack[frame.base+int(readByte())] = vm.peek(0)
+		case OP_DEFINE_GLOBAL:
+			frame.closure.Globals.Declare(readString(), vm.pop())
+		case OP_GET_GLOBAL:
+			val, ok := frame.closure.Globals.Get(readString())
+			if !ok {
+				return tok.MakeError("undefined variable")
+			}
+			vm.push(val)
+		case OP_SET_GLOBAL:
+			if !frame.closure.Globals.Assign(readString(), vm.peek(0)) {
+				return tok.MakeError("undefined variable")
+			}
+		case OP_GET_UPVALUE:
+			vm.push(frame.closure.Upvalues[readByte()].Get())
+		case OP_SET_UPVALUE:
+			frame.closure.Upvalues[readByte()].Set(vm.peek(0))
+		case OP_CLOSE_UPVALUE:
+			vm.closeUpvalues(vm.sp - 1)
+			vm.pop()
+
+		case OP_GET_PROPERTY:
+			name := readString()
+			obj, ok := vm.peek(0).(runtime.Object)
+			if !ok {
+				return tok.MakeError("only instances can have properties")
+			}
+			val, ok := obj.Get(name)
+			if !ok {
+				return tok.MakeError("undefined field")
+			}
+			vm.pop()
+			vm.push(val)
+		case OP_SET_PROPERTY:
+			name := readString()
+			value := vm.pop()
+			switch obj := vm.peek(0).(type) {
+			case *Instance:
+				obj.Set(name, value)
+			case *runtime.Proxy:
+				if err := obj.Set(name, value); err != nil {
+					return tok.MakeError(err.Error())
+				}
+			default:
+				return tok.MakeError("can't assign field to non-instance")
+			}
+			vm.pop()
+			vm.push(value)
+		case OP_GET_SUPER:
+			name := readString()
+			superclass, ok := vm.pop().(*Class)
+			if !ok {
+				return tok.MakeError("'super' isn't bound")
+			}
+			method, ok := superclass.Methods[name]
+			if !ok {
+				return tok.MakeError("undefined superclass method")
+			}
+			vm.push(&BoundMethod{Receiver: vm.pop(), Method: method})
+		case OP_GET_INDEX:
+			obj, ok := vm.peek(1).(runtime.Indexable)
+			if !ok {
+				return tok.MakeError(fmt.Sprintf("type %T can't be indexed", vm.peek(1)))
+			}
+			val, err := obj.GetIndex(vm.peek(0))
+			if err != nil {
+				return tok.MakeError(err.Error())
+			}
+			vm.pop()
+			vm.pop()
+			vm.push(val)
+		case OP_SET_INDEX:
+			obj, ok := vm.peek(2).(runtime.Indexable)
+			if !ok {
+				return tok.MakeError(fmt.Sprintf("type %T doesn't support index assignment", vm.peek(2)))
+			}
+			if err := vm.te.Meter.AllocEntry(obj, vm.peek(1), tok); err != nil {
+				return err
+			}
+			if err := obj.SetIndex(vm.peek(1), vm.peek(0)); err != nil {
+				return tok.MakeError(err.Error())
+			}
+			value := vm.pop()
+			vm.pop()
+			vm.pop()
+			vm.push(value)
+
+		case OP_EQUAL:
+			r, l := vm.pop(), vm.pop()
+			vm.push(runtime.Equality(l, r))
+		case OP_NOT_EQUAL:
+			r, l := vm.pop(), vm.pop()
+			vm.push(!runtime.Equality(l, r))
+		case OP_GREATER, OP_GREATER_EQUAL, OP_LESS, OP_LESS_EQUAL, OP_SUBTRACT, OP_MULTIPLY, OP_DIVIDE:
+			r, rok := vm.peek(0).(float64)
+			l, lok := vm.peek(1).(float64)
+			if !lok || !rok {
+				return tok.MakeError(fmt.Sprintf("operator '%s' requires numbers", tok.Lexeme))
+			}
+			vm.pop()
+			vm.pop()
+			switch op {
+			case OP_GREATER:
+				vm.push(l > r)
+			case OP_GREATER_EQUAL:
+				vm.push(l >= r)
+			case OP_LESS:
+				vm.push(l < r)
+			case OP_LESS_EQUAL:
+				vm.push(l <= r)
+			case OP_SUBTRACT:
+				vm.push(l - r)
+			case OP_MULTIPLY:
+				vm.push(l * r)
+			case OP_DIVIDE:
+				if r == 0. {
+					return tok.MakeError("divide by 0")
+				}
+				vm.push(l / r)
+			}
+		case OP_ADD:
+			right, left := vm.peek(0), vm.peek(1)
+			switch l := left.(type) {
+			case float64:
+				r, ok := right.(float64)
+				if !ok {
+					return tok.MakeError(fmt.Sprintf("type %T doesn't support addition", right))
+				}
+				vm.pop()
+				vm.pop()
+				vm.push(l + r)
+			case string:
+				r, ok := right.(string)
+				if !ok {
+					return tok.MakeError(fmt.Sprintf("type %T doesn't support addition", right))
+				}
+				if err := vm.te.Meter.Alloc(len(l)+len(r), tok); err != nil {
+					return err
+				}
+				vm.pop()
+				vm.pop()
+				vm.push(l + r)
+			default:
+				return tok.MakeError(fmt.Sprintf("type %T doesn't support addition", left))
+			}
+		case OP_NOT:
+			vm.push(!runtime.Truthy(vm.pop()))
+		case OP_NEGATE:
+			v, ok := vm.peek(0).(float64)
+			if !ok {
+				return tok.MakeError(fmt.Sprintf("can't negate a non-float type: %T", vm.peek(0)))
+			}
+			vm.pop()
+			vm.push(-v)
+
+		case OP_PRINT:
+			vm.result = vm.pop()
+			fmt.Fprintf(vm.te.Stdout, "%v\n", vm.result)
+
+		case OP_JUMP:
+			offset := int(readU16())
+			frame.ip += offset
+		case OP_JUMP_IF_FALSE:
+			offset := int(readU16())
+			if !runtime.Truthy(vm.peek(0)) {
+				frame.ip += offset
+			}
+		case OP_LOOP:
+			offset := int(readU16())
+			frame.ip -= offset
+			if err := vm.te.Meter.Step(tok); err != nil {
+				return err
+			}
+
+		case OP_CALL:
+			argc := int(readByte())
+			if err := vm.te.Meter.Step(tok); err != nil {
+				return err
+			}
+			if err := vm.callValue(vm.peek(argc), argc, tok); err != nil {
+				return err
+			}
+			frame = &vm.frames[len(vm.frames)-1]
+			chunk = &frame.closure.Fn.Chunk
+		case OP_CLOSURE:
+			fn := chunk.Constants[readU16()].(*Function)
+			closure := &Closure{
+				Fn:       fn,
+				Upvalues: make([]*Upvalue, fn.UpvalueCount),
+				Globals:  frame.closure.Globals,
+			}
+			for i := range closure.Upvalues {
+				isLocal, index := readByte(), int(readByte())
+				if isLocal == 1 {
+					closure.Upvalues[i] = vm.captureUpvalue(frame.base + index)
+				} else {
+					closure.Upvalues[i] = frame.closure.Upvalues[index]
+				}
+			}
+			vm.push(closure)
+		case OP_RETURN:
+			result := vm.pop()
+			if frame.initializer {
+				result = vm.stack[frame.base]
+			}
+			vm.closeUpvalues(frame.base)
+			for vm.sp > frame.base {
+				vm.pop()
+			}
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			vm.push(result)
+			if len(vm.frames) == 0 {
+				return nil
+			}
+			frame = &vm.frames[len(vm.frames)-1]
+			chunk = &frame.closure.Fn.Chunk
+
+		case OP_CLASS:
+			vm.push(&Class{Name: readString(), Methods: make(map[string]*Closure)})
+		case OP_INHERIT:
+			superclass, ok := vm.peek(1).(*Class)
+			if !ok {
+				return tok.MakeError("superclass must be a class")
+			}
+			subclass := vm.peek(0).(*Class)
+			for name, method := range superclass.Methods {
+				subclass.Methods[name] = method
+			}
+			vm.pop()
+		case OP_METHOD:
+			name := readString()
+			cls := vm.peek(1).(*Class)
+			cls.Methods[name] = vm.pop().(*Closure)
+
+		case OP_THROW:
+			return runtime.Throw(vm.pop(), tok)
+		case OP_TRY:
+			offset := int(readU16())
+			vm.handlers = append(vm.handlers, handler{
+				frames: len(vm.frames),
+				sp:     vm.sp,
+				ip:     frame.ip + offset,
+			})
+		case OP_END_TRY:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case OP_IMPORT:
+			m, err := vm.lox.Import(readString(), tok)
+			if err != nil {
+				return err
+			}
+			vm.push(m)
+
+		case OP_LIST:
+			n := int(readU16())
+			if err := vm.te.Meter.Alloc(n*runtime.ValueSize, tok); err != nil {
+				return err
+			}
+			elements := make([]any, n)
+			copy(elements, vm.stack[vm.sp-n:vm.sp])
+			for i := 0; i < n; i++ {
+				vm.pop()
+			}
+			vm.push(runtime.NewLoxList(elements))
+		case OP_MAP:
+			n := int(readU16())
+			if err := vm.te.Meter.Alloc(2*n*runtime.ValueSize, tok); err != nil {
+				return err
+			}
+			m := runtime.NewLoxMap()
+			for i := vm.sp - 2*n; i < vm.sp; i += 2 {
+				if err := m.SetIndex(vm.stack[i], vm.stack[i+1]); err != nil {
+					return tok.MakeError(err.Error())
+				}
+			}
+			for i := 0; i < 2*n; i++ {
+				vm.pop()
+			}
+			vm.push(m)
+
+		default:
+			return tok.MakeError(fmt.Sprintf("unknown opcode %s", op))
+		}
+	}
+}